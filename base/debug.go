@@ -0,0 +1,176 @@
+package base
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/metrics"
+	"github.com/GitHub121380/golib/ral"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultDebugPrefix = "/debug/golib"
+	// 应用配置目录下的调试接口配置文件
+	DebugConfFile = "debug.yaml"
+)
+
+// 调试接口配置，地址支持 IP 及 CIDR
+type DebugConf struct {
+	// 允许访问的客户端地址，为空时只允许本机访问
+	AllowList []string `yaml:"allowList"`
+	// 可信的代理地址，对端为可信代理时按 X-Forwarded-For、X-Real-IP 取客户端地址
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+var defaultDebugAllowList = []string{"127.0.0.0/8", "::1"}
+
+var debugAllow = struct {
+	sync.RWMutex
+	nets    []*net.IPNet
+	proxies []*net.IPNet
+}{nets: parseAllowList(defaultDebugAllowList...)}
+
+// 按配置设置调试接口的访问控制
+func InitDebug(conf DebugConf) {
+	list := conf.AllowList
+	if len(list) == 0 {
+		list = defaultDebugAllowList
+	}
+	nets, proxies := parseAllowList(list...), parseAllowList(conf.TrustedProxies...)
+	debugAllow.Lock()
+	debugAllow.nets = nets
+	debugAllow.proxies = proxies
+	debugAllow.Unlock()
+}
+
+// 设置允许访问调试接口的地址，支持 IP 及 CIDR
+func SetDebugAllowList(list ...string) {
+	nets := parseAllowList(list...)
+	debugAllow.Lock()
+	debugAllow.nets = nets
+	debugAllow.Unlock()
+}
+
+// 读取应用配置目录下的 DebugConfFile，文件不存在时使用默认配置
+func loadDebugConf() {
+	path := filepath.Join(env.GetConfDirPath(), env.SubConfApp, DebugConfFile)
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	var conf DebugConf
+	env.LoadConf(DebugConfFile, env.SubConfApp, &conf)
+	InitDebug(conf)
+}
+
+func parseAllowList(list ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, v := range list {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip == nil {
+				continue
+			} else if ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		if _, n, err := net.ParseCIDR(v); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回请求的客户端地址。只有对端为可信代理时才使用 X-Forwarded-For，从右向左
+// 跳过可信代理取第一个地址；没有 X-Forwarded-For 时使用 X-Real-IP
+func debugClientIP(c *gin.Context, proxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(proxies, ip) {
+		return ip
+	}
+
+	if xff := c.Request.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip = net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil || !containsIP(proxies, ip) {
+				return ip
+			}
+		}
+		return ip
+	}
+	if rip := c.Request.Header.Get("X-Real-IP"); rip != "" {
+		return net.ParseIP(strings.TrimSpace(rip))
+	}
+	// 可信代理自身发起的请求
+	return ip
+}
+
+func debugAllowed(c *gin.Context) bool {
+	debugAllow.RLock()
+	defer debugAllow.RUnlock()
+	ip := debugClientIP(c, debugAllow.proxies)
+	return ip != nil && containsIP(debugAllow.nets, ip)
+}
+
+func debugAuth(c *gin.Context) {
+	if !debugAllowed(c) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	c.Next()
+}
+
+// 注册调试接口，输出 ral 资源、实例、依赖关系、连接池及客户端状态，访问控制
+// 读取自 DebugConfFile。prefixOptions 为可选的路径前缀，默认为 DefaultDebugPrefix
+func RegisterDebug(r *gin.Engine, prefixOptions ...string) {
+	loadDebugConf()
+	prefix := DefaultDebugPrefix
+	if len(prefixOptions) > 0 {
+		prefix = prefixOptions[0]
+	}
+
+	g := r.Group(prefix, debugAuth)
+	{
+		g.GET("/", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"resources": ral.Resources(),
+				"dependons": ral.Dependons(),
+				"pools":     metrics.Pools(),
+				"clients":   metrics.Clients(),
+			})
+		})
+		g.GET("/ral", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"resources": ral.Resources(),
+				"dependons": ral.Dependons(),
+			})
+		})
+		g.GET("/pools", func(c *gin.Context) {
+			c.JSON(http.StatusOK, metrics.Pools())
+		})
+		g.GET("/clients", func(c *gin.Context) {
+			c.JSON(http.StatusOK, metrics.Clients())
+		})
+	}
+}
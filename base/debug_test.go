@@ -0,0 +1,54 @@
+package base
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugAllowed(t *testing.T) {
+	defer InitDebug(DebugConf{})
+	InitDebug(DebugConf{
+		AllowList:      []string{"10.0.0.0/8"},
+		TrustedProxies: []string{"127.0.0.1", "192.168.0.1"},
+	})
+
+	cases := []struct {
+		remote  string
+		headers map[string]string
+		allowed bool
+	}{
+		{"10.1.2.3:1234", nil, true},
+		{"11.1.2.3:1234", nil, false},
+		// 不可信的对端伪造 header
+		{"11.1.2.3:1234", map[string]string{"X-Forwarded-For": "10.1.2.3"}, false},
+		// 经过本机 sidecar 的外部请求
+		{"127.0.0.1:1234", map[string]string{"X-Forwarded-For": "11.1.2.3"}, false},
+		{"127.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.1.2.3"}, true},
+		// 跳过可信代理，客户端伪造的最左地址无效
+		{"127.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.9.9.9, 11.1.2.3, 192.168.0.1"}, false},
+		{"127.0.0.1:1234", map[string]string{"X-Forwarded-For": "11.1.2.3, 10.1.2.3, 192.168.0.1"}, true},
+		{"127.0.0.1:1234", map[string]string{"X-Real-IP": "10.1.2.3"}, true},
+		{"127.0.0.1:1234", nil, false},
+	}
+	for _, v := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, DefaultDebugPrefix, nil)
+		c.Request.RemoteAddr = v.remote
+		for k, h := range v.headers {
+			c.Request.Header.Set(k, h)
+		}
+		assert.Equal(t, v.allowed, debugAllowed(c), v.remote, v.headers)
+	}
+
+	// 默认只允许本机访问
+	InitDebug(DebugConf{})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, DefaultDebugPrefix, nil)
+	c.Request.RemoteAddr = "127.0.0.1:1234"
+	c.Request.Header.Set("X-Forwarded-For", "11.1.2.3")
+	assert.True(t, debugAllowed(c))
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/metrics"
+	"github.com/GitHub121380/golib/ral"
 	"github.com/GitHub121380/golib/utils"
 	"github.com/GitHub121380/golib/zlog"
 	"github.com/Shopify/sarama"
@@ -38,6 +40,7 @@ type KafkaProducerConfig struct {
 type KafkaPubClient struct {
 	Conf     KafkaProducerConfig
 	producer sarama.SyncProducer
+	version  sarama.KafkaVersion
 }
 
 type KafkaBody struct {
@@ -90,12 +93,20 @@ func InitKafkaPub(conf KafkaProducerConfig) *KafkaPubClient {
 	c := &KafkaPubClient{
 		Conf:     conf,
 		producer: producer,
+		version:  saramaConfig.Version,
 	}
+	metrics.RegisterClient("kafka", conf.Service, func() interface{} {
+		return map[string]interface{}{
+			"addr":    conf.Addr,
+			"version": c.version.String(),
+		}
+	})
 	return c
 }
 
 func (client *KafkaPubClient) CloseProducer() error {
 	if client.producer != nil {
+		metrics.UnregisterClient("kafka", client.Conf.Service)
 		return client.producer.Close()
 	}
 	return nil
@@ -114,10 +125,33 @@ func (client *KafkaPubClient) Pub(ctx *gin.Context, topic string, msg interface{
 		return err
 	}
 
+	// 压测流量发送到影子topic
+	topic = utils.ShadowName(ctx, topic)
+
+	span := zlog.StartSpan(ctx, "kafka:"+client.Conf.Service)
+	done := metrics.Client("kafka", client.Conf.Service, topic)
 	start := time.Now()
 	kafkaMsg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(body)}
+	// 消息头需要 kafka 0.11 及以上版本
+	if client.version.IsAtLeast(sarama.V0_11_0_0) {
+		kafkaMsg.Headers = KafkaTraceHeaders(span)
+		if color := ral.Color(ctx); color != "" {
+			kafkaMsg.Headers = append(kafkaMsg.Headers, sarama.RecordHeader{Key: []byte(ral.HEAD_COLOR), Value: []byte(color)})
+		}
+		if callerURI, _ := utils.GetPressureFlag(ctx); callerURI != "" {
+			kafkaMsg.Headers = append(kafkaMsg.Headers, sarama.RecordHeader{Key: []byte(utils.HttpXBDCallerURI), Value: []byte(callerURI)})
+		}
+	}
 	partition, offset, err := client.producer.SendMessage(kafkaMsg)
 	end := time.Now()
+	done(err)
+
+	span.SetTag("prot", "kafka")
+	span.SetTag("topic", topic)
+	span.SetTag("partition", partition)
+	span.SetTag("offset", offset)
+	span.SetError(err)
+	span.Finish()
 
 	ralCode := 0
 	infoMsg := "kafka pub success"
@@ -143,3 +177,15 @@ func (client *KafkaPubClient) Pub(ctx *gin.Context, topic string, msg interface{
 
 	return nil
 }
+
+// 将链路信息写入kafka消息头
+func KafkaTraceHeaders(span *zlog.Span) []sarama.RecordHeader {
+	head := map[string]string{}
+	span.Inject(head)
+
+	headers := make([]sarama.RecordHeader, 0, len(head))
+	for k, v := range head {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	return headers
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/metrics"
 	"github.com/GitHub121380/golib/utils"
 	"github.com/GitHub121380/golib/zlog"
 	"github.com/gin-gonic/gin"
@@ -13,6 +14,7 @@ import (
 	"go.uber.org/zap"
 	"reflect"
 	"regexp"
+	"strings"
 	"time"
 	"unicode"
 )
@@ -29,7 +31,9 @@ type MysqlConf struct {
 	ConnTimeOut     time.Duration `yaml:"connTimeOut"`
 	WriteTimeOut    time.Duration `yaml:"writeTimeOut"`
 	ReadTimeOut     time.Duration `yaml:"readTimeOut"`
-	LogMode         bool
+	// 压测流量使用的影子库，为空时压测流量访问线上库
+	ShadowDataBase string `yaml:"shadowDatabase"`
+	LogMode        bool
 }
 
 func (conf *MysqlConf) checkConf() {
@@ -153,7 +157,11 @@ func gormLogKeyValueFormatter(values ...interface{}) (msg string, fields []zap.F
 		var spanId string
 		var requestId string
 		var handler string
+		var press int
 		if len(values) >= 7 && values[6] != nil {
+			if ctx, ok := values[6].(*gin.Context); ok {
+				_, press = utils.GetPressureFlag(ctx)
+			}
 			logId, _ = values[6].(context.Context).Value("logID").(string)
 			spanId, _ = values[6].(context.Context).Value("spanId").(string)
 			requestId, _ = values[6].(context.Context).Value("requestId").(string)
@@ -164,6 +172,7 @@ func gormLogKeyValueFormatter(values ...interface{}) (msg string, fields []zap.F
 			zap.String("spanId", spanId),
 			zap.String("requestId", requestId),
 			zap.String("handler", handler),
+			zap.Int("press", press),
 			zap.String("sql", sql),
 			zap.Float64("cost", float64(values[2].(time.Duration).Nanoseconds()/1e4)/100.0),
 			zap.Int64("affectedrow", values[5].(int64)),
@@ -252,51 +261,57 @@ func InitMysqlClient(conf MysqlConf) (client *gorm.DB, err error) {
 	client.SetLogger(ormLogger)
 
 	// register tracer callback
-	setCallback(client, "create")
-	setCallback(client, "delete")
-	setCallback(client, "update")
-	setCallback(client, "query")
-	setCallback(client, "row_query")
+	setCallback(client, ormLogger.Service, conf.ShadowDataBase, "create")
+	setCallback(client, ormLogger.Service, conf.ShadowDataBase, "delete")
+	setCallback(client, ormLogger.Service, conf.ShadowDataBase, "update")
+	setCallback(client, ormLogger.Service, conf.ShadowDataBase, "query")
+	setCallback(client, ormLogger.Service, conf.ShadowDataBase, "row_query")
+
+	db := client.DB()
+	metrics.RegisterPool("mysql", ormLogger.Service, conf.Addr, func() metrics.PoolStats {
+		s := db.Stats()
+		return metrics.PoolStats{Active: s.OpenConnections, Idle: s.Idle}
+	})
 
 	return client, nil
 }
 
-func setCallback(client *gorm.DB, callbackName string) {
+func setCallback(client *gorm.DB, service string, shadow string, callbackName string) {
 	beforeName := fmt.Sprintf("tracer:%v_before", callbackName)
 	afterName := fmt.Sprintf("tracer:%v_after", callbackName)
 	gormCallbackName := fmt.Sprintf("gorm:%v", callbackName)
 	switch callbackName {
 	case "create":
 		client.Callback().Create().Before(gormCallbackName).Register(beforeName, func(scope *gorm.Scope) {
-			tracerBefore(scope, callbackName)
+			tracerBefore(scope, service, shadow, callbackName)
 		})
 		client.Callback().Create().After(gormCallbackName).Register(afterName, func(scope *gorm.Scope) {
 			tracerAfter(scope, callbackName)
 		})
 	case "query":
 		client.Callback().Query().Before(gormCallbackName).Register(beforeName, func(scope *gorm.Scope) {
-			tracerBefore(scope, callbackName)
+			tracerBefore(scope, service, shadow, callbackName)
 		})
 		client.Callback().Query().After(gormCallbackName).Register(afterName, func(scope *gorm.Scope) {
 			tracerAfter(scope, callbackName)
 		})
 	case "update":
 		client.Callback().Update().Before(gormCallbackName).Register(beforeName, func(scope *gorm.Scope) {
-			tracerBefore(scope, callbackName)
+			tracerBefore(scope, service, shadow, callbackName)
 		})
 		client.Callback().Update().After(gormCallbackName).Register(afterName, func(scope *gorm.Scope) {
 			tracerAfter(scope, callbackName)
 		})
 	case "delete":
 		client.Callback().Delete().Before(gormCallbackName).Register(beforeName, func(scope *gorm.Scope) {
-			tracerBefore(scope, callbackName)
+			tracerBefore(scope, service, shadow, callbackName)
 		})
 		client.Callback().Delete().After(gormCallbackName).Register(afterName, func(scope *gorm.Scope) {
 			tracerAfter(scope, callbackName)
 		})
 	case "row_query":
 		client.Callback().RowQuery().Before(gormCallbackName).Register(beforeName, func(scope *gorm.Scope) {
-			tracerBefore(scope, callbackName)
+			tracerBefore(scope, service, shadow, callbackName)
 		})
 		client.Callback().RowQuery().After(gormCallbackName).Register(afterName, func(scope *gorm.Scope) {
 			tracerAfter(scope, callbackName)
@@ -304,16 +319,56 @@ func setCallback(client *gorm.DB, callbackName string) {
 	}
 }
 
-func tracerBefore(scope *gorm.Scope, callbackName string) {
+const (
+	scopeKeySpan    = "tracer:span"
+	scopeKeyMetrics = "tracer:metrics"
+)
+
+func tracerBefore(scope *gorm.Scope, service string, shadow string, callbackName string) {
+	scope.InstanceSet(scopeKeyMetrics, metrics.Client("mysql", service, callbackName))
+
 	ctx, ok := scope.Search.GetCtx().(*gin.Context)
 	if !ok || ctx == nil {
 		return
 	}
+
+	// 压测流量访问影子库，原生sql无法改写
+	if shadow != "" && utils.IsPressure(ctx) {
+		if table := scope.TableName(); table != "" && !strings.Contains(table, ".") {
+			scope.Search.Table(shadow + "." + table)
+		}
+	}
+	span := zlog.StartSpan(ctx, "mysql:"+callbackName)
+	scope.InstanceSet(scopeKeySpan, span)
+
 	// 老span的兼容方式
-	spanId := zlog.CreateSpan(ctx)
-	ctx.Set("spanId", spanId)
+	ctx.Set(zlog.ContextKeySpanID, span.LegacyID)
 }
 
 func tracerAfter(scope *gorm.Scope, callbackName string) {
+	if v, ok := scope.InstanceGet(scopeKeyMetrics); ok {
+		if done, ok := v.(func(error)); ok {
+			err := scope.DB().Error
+			if gorm.IsRecordNotFoundError(err) {
+				err = nil
+			}
+			done(err)
+		}
+	}
+
+	v, ok := scope.InstanceGet(scopeKeySpan)
+	if !ok {
+		return
+	}
+	span, ok := v.(*zlog.Span)
+	if !ok {
+		return
+	}
 
+	span.SetTag("prot", "mysql")
+	span.SetTag("table", scope.TableName())
+	span.SetTag("sql", scope.SQL)
+	span.SetTag("affectedrow", scope.DB().RowsAffected)
+	span.SetError(scope.DB().Error)
+	span.Finish()
 }
@@ -3,6 +3,7 @@ package golib
 import (
 	"github.com/GitHub121380/golib/base"
 	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/metrics"
 	gg "github.com/GitHub121380/golib/middleware/gin"
 	"github.com/gin-gonic/gin"
 )
@@ -23,6 +24,12 @@ func Bootstrap(router *gin.Engine) {
 	// 就绪探针
 	router.GET("/ready", base.ReadyProbe())
 
+	// 监控指标
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	// 性能分析工具
 	base.Register(router)
+
+	// 调试接口，只允许 conf/app/debug.yaml 配置的地址访问
+	base.RegisterDebug(router)
 }
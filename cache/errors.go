@@ -0,0 +1,10 @@
+package cache
+
+import "errors"
+
+var (
+	// 加载函数返回该错误表示数据不存在，开启空值缓存时会缓存该结果
+	NotFoundErr     = errors.New("cache value not found")
+	EntryInvalidErr = errors.New("cache entry invalid")
+	LoadPanicErr    = errors.New("cache load panic")
+)
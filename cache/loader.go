@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const ( // 加载器默认配置
+	defaultTTL = time.Minute
+	// 条目头部：类型(1) + 新鲜截止时间(8) + 过期时间(8)
+	entryHeader = 17
+)
+
+const (
+	entryValue    byte = 0
+	entryNotFound byte = 1
+)
+
+// 加载函数，数据不存在时返回 NotFoundErr
+type LoadFunc func() (interface{}, error)
+
+type LoaderOptions struct {
+	// 调用未指定 TTL 时使用
+	TTL time.Duration
+	// 空值缓存时间，0 时不缓存不存在的结果
+	NotFoundTTL time.Duration
+	// TTL 随机增加的比例，避免同时过期，如 0.1 表示增加 [0, 10%)
+	Jitter float64
+	// 过期后仍可返回旧值的时间，期间在后台刷新
+	Stale time.Duration
+}
+
+// 旁路缓存加载器。依次查询各级存储，未命中时调用加载函数并写入所有存储，
+// 同一个 key 的并发加载只执行一次。低级存储命中时回填高级存储
+type Loader struct {
+	opt    LoaderOptions
+	stores []Store
+	group  group
+}
+
+// stores 按查询顺序传入，如 NewLoader(opt, NewLocalStore(c), NewRedisStore("cache"))
+func NewLoader(opt LoaderOptions, stores ...Store) *Loader {
+	if opt.TTL <= 0 {
+		opt.TTL = defaultTTL
+	}
+	return &Loader{opt: opt, stores: stores}
+}
+
+// 缓存条目
+type entry struct {
+	kind   byte
+	fresh  int64
+	expire int64
+	data   []byte
+}
+
+func (e *entry) encode() []byte {
+	buf := make([]byte, entryHeader+len(e.data))
+	buf[0] = e.kind
+	binary.BigEndian.PutUint64(buf[1:], uint64(e.fresh))
+	binary.BigEndian.PutUint64(buf[9:], uint64(e.expire))
+	copy(buf[entryHeader:], e.data)
+	return buf
+}
+
+func decodeEntry(buf []byte) (*entry, error) {
+	if len(buf) < entryHeader || buf[0] > entryNotFound {
+		return nil, EntryInvalidErr
+	}
+	return &entry{
+		kind:   buf[0],
+		fresh:  int64(binary.BigEndian.Uint64(buf[1:])),
+		expire: int64(binary.BigEndian.Uint64(buf[9:])),
+		data:   buf[entryHeader:],
+	}, nil
+}
+
+// 将条目的值解码到 dst，不存在的条目返回 NotFoundErr
+func (e *entry) value(dst interface{}) error {
+	if e.kind == entryNotFound {
+		return NotFoundErr
+	}
+	return json.Unmarshal(e.data, dst)
+}
+
+// 获取 key 对应的值并解码到 dst，ttl 为 0 时使用默认配置。
+// 后台刷新时加载函数可能在请求结束后执行，不应依赖请求的 context
+func (l *Loader) Get(ctx *gin.Context, key string, ttl time.Duration, dst interface{}, load LoadFunc) error {
+	now := time.Now().UnixNano()
+	if e := l.lookup(ctx, key, now); e != nil {
+		if now > e.fresh && !l.group.loading(key) {
+			go l.refresh(refreshContext(ctx), key, ttl, load)
+		}
+		return e.value(dst)
+	}
+
+	buf, err := l.group.do(key, func() ([]byte, error) {
+		return l.load(ctx, key, ttl, load)
+	})
+	if err != nil {
+		return err
+	}
+	e, err := decodeEntry(buf)
+	if err != nil {
+		return err
+	}
+	return e.value(dst)
+}
+
+// 复制 context 并与请求的超时及取消解绑，避免请求结束后后台刷新被取消或复用
+func refreshContext(ctx *gin.Context) *gin.Context {
+	if ctx == nil {
+		return nil
+	}
+	cp := ctx.Copy()
+	if cp.Request != nil {
+		cp.Request = cp.Request.WithContext(context.Background())
+	}
+	return cp
+}
+
+// 写入 key 的值，用于更新数据后主动刷新缓存
+func (l *Loader) Set(ctx *gin.Context, key string, ttl time.Duration, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return l.store(ctx, key, l.newEntry(entryValue, ttl, data))
+}
+
+// 删除所有存储中的 key
+func (l *Loader) Delete(ctx *gin.Context, keys ...string) error {
+	var err error
+	for _, s := range l.stores {
+		if e := s.Delete(ctx, keys...); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// 依次查询各级存储，返回未过期的条目
+func (l *Loader) lookup(ctx *gin.Context, key string, now int64) *entry {
+	for i, s := range l.stores {
+		buf, ok, err := s.Get(ctx, key)
+		if err != nil {
+			zlog.WarnLogger(ctx, fmt.Sprintf("cache get %s error: %s", key, err.Error()), zap.String("prot", "cache"))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		e, err := decodeEntry(buf)
+		if err != nil || now > e.expire {
+			continue
+		}
+		// 回填更高级的存储，过期时间保持一致
+		ttl := time.Duration(e.expire - now)
+		for _, upper := range l.stores[:i] {
+			_ = upper.Set(ctx, key, buf, ttl)
+		}
+		return e
+	}
+	return nil
+}
+
+func (l *Loader) load(ctx *gin.Context, key string, ttl time.Duration, load LoadFunc) ([]byte, error) {
+	v, err := load()
+	var e *entry
+	switch {
+	case err == NotFoundErr && l.opt.NotFoundTTL > 0:
+		e = l.newEntry(entryNotFound, l.opt.NotFoundTTL, nil)
+	case err != nil:
+		return nil, err
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		e = l.newEntry(entryValue, ttl, data)
+	}
+
+	if err := l.store(ctx, key, e); err != nil {
+		zlog.WarnLogger(ctx, fmt.Sprintf("cache set %s error: %s", key, err.Error()), zap.String("prot", "cache"))
+	}
+	return e.encode(), nil
+}
+
+// 后台刷新过期的条目，ctx 为调用方复制的 context
+func (l *Loader) refresh(ctx *gin.Context, key string, ttl time.Duration, load LoadFunc) {
+	defer func() {
+		if r := recover(); r != nil {
+			zlog.WarnLogger(ctx, fmt.Sprintf("cache refresh %s panic: %v", key, r), zap.String("prot", "cache"))
+		}
+	}()
+	_, err := l.group.do(key, func() ([]byte, error) {
+		return l.load(ctx, key, ttl, load)
+	})
+	if err != nil {
+		zlog.WarnLogger(ctx, fmt.Sprintf("cache refresh %s error: %s", key, err.Error()), zap.String("prot", "cache"))
+	}
+}
+
+func (l *Loader) newEntry(kind byte, ttl time.Duration, data []byte) *entry {
+	if ttl <= 0 {
+		ttl = l.opt.TTL
+	}
+	if l.opt.Jitter > 0 {
+		ttl += time.Duration(rand.Int63n(int64(float64(ttl)*l.opt.Jitter) + 1))
+	}
+	now := time.Now()
+	fresh := now.Add(ttl)
+	return &entry{
+		kind:   kind,
+		fresh:  fresh.UnixNano(),
+		expire: fresh.Add(l.opt.Stale).UnixNano(),
+		data:   data,
+	}
+}
+
+func (l *Loader) store(ctx *gin.Context, key string, e *entry) error {
+	buf := e.encode()
+	ttl := time.Duration(e.expire - time.Now().UnixNano())
+	var err error
+	for _, s := range l.stores {
+		if e := s.Set(ctx, key, buf, ttl); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GitHub121380/golib/gcache"
+	"github.com/GitHub121380/golib/ral"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLocal() *LocalStore {
+	return NewLocalStore(gcache.NewBucketCache(gcache.DefaultExpiration, 0, 4))
+}
+
+func TestLoaderSingleflight(t *testing.T) {
+	l := NewLoader(LoaderOptions{TTL: time.Minute}, newLocal())
+
+	var count int32
+	load := func() (interface{}, error) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(50 * time.Millisecond)
+		return map[string]int{"n": 1}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v map[string]int
+			assert.NoError(t, l.Get(nil, "k", 0, &v, load))
+			assert.Equal(t, 1, v["n"])
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	// 命中缓存不再加载
+	var v map[string]int
+	assert.NoError(t, l.Get(nil, "k", 0, &v, load))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestLoaderNotFound(t *testing.T) {
+	l := NewLoader(LoaderOptions{NotFoundTTL: time.Minute}, newLocal())
+
+	var count int
+	load := func() (interface{}, error) {
+		count++
+		return nil, NotFoundErr
+	}
+	var v string
+	assert.Equal(t, NotFoundErr, l.Get(nil, "missing", 0, &v, load))
+	assert.Equal(t, NotFoundErr, l.Get(nil, "missing", 0, &v, load))
+	assert.Equal(t, 1, count)
+
+	// 未开启空值缓存时每次都加载
+	l = NewLoader(LoaderOptions{}, newLocal())
+	count = 0
+	assert.Equal(t, NotFoundErr, l.Get(nil, "missing", 0, &v, load))
+	assert.Equal(t, NotFoundErr, l.Get(nil, "missing", 0, &v, load))
+	assert.Equal(t, 2, count)
+}
+
+func TestLoaderStale(t *testing.T) {
+	l := NewLoader(LoaderOptions{Stale: time.Minute}, newLocal())
+
+	var count int32
+	refreshed := make(chan struct{})
+	load := func() (interface{}, error) {
+		n := atomic.AddInt32(&count, 1)
+		if n == 2 {
+			close(refreshed)
+		}
+		return n, nil
+	}
+	var v int32
+	assert.NoError(t, l.Get(nil, "k", 0, &v, load))
+	assert.Equal(t, int32(1), v)
+
+	// 将条目改为已过期但仍在 Stale 时间内
+	e := l.newEntry(entryValue, time.Minute, []byte("1"))
+	e.fresh = time.Now().Add(-time.Second).UnixNano()
+	assert.NoError(t, l.store(nil, "k", e))
+
+	// 过期后返回旧值并在后台刷新
+	assert.NoError(t, l.Get(nil, "k", 0, &v, load))
+	assert.Equal(t, int32(1), v)
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("entry not refreshed")
+	}
+	deadline := time.Now().Add(time.Second)
+	for v != 2 && time.Now().Before(deadline) {
+		assert.NoError(t, l.Get(nil, "k", 0, &v, load))
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int32(2), v)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+}
+
+// 与 RedisStore 相同，调用方已取消时写入失败
+type ctxStore struct {
+	Store
+}
+
+func (s ctxStore) Set(ctx *gin.Context, key string, value []byte, ttl time.Duration) error {
+	if err := ral.Err(ctx); err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, key, value, ttl)
+}
+
+// 请求结束后后台刷新仍能写入存储
+func TestLoaderStaleCanceled(t *testing.T) {
+	s := ctxStore{newLocal()}
+	l := NewLoader(LoaderOptions{Stale: time.Minute}, s)
+	e := l.newEntry(entryValue, time.Minute, []byte("1"))
+	e.fresh = time.Now().Add(-time.Second).UnixNano()
+	assert.NoError(t, l.store(nil, "k", e))
+
+	req, cancel := context.WithCancel(context.Background())
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil).WithContext(req)
+
+	start := make(chan struct{})
+	var v int
+	assert.NoError(t, l.Get(ctx, "k", 0, &v, func() (interface{}, error) {
+		<-start
+		return 2, nil
+	}))
+	assert.Equal(t, 1, v)
+	// 请求在刷新写入前结束
+	cancel()
+	close(start)
+
+	deadline := time.Now().Add(time.Second)
+	for v != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		buf, ok, err := s.Get(nil, "k")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		e, err := decodeEntry(buf)
+		assert.NoError(t, err)
+		assert.NoError(t, e.value(&v))
+	}
+	assert.Equal(t, 2, v)
+}
+
+func TestLoaderLevels(t *testing.T) {
+	l1, l2 := newLocal(), newLocal()
+	l := NewLoader(LoaderOptions{Jitter: 0.1}, l1, l2)
+
+	assert.NoError(t, NewLoader(LoaderOptions{}, l2).Set(nil, "k", time.Minute, "v"))
+	_, ok, _ := l1.Get(nil, "k")
+	assert.False(t, ok)
+
+	// 二级存储命中时回填一级存储
+	var v string
+	assert.NoError(t, l.Get(nil, "k", 0, &v, func() (interface{}, error) {
+		t.Fatal("unexpected load")
+		return nil, nil
+	}))
+	assert.Equal(t, "v", v)
+	_, ok, _ = l1.Get(nil, "k")
+	assert.True(t, ok)
+
+	assert.NoError(t, l.Delete(nil, "k"))
+	_, ok, _ = l2.Get(nil, "k")
+	assert.False(t, ok)
+}
@@ -0,0 +1,49 @@
+package cache
+
+import "sync"
+
+// 同一个 key 的并发加载只执行一次，其余调用等待并共享结果
+type call struct {
+	wg  sync.WaitGroup
+	val []byte
+	err error
+}
+
+type group struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+func (g *group) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = map[string]*call{}
+	}
+	if c, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &call{err: LoadPanicErr}
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	// 加载函数 panic 时等待的调用返回 LoadPanicErr
+	defer func() {
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err
+}
+
+// key 是否正在加载
+func (g *group) loading(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.m[key]
+	return ok
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/GitHub121380/golib/gcache"
+	"github.com/GitHub121380/golib/redis"
+	"github.com/GitHub121380/golib/utils"
+	"github.com/gin-gonic/gin"
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// 缓存存储，保存编码后的缓存条目。未命中时返回 false 且 err 为 nil
+type Store interface {
+	Get(ctx *gin.Context, key string) ([]byte, bool, error)
+	Set(ctx *gin.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx *gin.Context, keys ...string) error
+}
+
+// 进程内存储，压测流量使用影子 key
+type LocalStore struct {
+	c *gcache.BucketCache
+}
+
+func NewLocalStore(c *gcache.BucketCache) *LocalStore {
+	return &LocalStore{c: c}
+}
+
+func (s *LocalStore) Get(ctx *gin.Context, key string) ([]byte, bool, error) {
+	v, ok := s.c.Get(utils.ShadowName(ctx, key))
+	if !ok {
+		return nil, false, nil
+	}
+	data, ok := v.([]byte)
+	return data, ok, nil
+}
+
+func (s *LocalStore) Set(ctx *gin.Context, key string, value []byte, ttl time.Duration) error {
+	s.c.Set(utils.ShadowName(ctx, key), value, ttl)
+	return nil
+}
+
+func (s *LocalStore) Delete(ctx *gin.Context, keys ...string) error {
+	for _, key := range keys {
+		s.c.Delete(utils.ShadowName(ctx, key))
+	}
+	return nil
+}
+
+// redis 存储，压测流量的影子 key 由 redis 模块处理
+type RedisStore struct {
+	service string
+}
+
+func NewRedisStore(service string) *RedisStore {
+	return &RedisStore{service: service}
+}
+
+func (s *RedisStore) Get(ctx *gin.Context, key string) ([]byte, bool, error) {
+	r, err := redis.GetInstance(ctx, s.service)
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Release()
+	data, err := redigo.Bytes(r.Do("GET", key))
+	if err == redigo.ErrNil {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (s *RedisStore) Set(ctx *gin.Context, key string, value []byte, ttl time.Duration) error {
+	r, err := redis.GetInstance(ctx, s.service)
+	if err != nil {
+		return err
+	}
+	defer r.Release()
+	ms := int64(ttl / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	_, err = r.Do("SET", key, value, "PX", ms)
+	return err
+}
+
+func (s *RedisStore) Delete(ctx *gin.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	r, err := redis.GetInstance(ctx, s.service)
+	if err != nil {
+		return err
+	}
+	defer r.Release()
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	_, err = r.Del(args...)
+	return err
+}
@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/GitHub121380/golib/gcache"
+	"github.com/GitHub121380/golib/redis"
+	"github.com/GitHub121380/golib/utils"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const ( // 二级缓存默认配置
+	defaultInvalidateChannel = "gcache:invalidate"
+	// 订阅断开期间可能丢失失效通知，一级缓存的时间不超过该值
+	defaultLocalTTL = time.Minute
+	defaultShards   = 16
+)
+
+type TwoLevelOptions struct {
+	LoaderOptions
+	// 失效通知的 Pub/Sub 频道，同一份数据的所有进程需使用相同的频道
+	Channel string
+	// 一级缓存的最大时间
+	LocalTTL time.Duration
+	// 一级缓存的分片数
+	Shards int
+}
+
+// 二级缓存，一级为进程内的 gcache，二级为 redis。写入及删除时通过 Pub/Sub
+// 通知其他进程删除一级缓存
+type TwoLevelCache struct {
+	loader  *Loader
+	local   *level
+	remote  *level
+	service string
+	channel string
+	// 进程标识，忽略自己发出的通知
+	id  string
+	sub *redis.Subscriber
+}
+
+// 失效通知，Keys 为一级缓存中的 key（压测流量已添加影子前缀）
+type invalidation struct {
+	ID   string   `json:"id"`
+	Keys []string `json:"keys"`
+}
+
+// 各级缓存的命中统计
+type LevelStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+func (s LevelStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type TwoLevelStats struct {
+	Local  LevelStats `json:"local"`
+	Remote LevelStats `json:"remote"`
+}
+
+// 统计命中率并限制缓存时间的存储
+type level struct {
+	Store
+	maxTTL time.Duration
+	hits   int64
+	misses int64
+}
+
+func (l *level) Get(ctx *gin.Context, key string) ([]byte, bool, error) {
+	buf, ok, err := l.Store.Get(ctx, key)
+	if ok {
+		atomic.AddInt64(&l.hits, 1)
+	} else {
+		atomic.AddInt64(&l.misses, 1)
+	}
+	return buf, ok, err
+}
+
+func (l *level) Set(ctx *gin.Context, key string, value []byte, ttl time.Duration) error {
+	if l.maxTTL > 0 && ttl > l.maxTTL {
+		ttl = l.maxTTL
+	}
+	return l.Store.Set(ctx, key, value, ttl)
+}
+
+func (l *level) stats() LevelStats {
+	return LevelStats{Hits: atomic.LoadInt64(&l.hits), Misses: atomic.LoadInt64(&l.misses)}
+}
+
+// 创建二级缓存并订阅失效通知，service 为 ral 中配置的 redis 服务名
+func NewTwoLevelCache(service string, opt TwoLevelOptions) *TwoLevelCache {
+	if opt.Channel == "" {
+		opt.Channel = defaultInvalidateChannel
+	}
+	if opt.LocalTTL <= 0 {
+		opt.LocalTTL = defaultLocalTTL
+	}
+	if opt.Shards <= 0 {
+		opt.Shards = defaultShards
+	}
+
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	c := &TwoLevelCache{
+		local:   &level{Store: NewLocalStore(gcache.NewBucketCache(opt.LocalTTL, opt.LocalTTL, opt.Shards)), maxTTL: opt.LocalTTL},
+		remote:  &level{Store: NewRedisStore(service)},
+		service: service,
+		channel: opt.Channel,
+		id:      hex.EncodeToString(buf),
+	}
+	c.loader = NewLoader(opt.LoaderOptions, c.local, c.remote)
+	c.sub = redis.NewSubscriber(service, c.invalidated)
+	if err := c.sub.Subscribe(opt.Channel); err != nil {
+		zlog.WarnLogger(nil, fmt.Sprintf("cache subscribe %s error: %s", opt.Channel, err.Error()), zap.String("prot", "cache"))
+	}
+	return c
+}
+
+// 依次查询一级、二级缓存并解码到 dst，未命中时返回 NotFoundErr
+func (c *TwoLevelCache) Get(ctx *gin.Context, key string, dst interface{}) error {
+	if e := c.loader.lookup(ctx, key, time.Now().UnixNano()); e != nil {
+		return e.value(dst)
+	}
+	return NotFoundErr
+}
+
+// 查询缓存，未命中时调用加载函数，参见 Loader.Get
+func (c *TwoLevelCache) Load(ctx *gin.Context, key string, ttl time.Duration, dst interface{}, load LoadFunc) error {
+	return c.loader.Get(ctx, key, ttl, dst, load)
+}
+
+// 写入两级缓存，并通知其他进程删除一级缓存
+func (c *TwoLevelCache) Set(ctx *gin.Context, key string, value interface{}, ttl time.Duration) error {
+	if err := c.loader.Set(ctx, key, ttl, value); err != nil {
+		return err
+	}
+	return c.publish(ctx, key)
+}
+
+// 删除两级缓存，并通知其他进程删除一级缓存
+func (c *TwoLevelCache) Delete(ctx *gin.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := c.loader.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	return c.publish(ctx, keys...)
+}
+
+// 返回各级缓存的命中统计
+func (c *TwoLevelCache) Stats() TwoLevelStats {
+	return TwoLevelStats{Local: c.local.stats(), Remote: c.remote.stats()}
+}
+
+// 停止订阅失效通知
+func (c *TwoLevelCache) Close() {
+	c.sub.Close()
+}
+
+func (c *TwoLevelCache) publish(ctx *gin.Context, keys ...string) error {
+	msg := invalidation{ID: c.id, Keys: make([]string, len(keys))}
+	for i, key := range keys {
+		msg.Keys[i] = utils.ShadowName(ctx, key)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// 频道不添加影子前缀，压测流量的通知同样发送到线上频道
+	r, err := redis.GetInstance(nil, c.service)
+	if err != nil {
+		return err
+	}
+	defer r.Release()
+	_, err = r.Publish(c.channel, data)
+	return err
+}
+
+// 收到其他进程的失效通知时删除一级缓存
+func (c *TwoLevelCache) invalidated(m redis.Message) {
+	var msg invalidation
+	if err := json.Unmarshal(m.Data, &msg); err != nil {
+		zlog.WarnLogger(nil, fmt.Sprintf("cache invalidation %s invalid: %s", m.Channel, err.Error()), zap.String("prot", "cache"))
+		return
+	}
+	if msg.ID == c.id {
+		return
+	}
+	// 通知中的 key 已处理影子前缀，使用 nil context 直接删除
+	_ = c.local.Delete(nil, msg.Keys...)
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/redis"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func init() {
+	zlog.ModuleLogger = zap.NewNop()
+	zlog.SetSpanExporter(nil)
+}
+
+func TestTwoLevelInvalidated(t *testing.T) {
+	local := &level{Store: newLocal(), maxTTL: time.Minute}
+	c := &TwoLevelCache{local: local, id: "self"}
+	_ = local.Set(nil, "k", []byte("v"), time.Hour)
+
+	// 忽略自己发出的通知
+	data, _ := json.Marshal(invalidation{ID: "self", Keys: []string{"k"}})
+	c.invalidated(redis.Message{Data: data})
+	_, ok, _ := local.Get(nil, "k")
+	assert.True(t, ok)
+
+	data, _ = json.Marshal(invalidation{ID: "other", Keys: []string{"k"}})
+	c.invalidated(redis.Message{Data: data})
+	_, ok, _ = local.Get(nil, "k")
+	assert.False(t, ok)
+
+	s := local.stats()
+	assert.Equal(t, LevelStats{Hits: 1, Misses: 1}, s)
+	assert.Equal(t, 0.5, s.HitRatio())
+	assert.Equal(t, float64(0), LevelStats{}.HitRatio())
+}
+
+// 写入及删除时发布失效通知
+func TestTwoLevelPublish(t *testing.T) {
+	m := ral.NewMock()
+	m.On(ral.TYPE_REDIS, "twolevel", "GET", "").Return(nil, nil)
+	m.On(ral.TYPE_REDIS, "twolevel", "SET", "").Return("OK", nil)
+	m.On(ral.TYPE_REDIS, "twolevel", "DEL", "").Return(int64(2), nil)
+	m.On(ral.TYPE_REDIS, "twolevel", "PUBLISH", "").Return(int64(1), nil)
+	m.Start()
+	defer m.Stop()
+
+	c := NewTwoLevelCache("twolevel", TwoLevelOptions{})
+	defer c.Close()
+	assert.NoError(t, c.Set(nil, "k", "v", time.Minute))
+	var v string
+	assert.NoError(t, c.Get(nil, "k", &v))
+	assert.Equal(t, "v", v)
+	assert.NoError(t, c.Delete(nil, "k", "k2"))
+	assert.Equal(t, NotFoundErr, c.Get(nil, "k", &v))
+
+	var msgs []invalidation
+	for _, call := range m.Calls() {
+		if call.Method != "PUBLISH" {
+			continue
+		}
+		assert.Equal(t, defaultInvalidateChannel, call.Args[0])
+		var msg invalidation
+		assert.NoError(t, json.Unmarshal(call.Args[1].([]byte), &msg))
+		msgs = append(msgs, msg)
+	}
+	assert.Equal(t, []invalidation{
+		{ID: c.id, Keys: []string{"k"}},
+		{ID: c.id, Keys: []string{"k", "k2"}},
+	}, msgs)
+	assert.Empty(t, m.Unmatched())
+}
@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/GitHub121380/golib/base"
+	"github.com/GitHub121380/golib/metrics"
 	m "github.com/GitHub121380/golib/middleware/gin"
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/utils"
 	"github.com/GitHub121380/golib/zlog"
 	"github.com/Shopify/sarama"
 	"github.com/gin-gonic/gin"
@@ -127,6 +130,7 @@ func (c *KafkaConsumerGroup) ConsumeClaim(session sarama.ConsumerGroupSession, c
 
 func (c *KafkaConsumerGroup) HandleMessage(message *sarama.ConsumerMessage) error {
 	ctx := gin.CreateNewContext(c.Client.g)
+	done := metrics.Server("kafka")
 	customCtx := gin.CustomContext{
 		Handle:    c.handler,
 		Desc:      message.Topic,
@@ -153,14 +157,41 @@ func (c *KafkaConsumerGroup) HandleMessage(message *sarama.ConsumerMessage) erro
 		gin.RecycleContext(c.Client.g, ctx)
 	}()
 
+	// 从消息头中恢复上游链路
+	header := func(key string) string {
+		for _, h := range message.Headers {
+			if h != nil && string(h.Key) == key {
+				return string(h.Value)
+			}
+		}
+		return ""
+	}
+	span := zlog.StartServerSpan(ctx, "kafka:"+message.Topic, header)
+	if color := header(ral.HEAD_COLOR); color != "" {
+		ral.SetColor(ctx, color)
+	}
+	utils.SetPressureFlag(ctx, header(utils.HttpXBDCallerURI))
+	span.SetTag("topic", message.Topic)
+	span.SetTag("partition", message.Partition)
+	span.SetTag("offset", message.Offset)
+	defer span.Finish()
+
 	var body base.KafkaBody
 	if err := json.Unmarshal(message.Value, &body); err != nil {
+		span.SetError(err)
+		done("consume", message.Topic, metrics.CodeError)
 		return err
 	}
 	ctx.Set(KafkaBodyKey, body.Msg)
 	//m.LoggerBeforeRun(ctx)
 
 	err := c.handler(ctx)
+	span.SetError(err)
+	if err != nil {
+		done("consume", message.Topic, metrics.CodeError)
+	} else {
+		done("consume", message.Topic, metrics.CodeOK)
+	}
 
 	ctx.CustomContext.Error = err
 	ctx.CustomContext.EndTime = time.Now()
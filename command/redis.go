@@ -0,0 +1,170 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/metrics"
+	m "github.com/GitHub121380/golib/middleware/gin"
+	"github.com/GitHub121380/golib/redis"
+	"github.com/GitHub121380/golib/utils"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+)
+
+const RedisMsgKey string = "RedisMsg"
+
+type RedisSubClient struct {
+	Service string
+	g       *gin.Engine
+
+	mu        sync.Mutex
+	subs      []*redis.Subscriber
+	consumers []*redis.StreamConsumer
+}
+
+// service 为 ral 中配置的 redis 服务名
+func InitRedisSub(g *gin.Engine, service string) *RedisSubClient {
+	return &RedisSubClient{
+		Service: service,
+		g:       g,
+	}
+}
+
+type redisHandler func(*gin.Context) error
+
+// 订阅 channel，每次调用独占一个连接，handler 中通过 GetRedisMsg 获取 redis.Message
+func (c *RedisSubClient) AddSubFunction(channels []string, handler redisHandler) error {
+	s := c.subscriber(handler)
+	return s.Subscribe(channels...)
+}
+
+// 按模式订阅 channel
+func (c *RedisSubClient) AddPSubFunction(patterns []string, handler redisHandler) error {
+	s := c.subscriber(handler)
+	return s.PSubscribe(patterns...)
+}
+
+func (c *RedisSubClient) subscriber(handler redisHandler) *redis.Subscriber {
+	s := redis.NewSubscriber(c.Service, func(msg redis.Message) {
+		desc := msg.Channel
+		if msg.Pattern != "" {
+			desc = msg.Pattern
+		}
+		c.handle(handler, "RedisSub", desc, msg.Channel, msg, map[string]interface{}{
+			"channel": msg.Channel,
+			"pattern": msg.Pattern,
+		})
+	})
+
+	c.mu.Lock()
+	c.subs = append(c.subs, s)
+	c.mu.Unlock()
+	return s
+}
+
+// 以消费组方式消费 stream，handler 返回 nil 时确认消息，handler 中通过 GetRedisMsg 获取 redis.StreamMessage。
+// consumer 为空时使用主机名
+func (c *RedisSubClient) AddStreamFunction(stream string, group string, consumer string, handler redisHandler, opts *redis.StreamOptions) {
+	if consumer == "" {
+		consumer = env.Hostname
+	}
+	opt := redis.StreamOptions{}
+	if opts != nil {
+		opt = *opts
+	}
+
+	s := redis.NewStreamConsumer(c.Service, stream, group, consumer, opt, func(msg redis.StreamMessage) error {
+		return c.handle(handler, "RedisStream", stream, stream, msg, map[string]interface{}{
+			"stream": stream,
+			"group":  group,
+			"id":     msg.ID,
+		})
+	})
+
+	c.mu.Lock()
+	c.consumers = append(c.consumers, s)
+	c.mu.Unlock()
+}
+
+// 停止所有订阅及消费
+func (c *RedisSubClient) Close() {
+	c.mu.Lock()
+	subs, consumers := c.subs, c.consumers
+	c.subs, c.consumers = nil, nil
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		s.Close()
+	}
+	for _, s := range consumers {
+		s.Close()
+	}
+}
+
+func (c *RedisSubClient) handle(handler redisHandler, typ string, desc string, name string, msg interface{}, tags map[string]interface{}) (err error) {
+	ctx := gin.CreateNewContext(c.g)
+	done := metrics.Server("redis")
+	customCtx := gin.CustomContext{
+		Handle:    handler,
+		Desc:      desc,
+		Type:      typ,
+		StartTime: time.Now(),
+	}
+	ctx.CustomContext = customCtx
+
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+
+			info, _ := json.Marshal(map[string]interface{}{
+				"time":      time.Now().Format("2006-01-02 15:04:05"),
+				"level":     "error",
+				"module":    "stack",
+				"requestId": zlog.GetRequestID(ctx),
+				"handle":    ctx.CustomContext.HandlerName(),
+			})
+			fmt.Printf("%s\n-------------------stack-start-------------------\n%+v\n-------------------stack-end-------------------\n", string(info), r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+		gin.RecycleContext(c.g, ctx)
+	}()
+
+	m.UseMetadata(ctx)
+	span := zlog.StartServerSpan(ctx, "redis:"+name, nil)
+	// 影子 channel、stream 中的消息按压测流量处理
+	if strings.HasPrefix(name, utils.PressureShadowPrefix) {
+		utils.SetPressureFlag(ctx, utils.PressureCallerURI)
+	}
+	for k, v := range tags {
+		span.SetTag(k, v)
+	}
+	defer span.Finish()
+
+	ctx.Set(RedisMsgKey, msg)
+	err = handler(ctx)
+	span.SetError(err)
+	if err != nil {
+		done("consume", desc, metrics.CodeError)
+	} else {
+		done("consume", desc, metrics.CodeOK)
+	}
+
+	ctx.CustomContext.Error = err
+	ctx.CustomContext.EndTime = time.Now()
+	m.LoggerAfterRun(ctx)
+	return err
+}
+
+// 返回 redis.Message 或 redis.StreamMessage
+func GetRedisMsg(ctx *gin.Context) (msg interface{}, exist bool) {
+	msg, exist = ctx.Get(RedisMsgKey)
+	return msg, exist
+}
@@ -10,10 +10,17 @@ type BucketCache struct {
 }
 
 func NewBucketCache(defaultExpiration, cleanupInterval time.Duration, shardnum int) *BucketCache {
+	return NewBoundedBucketCache(defaultExpiration, cleanupInterval, shardnum, BoundOptions{})
+}
+
+// Same as NewBucketCache, but items are evicted with opt.Policy once the cache
+// exceeds opt.MaxEntries or opt.MaxBytes. Expired items count towards the
+// limits until the janitor removes them.
+func NewBoundedBucketCache(defaultExpiration, cleanupInterval time.Duration, shardnum int, opt BoundOptions) *BucketCache {
 	if defaultExpiration == 0 {
 		defaultExpiration = -1
 	}
-	sc := newShardedCache(shardnum, defaultExpiration)
+	sc := newShardedCache(shardnum, defaultExpiration, opt)
 	SC := &BucketCache{sc}
 	if cleanupInterval > 0 {
 		runShardedJanitor(sc, cleanupInterval)
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,10 +24,18 @@ func (item Item) Expired() bool {
 }
 
 type cache struct {
+	// Updated atomically; kept first for 64-bit alignment on 32-bit platforms.
+	hits        int64
+	misses      int64
+	evictions   int64
+	expirations int64
+
 	defaultExpiration time.Duration
 	items             map[string]Item
 	mu                sync.RWMutex
-	onEvicted         func(string, interface{})
+	onEvicted         func(string, interface{}, EvictReason)
+	// Size limits and eviction policy, nil if the cache is unbounded.
+	bound *bound
 }
 
 func (c *cache) setRecover(k string, x interface{}, e int64) {
@@ -40,9 +49,11 @@ func (c *cache) setRecover(k string, x interface{}, e int64) {
 		Object:     x,
 		Expiration: e,
 	}
+	evicted := c.track(k, x)
 	// TODO: Calls to mu.Unlock are currently not deferred because defer
 	// adds ~200 ns (as of go1.)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 }
 
 // Add an item to the cache, replacing any existing item. If the duration is 0
@@ -62,12 +73,16 @@ func (c *cache) set(k string, x interface{}, d time.Duration) {
 		Object:     x,
 		Expiration: e,
 	}
+	evicted := c.track(k, x)
 	// TODO: Calls to mu.Unlock are currently not deferred because defer
 	// adds ~200 ns (as of go1.)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 }
 
-func (c *cache) setkvd(k string, x interface{}, d time.Duration) {
+// Same as set, but must be called with c.mu held. Returns the items evicted to
+// make room, which are passed to evict after the lock is released.
+func (c *cache) setkvd(k string, x interface{}, d time.Duration) []keyAndValue {
 	var e int64
 	if d == DefaultExpiration {
 		d = c.defaultExpiration
@@ -79,6 +94,7 @@ func (c *cache) setkvd(k string, x interface{}, d time.Duration) {
 		Object:     x,
 		Expiration: e,
 	}
+	return c.track(k, x)
 }
 
 // Add an item to the cache, replacing any existing item, using the default
@@ -96,8 +112,9 @@ func (c *cache) add(k string, x interface{}, d time.Duration) error {
 		c.mu.Unlock()
 		return fmt.Errorf("Item %s already exists", k)
 	}
-	c.setkvd(k, x, d)
+	evicted := c.setkvd(k, x, d)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nil
 }
 
@@ -110,58 +127,93 @@ func (c *cache) replace(k string, x interface{}, d time.Duration) error {
 		c.mu.Unlock()
 		return fmt.Errorf("Item %s doesn't exist", k)
 	}
-	c.setkvd(k, x, d)
+	evicted := c.setkvd(k, x, d)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nil
 }
 
 // Get an item from the cache. Returns the item or nil, and a bool indicating
 // whether the key was found.
 func (c *cache) get(k string) (interface{}, bool) {
+	if c.bound != nil {
+		item, found := c.getBounded(k)
+		return item.Object, found
+	}
 	c.mu.RLock()
 	// "Inlining" of get and Expired
 	item, found := c.items[k]
 	if !found {
 		c.mu.RUnlock()
+		atomic.AddInt64(&c.misses, 1)
 		return nil, false
 	}
 	if item.Expiration > 0 {
 		if time.Now().UnixNano() > item.Expiration {
 			c.mu.RUnlock()
+			atomic.AddInt64(&c.misses, 1)
 			return nil, false
 		}
 	}
 	c.mu.RUnlock()
+	atomic.AddInt64(&c.hits, 1)
 	return item.Object, true
 }
 
+// Get an item from a bounded cache. A hit is recorded by the eviction policy,
+// so the write lock is taken.
+func (c *cache) getBounded(k string) (Item, bool) {
+	c.mu.Lock()
+	item, found := c.items[k]
+	if !found || item.Expired() {
+		c.mu.Unlock()
+		atomic.AddInt64(&c.misses, 1)
+		return Item{}, false
+	}
+	c.bound.policy.touch(k)
+	c.mu.Unlock()
+	atomic.AddInt64(&c.hits, 1)
+	return item, true
+}
+
 // GetWithExpiration returns an item and its expiration time from the cache.
 // It returns the item or nil, the expiration time if one is set (if the item
 // never expires a zero value for time.Time is returned), and a bool indicating
 // whether the key was found.
 func (c *cache) getWithExpiration(k string) (interface{}, time.Time, bool) {
+	if c.bound != nil {
+		item, found := c.getBounded(k)
+		if !found || item.Expiration <= 0 {
+			return item.Object, time.Time{}, found
+		}
+		return item.Object, time.Unix(0, item.Expiration), true
+	}
 	c.mu.RLock()
 	// "Inlining" of get and Expired
 	item, found := c.items[k]
 	if !found {
 		c.mu.RUnlock()
+		atomic.AddInt64(&c.misses, 1)
 		return nil, time.Time{}, false
 	}
 
 	if item.Expiration > 0 {
 		if time.Now().UnixNano() > item.Expiration {
 			c.mu.RUnlock()
+			atomic.AddInt64(&c.misses, 1)
 			return nil, time.Time{}, false
 		}
 
 		// Return the item and the expiration time
 		c.mu.RUnlock()
+		atomic.AddInt64(&c.hits, 1)
 		return item.Object, time.Unix(0, item.Expiration), true
 	}
 
 	// If expiration <= 0 (i.e. no expiration time set) then return the item
 	// and a zeroed time.Time
 	c.mu.RUnlock()
+	atomic.AddInt64(&c.hits, 1)
 	return item.Object, time.Time{}, true
 }
 
@@ -223,7 +275,9 @@ func (c *cache) increment(k string, n int64) error {
 		return fmt.Errorf("The value for %s is not an integer", k)
 	}
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nil
 }
 
@@ -249,7 +303,9 @@ func (c *cache) incrementFloat(k string, n float64) error {
 		return fmt.Errorf("The value for %s does not have type float32 or float64", k)
 	}
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nil
 }
 
@@ -271,7 +327,9 @@ func (c *cache) incrementInt(k string, n int) (int, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -293,7 +351,9 @@ func (c *cache) incrementInt8(k string, n int8) (int8, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -315,7 +375,9 @@ func (c *cache) incrementInt16(k string, n int16) (int16, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -337,7 +399,9 @@ func (c *cache) incrementInt32(k string, n int32) (int32, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -359,7 +423,9 @@ func (c *cache) incrementInt64(k string, n int64) (int64, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -381,7 +447,9 @@ func (c *cache) incrementUint(k string, n uint) (uint, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -403,7 +471,9 @@ func (c *cache) incrementUintptr(k string, n uintptr) (uintptr, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -425,7 +495,9 @@ func (c *cache) incrementUint8(k string, n uint8) (uint8, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -447,7 +519,9 @@ func (c *cache) incrementUint16(k string, n uint16) (uint16, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -469,7 +543,9 @@ func (c *cache) incrementUint32(k string, n uint32) (uint32, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -491,7 +567,9 @@ func (c *cache) incrementUint64(k string, n uint64) (uint64, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -513,7 +591,9 @@ func (c *cache) incrementFloat32(k string, n float32) (float32, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -535,7 +615,9 @@ func (c *cache) incrementFloat64(k string, n float64) (float64, error) {
 	nv := rv + n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -585,7 +667,9 @@ func (c *cache) decrement(k string, n int64) error {
 		return fmt.Errorf("The value for %s is not an integer", k)
 	}
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nil
 }
 
@@ -611,7 +695,9 @@ func (c *cache) decrementFloat(k string, n float64) error {
 		return fmt.Errorf("The value for %s does not have type float32 or float64", k)
 	}
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nil
 }
 
@@ -633,7 +719,9 @@ func (c *cache) decrementInt(k string, n int) (int, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -655,7 +743,9 @@ func (c *cache) decrementInt8(k string, n int8) (int8, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -677,7 +767,9 @@ func (c *cache) decrementInt16(k string, n int16) (int16, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -699,7 +791,9 @@ func (c *cache) decrementInt32(k string, n int32) (int32, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -721,7 +815,9 @@ func (c *cache) decrementInt64(k string, n int64) (int64, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -743,7 +839,9 @@ func (c *cache) decrementUint(k string, n uint) (uint, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -765,7 +863,9 @@ func (c *cache) decrementUintptr(k string, n uintptr) (uintptr, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -787,7 +887,9 @@ func (c *cache) decrementUint8(k string, n uint8) (uint8, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -809,7 +911,9 @@ func (c *cache) decrementUint16(k string, n uint16) (uint16, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -831,7 +935,9 @@ func (c *cache) decrementUint32(k string, n uint32) (uint32, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -853,7 +959,9 @@ func (c *cache) decrementUint64(k string, n uint64) (uint64, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -875,7 +983,9 @@ func (c *cache) decrementFloat32(k string, n float32) (float32, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -897,7 +1007,9 @@ func (c *cache) decrementFloat64(k string, n float64) (float64, error) {
 	nv := rv - n
 	v.Object = nv
 	c.items[k] = v
+	evicted := c.track(k, v.Object)
 	c.mu.Unlock()
+	c.evict(evicted, EvictCapacity)
 	return nv, nil
 }
 
@@ -907,11 +1019,14 @@ func (c *cache) delete(k string) {
 	v, evicted := c.deletekey(k)
 	c.mu.Unlock()
 	if evicted {
-		c.onEvicted(k, v)
+		c.onEvicted(k, v, EvictDeleted)
 	}
 }
 
 func (c *cache) deletekey(k string) (interface{}, bool) {
+	if c.bound != nil {
+		c.bound.remove(k)
+	}
 	if c.onEvicted != nil {
 		if v, found := c.items[k]; found {
 			delete(c.items, k)
@@ -935,6 +1050,7 @@ func (c *cache) deleteExpired() {
 	for k, v := range c.items {
 		// "Inlining" of expired
 		if v.Expiration > 0 && now > v.Expiration {
+			atomic.AddInt64(&c.expirations, 1)
 			ov, evicted := c.deletekey(k)
 			if evicted {
 				evictedItems = append(evictedItems, keyAndValue{k, ov})
@@ -942,15 +1058,72 @@ func (c *cache) deleteExpired() {
 		}
 	}
 	c.mu.Unlock()
-	for _, v := range evictedItems {
-		c.onEvicted(v.key, v.value)
+	c.evict(evictedItems, EvictExpired)
+}
+
+// Records that k was set to x, including by increment and decrement, and
+// evicts items until the cache is within its limits. Must be called with c.mu
+// held.
+func (c *cache) track(k string, x interface{}) []keyAndValue {
+	if c.bound == nil {
+		return nil
+	}
+	var evicted []keyAndValue
+	c.bound.resize(k, x)
+	if c.bound.policy.has(k) {
+		c.bound.policy.touch(k)
+	} else {
+		// Make room before adding k, so a new item is not its own victim.
+		evicted = c.shrink(evicted)
+		c.bound.policy.touch(k)
+	}
+	// A single item may still exceed the limits.
+	return c.shrink(evicted)
+}
+
+// Evicts items until the cache is within its limits. Must be called with c.mu
+// held.
+func (c *cache) shrink(evicted []keyAndValue) []keyAndValue {
+	for c.bound.over(len(c.items)) {
+		vk, ok := c.bound.policy.victim()
+		if !ok {
+			break
+		}
+		item := c.items[vk]
+		delete(c.items, vk)
+		c.bound.remove(vk)
+		atomic.AddInt64(&c.evictions, 1)
+		if c.onEvicted != nil {
+			evicted = append(evicted, keyAndValue{vk, item.Object})
+		}
+	}
+	return evicted
+}
+
+// Calls onEvicted for the removed items. Must be called without c.mu held.
+func (c *cache) evict(items []keyAndValue, reason EvictReason) {
+	for _, v := range items {
+		c.onEvicted(v.key, v.value, reason)
 	}
 }
 
 // Sets an (optional) function that is called with the key and value when an
 // item is evicted from the cache. (Including when it is deleted manually, but
-// not when it is overwritten.) Set to nil to disable.
+// not when it is overwritten.) Replaces any function set with addEvictedReason.
+// Set to nil to disable.
 func (c *cache) addEvicted(f func(string, interface{})) {
+	if f == nil {
+		c.addEvictedReason(nil)
+		return
+	}
+	c.addEvictedReason(func(k string, v interface{}, _ EvictReason) {
+		f(k, v)
+	})
+}
+
+// Same as addEvicted, but f is also passed the reason the item was removed.
+// Replaces any function set with addEvicted.
+func (c *cache) addEvictedReason(f func(string, interface{}, EvictReason)) {
 	c.mu.Lock()
 	c.onEvicted = f
 	c.mu.Unlock()
@@ -1025,5 +1198,18 @@ func (c *cache) getItemCount() int {
 func (c *cache) flush() {
 	c.mu.Lock()
 	c.items = map[string]Item{}
+	if c.bound != nil {
+		c.bound.reset()
+	}
 	c.mu.Unlock()
 }
+
+// Returns the cache's hit, miss and eviction counters.
+func (c *cache) stats() Stats {
+	return Stats{
+		Hits:        atomic.LoadInt64(&c.hits),
+		Misses:      atomic.LoadInt64(&c.misses),
+		Evictions:   atomic.LoadInt64(&c.evictions),
+		Expirations: atomic.LoadInt64(&c.expirations),
+	}
+}
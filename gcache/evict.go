@@ -0,0 +1,277 @@
+package gcache
+
+import (
+	"container/list"
+	"reflect"
+)
+
+// Why an item was removed from the cache. Passed to the function set with
+// OnEvictedWithReason.
+type EvictReason int
+
+const (
+	// The item was deleted with Delete.
+	EvictDeleted EvictReason = iota
+	// The item had expired and was removed by DeleteExpired or the janitor.
+	EvictExpired
+	// The item was removed to keep the cache within its size limits.
+	EvictCapacity
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictDeleted:
+		return "deleted"
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	}
+	return "unknown"
+}
+
+// Eviction policy used when a cache is bounded.
+type Policy int
+
+const (
+	// Evict the least recently used item.
+	PolicyLRU Policy = iota
+	// Evict the least frequently used item, the least recently used one among
+	// items with the same frequency.
+	PolicyLFU
+)
+
+// Size limits of a BucketCache. The limits apply to the whole cache and are
+// split evenly between the shards, so a skewed key distribution may evict
+// items before the total limit is reached.
+type BoundOptions struct {
+	Policy Policy
+	// Maximum number of items, 0 for no limit.
+	MaxEntries int
+	// Approximate maximum size of keys and values in bytes, 0 for no limit.
+	MaxBytes int64
+	// Returns the size of an item in bytes. If nil, strings and byte slices
+	// count their length and other values their shallow size.
+	Sizer func(k string, x interface{}) int64
+}
+
+// Hit, miss and eviction counters of a cache.
+type Stats struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+}
+
+// Tracks the order in which items are evicted. Not safe for concurrent use;
+// callers hold the cache's lock.
+type evictPolicy interface {
+	// Record an insertion or access of k.
+	touch(k string)
+	has(k string) bool
+	remove(k string)
+	// Returns the next item to evict.
+	victim() (string, bool)
+	reset()
+}
+
+type bound struct {
+	policy     evictPolicy
+	maxEntries int
+	maxBytes   int64
+	sizer      func(k string, x interface{}) int64
+	sizes      map[string]int64
+	bytes      int64
+}
+
+func newBound(opt BoundOptions, shards int) *bound {
+	if opt.MaxEntries <= 0 && opt.MaxBytes <= 0 {
+		return nil
+	}
+	b := &bound{}
+	if opt.MaxEntries > 0 {
+		b.maxEntries = (opt.MaxEntries + shards - 1) / shards
+	}
+	if opt.MaxBytes > 0 {
+		b.maxBytes = (opt.MaxBytes + int64(shards) - 1) / int64(shards)
+		b.sizer = opt.Sizer
+		if b.sizer == nil {
+			b.sizer = approxSize
+		}
+		b.sizes = map[string]int64{}
+	}
+	switch opt.Policy {
+	case PolicyLFU:
+		b.policy = newLFU()
+	default:
+		b.policy = newLRU()
+	}
+	return b
+}
+
+// Record the size of x as the size of k.
+func (b *bound) resize(k string, x interface{}) {
+	if b.sizes != nil {
+		n := b.sizer(k, x)
+		b.bytes += n - b.sizes[k]
+		b.sizes[k] = n
+	}
+}
+
+func (b *bound) remove(k string) {
+	b.policy.remove(k)
+	if b.sizes != nil {
+		b.bytes -= b.sizes[k]
+		delete(b.sizes, k)
+	}
+}
+
+// Returns true if a cache holding n items exceeds the limits.
+func (b *bound) over(n int) bool {
+	return (b.maxEntries > 0 && n > b.maxEntries) || (b.maxBytes > 0 && b.bytes > b.maxBytes)
+}
+
+func (b *bound) reset() {
+	b.policy.reset()
+	if b.sizes != nil {
+		b.sizes = map[string]int64{}
+		b.bytes = 0
+	}
+}
+
+func approxSize(k string, x interface{}) int64 {
+	n := int64(len(k))
+	switch v := x.(type) {
+	case string:
+		return n + int64(len(v))
+	case []byte:
+		return n + int64(len(v))
+	case nil:
+		return n
+	}
+	return n + int64(reflect.TypeOf(x).Size())
+}
+
+type lru struct {
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (p *lru) touch(k string) {
+	if e, ok := p.items[k]; ok {
+		p.ll.MoveToFront(e)
+		return
+	}
+	p.items[k] = p.ll.PushFront(k)
+}
+
+func (p *lru) has(k string) bool {
+	_, ok := p.items[k]
+	return ok
+}
+
+func (p *lru) remove(k string) {
+	if e, ok := p.items[k]; ok {
+		p.ll.Remove(e)
+		delete(p.items, k)
+	}
+}
+
+func (p *lru) victim() (string, bool) {
+	e := p.ll.Back()
+	if e == nil {
+		return "", false
+	}
+	return e.Value.(string), true
+}
+
+func (p *lru) reset() {
+	p.ll.Init()
+	p.items = map[string]*list.Element{}
+}
+
+type lfuEntry struct {
+	key  string
+	freq int
+}
+
+// Items are kept in one list per frequency, most recently used first.
+type lfu struct {
+	items   map[string]*list.Element
+	freqs   map[int]*list.List
+	minFreq int
+}
+
+func newLFU() *lfu {
+	return &lfu{items: map[string]*list.Element{}, freqs: map[int]*list.List{}}
+}
+
+func (p *lfu) push(k string, freq int) {
+	l, ok := p.freqs[freq]
+	if !ok {
+		l = list.New()
+		p.freqs[freq] = l
+	}
+	p.items[k] = l.PushFront(&lfuEntry{key: k, freq: freq})
+}
+
+func (p *lfu) unlink(e *list.Element) *lfuEntry {
+	ent := e.Value.(*lfuEntry)
+	l := p.freqs[ent.freq]
+	l.Remove(e)
+	if l.Len() == 0 {
+		delete(p.freqs, ent.freq)
+	}
+	return ent
+}
+
+func (p *lfu) touch(k string) {
+	if e, ok := p.items[k]; ok {
+		ent := p.unlink(e)
+		if ent.freq == p.minFreq && p.freqs[ent.freq] == nil {
+			p.minFreq++
+		}
+		p.push(k, ent.freq+1)
+		return
+	}
+	p.push(k, 1)
+	p.minFreq = 1
+}
+
+func (p *lfu) has(k string) bool {
+	_, ok := p.items[k]
+	return ok
+}
+
+func (p *lfu) remove(k string) {
+	if e, ok := p.items[k]; ok {
+		p.unlink(e)
+		delete(p.items, k)
+	}
+}
+
+func (p *lfu) victim() (string, bool) {
+	if len(p.items) == 0 {
+		return "", false
+	}
+	// minFreq may be stale after remove
+	if p.freqs[p.minFreq] == nil {
+		p.minFreq = 0
+		for f := range p.freqs {
+			if p.minFreq == 0 || f < p.minFreq {
+				p.minFreq = f
+			}
+		}
+	}
+	return p.freqs[p.minFreq].Back().Value.(*lfuEntry).key, true
+}
+
+func (p *lfu) reset() {
+	p.items = map[string]*list.Element{}
+	p.freqs = map[int]*list.List{}
+	p.minFreq = 0
+}
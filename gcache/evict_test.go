@@ -0,0 +1,180 @@
+package gcache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBoundedLRU(t *testing.T) {
+	tc := NewBoundedBucketCache(DefaultExpiration, 0, 1, BoundOptions{MaxEntries: 3})
+	var evicted []string
+	tc.OnEvictedWithReason(func(k string, v interface{}, reason EvictReason) {
+		if reason != EvictCapacity {
+			t.Error("unexpected eviction reason:", k, reason)
+		}
+		evicted = append(evicted, k)
+	})
+
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, DefaultExpiration)
+	// a becomes the most recently used item
+	tc.Get("a")
+	tc.Set("d", 4, DefaultExpiration)
+
+	if _, found := tc.Get("b"); found {
+		t.Error("b was not evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, found := tc.Get(k); !found {
+			t.Error(k, "was evicted")
+		}
+	}
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("evicted items are not [b]:", evicted)
+	}
+	if s := tc.Stats(); s.Hits != 4 || s.Misses != 1 || s.Evictions != 1 {
+		t.Error("unexpected stats:", s)
+	}
+}
+
+func TestBoundedLFU(t *testing.T) {
+	tc := NewBoundedBucketCache(DefaultExpiration, 0, 1, BoundOptions{Policy: PolicyLFU, MaxEntries: 3})
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, DefaultExpiration)
+	tc.Get("a")
+	tc.Get("a")
+	tc.Get("b")
+	tc.Get("c")
+	tc.Get("c")
+	tc.Set("d", 4, DefaultExpiration)
+
+	// b has the lowest frequency
+	if _, found := tc.Get("b"); found {
+		t.Error("b was not evicted")
+	}
+	// d is the only item used once
+	tc.Set("e", 5, DefaultExpiration)
+	if _, found := tc.Get("d"); found {
+		t.Error("d was not evicted")
+	}
+
+	tc.Delete("a")
+	tc.Set("f", 6, DefaultExpiration)
+	if n := tc.ItemsCount()[0]; n != 3 {
+		t.Error("item count is not 3:", n)
+	}
+}
+
+func TestBoundedMaxBytes(t *testing.T) {
+	tc := NewBoundedBucketCache(DefaultExpiration, 0, 1, BoundOptions{MaxBytes: 20})
+	tc.Set("a", "123456789", DefaultExpiration)
+	tc.Set("b", "123456789", DefaultExpiration)
+	if n := tc.ItemsCount()[0]; n != 2 {
+		t.Error("item count is not 2:", n)
+	}
+	tc.Set("c", "123456789", DefaultExpiration)
+	if _, found := tc.Get("a"); found {
+		t.Error("a was not evicted")
+	}
+
+	// replacing an item updates its size
+	tc.Set("b", "1", DefaultExpiration)
+	tc.Set("d", "12345", DefaultExpiration)
+	if n := tc.ItemsCount()[0]; n != 3 {
+		t.Error("item count is not 3:", n)
+	}
+
+	tc.Flush()
+	tc.Set("e", "123456789", DefaultExpiration)
+	tc.Set("f", "123456789", DefaultExpiration)
+	if n := tc.ItemsCount()[0]; n != 2 {
+		t.Error("item count after flush is not 2:", n)
+	}
+}
+
+func TestEvictReason(t *testing.T) {
+	tc := NewBucketCache(DefaultExpiration, 0, 1)
+	reasons := map[string]EvictReason{}
+	tc.OnEvictedWithReason(func(k string, v interface{}, reason EvictReason) {
+		reasons[k] = reason
+	})
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, time.Millisecond)
+	tc.Delete("a")
+	<-time.After(5 * time.Millisecond)
+	tc.DeleteExpired()
+
+	if reasons["a"] != EvictDeleted || reasons["b"] != EvictExpired {
+		t.Error("unexpected eviction reasons:", reasons)
+	}
+	if s := tc.Stats(); s.Expirations != 1 || s.Evictions != 0 {
+		t.Error("unexpected stats:", s)
+	}
+
+	// OnEvicted still works without a reason
+	var evicted string
+	tc.OnEvicted(func(k string, v interface{}) {
+		evicted = k
+	})
+	tc.Set("c", 3, DefaultExpiration)
+	tc.Delete("c")
+	if evicted != "c" {
+		t.Error("c was not passed to OnEvicted")
+	}
+}
+
+func TestBoundedConcurrent(t *testing.T) {
+	tc := NewBoundedBucketCache(DefaultExpiration, 0, 4, BoundOptions{Policy: PolicyLFU, MaxEntries: 100})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				k := strconv.Itoa((n*1000 + j) % 300)
+				tc.Set(k, j, DefaultExpiration)
+				tc.Get(k)
+				if j%10 == 0 {
+					tc.Delete(k)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, n := range tc.ItemsCount() {
+		if n > 25 {
+			t.Error("shard exceeds its limit:", n)
+		}
+	}
+}
+
+func TestBoundedIncrement(t *testing.T) {
+	// an item's size is its value
+	tc := NewBoundedBucketCache(DefaultExpiration, 0, 1, BoundOptions{
+		MaxBytes: 10,
+		Sizer: func(k string, x interface{}) int64 {
+			return int64(x.(int))
+		},
+	})
+	tc.Set("a", 4, DefaultExpiration)
+	tc.Set("b", 4, DefaultExpiration)
+	if err := tc.Increment("b", 4); err != nil {
+		t.Error(err)
+	}
+	if _, found := tc.Get("a"); found {
+		t.Error("a was not evicted after incrementing b")
+	}
+
+	// decrementing frees the space again
+	if _, err := tc.DecrementInt("b", 6); err != nil {
+		t.Error(err)
+	}
+	tc.Set("c", 8, DefaultExpiration)
+	if n := tc.ItemsCount()[0]; n != 2 {
+		t.Error("item count is not 2:", n)
+	}
+}
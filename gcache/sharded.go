@@ -233,12 +233,39 @@ func (sc *shardedCache) DeleteExpired() {
 	}
 }
 
+// Sets an (optional) function that is called with the key and value when an
+// item is removed from the cache. A cache holds a single eviction function:
+// this replaces any function set with OnEvictedWithReason, and calling
+// OnEvictedWithReason afterwards replaces f. Set to nil to disable.
 func (sc *shardedCache) OnEvicted(f func(string, interface{})) {
 	for _, v := range sc.cs {
 		v.addEvicted(f)
 	}
 }
 
+// Sets an (optional) function that is called with the key, value and the
+// reason when an item is removed from the cache. A cache holds a single
+// eviction function: this replaces any function set with OnEvicted, and calling
+// OnEvicted afterwards replaces f. Set to nil to disable.
+func (sc *shardedCache) OnEvictedWithReason(f func(string, interface{}, EvictReason)) {
+	for _, v := range sc.cs {
+		v.addEvictedReason(f)
+	}
+}
+
+// Returns the hit, miss and eviction counters summed over all shards.
+func (sc *shardedCache) Stats() Stats {
+	var res Stats
+	for _, v := range sc.cs {
+		s := v.stats()
+		res.Hits += s.Hits
+		res.Misses += s.Misses
+		res.Evictions += s.Evictions
+		res.Expirations += s.Expirations
+	}
+	return res
+}
+
 // Returns the items in the cache. This may include items that have expired,
 // but have not yet been cleaned up. If this is significant, the Expiration
 // fields of the items should be checked. Note that explicit synchronization
@@ -330,7 +357,7 @@ func runShardedJanitor(sc *shardedCache, ci time.Duration) {
 	go j.Run(sc)
 }
 
-func newShardedCache(n int, de time.Duration) *shardedCache {
+func newShardedCache(n int, de time.Duration, opt BoundOptions) *shardedCache {
 	max := big.NewInt(0).SetUint64(uint64(math.MaxUint32))
 	rnd, err := rand.Int(rand.Reader, max)
 	var seed uint32
@@ -349,6 +376,7 @@ func newShardedCache(n int, de time.Duration) *shardedCache {
 		c := &cache{
 			defaultExpiration: de,
 			items:             map[string]Item{},
+			bound:             newBound(opt, n),
 		}
 		sc.cs[i] = c
 	}
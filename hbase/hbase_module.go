@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/GitHub121380/golib/metrics"
 	"github.com/GitHub121380/golib/pool"
 	"github.com/GitHub121380/golib/ral"
 	"github.com/GitHub121380/golib/utils"
@@ -12,6 +13,7 @@ import (
 	"go.uber.org/zap"
 	"net"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,10 +22,15 @@ type HbaseClientModule struct {
 	Password string
 	Service  string
 
-	pool pool.Pool
+	pool    pool.Pool
+	timeout time.Duration
 
 	ins *ral.Instance
 	res *ral.Resource
+
+	// GetInstance 选取的实例，Exec 完成后释放
+	acquired *ral.Instance
+	released int32
 }
 
 type Pool struct {
@@ -37,11 +44,18 @@ type HBasePoolClient struct {
 }
 
 func (h *HbaseClientModule) Exec(ctx *gin.Context, efunc func(c *HbaseClient) error) (err error) {
-	zlog.CreateSpan(ctx)
+	span := zlog.StartSpan(ctx, "hbase:"+h.Service)
+	done := metrics.Client("hbase", h.Service, "exec")
 	start := time.Now()
 	remoteIp, remotePort := h.ins.IP, h.ins.Port
-	retry := h.ins.Retry(func(res *ral.Resource, ins *ral.Instance) bool {
+	retry := h.ins.RetryContext(ctx, func(res *ral.Resource, ins *ral.Instance) bool {
 		if r, ok := ins.Client.(*HbaseClientModule); ok {
+			// 根据调用方剩余时间计算超时
+			var timeout time.Duration
+			if timeout, err = ral.Budget(ctx, r.timeout); err != nil {
+				return false
+			}
+
 			var conn interface{}
 			conn, err = r.pool.Get(ctx)
 			if err != nil {
@@ -53,6 +67,10 @@ func (h *HbaseClientModule) Exec(ctx *gin.Context, efunc func(c *HbaseClient) er
 			if !ok || c == nil || c.Client == nil {
 				return true
 			}
+			if s, ok := c.Trans.(*thrift.TSocket); ok && timeout != r.timeout {
+				_ = s.SetTimeout(timeout)
+				defer s.SetTimeout(r.timeout)
+			}
 
 			if err = efunc(c.Client); err == nil {
 				return false
@@ -79,9 +97,24 @@ func (h *HbaseClientModule) Exec(ctx *gin.Context, efunc func(c *HbaseClient) er
 	}
 
 	zlog.InfoLogger(ctx, msg, fields...)
+
+	span.SetTag("prot", "hbase")
+	span.SetTag("remoteAddr", fmt.Sprintf("%s:%d", remoteIp, remotePort))
+	span.SetTag("retry", retry)
+	span.SetError(err)
+	span.Finish()
+	done(err)
+	h.release()
 	return
 }
 
+// 释放 GetInstance 时占用的实例，只释放一次
+func (h *HbaseClientModule) release() {
+	if h.acquired != nil && atomic.CompareAndSwapInt32(&h.released, 0, 1) {
+		h.acquired.Release()
+	}
+}
+
 var mod *ral.Module
 
 func init() {
@@ -165,8 +198,15 @@ func init() {
 
 			client := &HbaseClientModule{
 				pool:    p,
+				timeout: timeout,
 				Service: res.Name,
 			}
+			if s, ok := p.(pool.StatsPool); ok {
+				metrics.RegisterPool("hbase", res.Name, addr, func() metrics.PoolStats {
+					stats := s.Stats()
+					return metrics.PoolStats{Active: stats.Active, Idle: stats.Idle}
+				})
+			}
 			sub := &ral.Instance{
 				IP:     ins.IP,
 				Port:   ins.Port,
@@ -177,13 +217,26 @@ func init() {
 			return sub, nil
 		},
 		Remove: func(self *ral.Instance, res *ral.Resource, ins *ral.Instance) bool {
-			// 关闭当前实例的连接池
-			if r, ok := ins.Client.(*HbaseClientModule); ok && r.pool != nil {
-				r.pool.Release()
+			// ins 可能是 zns 的父实例，关闭本模块创建的 self 的连接池
+			if r, ok := self.Client.(*HbaseClientModule); ok {
+				metrics.UnregisterPool("hbase", r.Service, net.JoinHostPort(self.IP, strconv.Itoa(self.Port)))
+				if r.pool != nil {
+					r.pool.Release()
+				}
 			}
 			zlog.DebugLogger(nil, "release connections at: "+time.Now().String())
 			return true
 		},
+		Check: func(res *ral.Resource, ins *ral.Instance, timeout time.Duration) error {
+			trans, err := thrift.NewTSocketTimeout(net.JoinHostPort(ins.IP, strconv.Itoa(ins.Port)), timeout)
+			if err != nil {
+				return err
+			}
+			if err = trans.Open(); err != nil {
+				return err
+			}
+			return trans.Close()
+		},
 		Method: func(ctx *gin.Context, res *ral.Resource, ins *ral.Instance, method string, data map[string]interface{}, head map[string]string) (interface{}, error) {
 			return nil, nil
 		}}
@@ -196,10 +249,23 @@ func GetInstance(ctx *gin.Context, service string) (*HbaseClientModule, error) {
 		zlog.ErrorLogger(ctx, "module hbase not found clients", zap.String("prot", "hbase"))
 		return nil, ral.ERR_NOT_FOUND_INSTANCE
 	}
+
 	p, ok := ins.Client.(*HbaseClientModule)
 	if !ok {
+		ins.Release()
 		zlog.ErrorLogger(ctx, "module hbase get clients error", zap.String("prot", "hbase"))
 		return nil, ral.ERR_NOT_FOUND_CLIENT
 	}
-	return p, nil
+	// 每次调用使用独立的客户端，Exec 完成后释放实例，使调用计入实例的并发数
+	c := &HbaseClientModule{
+		Username: p.Username,
+		Password: p.Password,
+		Service:  p.Service,
+		pool:     p.pool,
+		timeout:  p.timeout,
+		ins:      ins,
+		res:      p.res,
+		acquired: ins,
+	}
+	return c, nil
 }
@@ -21,6 +21,8 @@ const (
 	HEAD_PARAM_BOUNCE = "_bounce"
 	// 返回的数据不进行解包
 	HEAD_NO_UNPACK = "_no_unpack"
+	// 对冲请求的延迟，单位毫秒，仅用于幂等请求，为空时使用资源配置
+	HEAD_HEDGE = "_hedge"
 )
 
 const (
@@ -49,6 +51,9 @@ type Request struct {
 
 	Meta map[string]interface{}
 
+	// 本次请求的超时时间
+	Timeout time.Duration
+
 	StatusCode int
 	Status     string
 
@@ -85,6 +90,13 @@ func (client *Client) Check(ctx *gin.Context, req *Request) error {
 	}
 	req.Header[HEAD_UAGENT] = ral.AppInfo.Agent
 
+	// 根据调用方剩余时间计算超时
+	if timeout, err := ral.Budget(ctx, client.ReadTimeOut); err != nil {
+		return err
+	} else {
+		req.Timeout = timeout
+	}
+
 	if p, ok := req.Header[HEAD_PATH]; !ok || p == "" {
 		return ral.ERR_NOT_FOUND_PATH
 	} else {
@@ -112,7 +124,7 @@ func (client *Client) Check(ctx *gin.Context, req *Request) error {
 	data := map[string]interface{}{}
 	for k, v := range req.Data {
 		switch k {
-		case ral.DATA_CONTEXT:
+		case ral.DATA_CONTEXT, dataStatus:
 		default:
 			data[k] = v
 		}
@@ -135,8 +147,13 @@ func (client *Client) Check(ctx *gin.Context, req *Request) error {
 }
 
 func (client *Client) Send(req *Request) ([]byte, error) {
+	timeout := client.ReadTimeOut
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
+
 	goreq := gorequest.New()
-	goreq = goreq.Timeout(client.ReadTimeOut)
+	goreq = goreq.Timeout(timeout)
 	goreq.ClearSuperAgent()
 
 	uri, query := req.URI, ""
@@ -26,6 +26,9 @@ func Encode(Type string, Data map[string]interface{}) (interface{}, error) {
 						val = string(res)
 					}
 				}
+			case json.Number:
+				// 表单中的数值直接使用原始文本
+				val = v.String()
 			default:
 				if res, err := jsoniter.Marshal(v); err != nil {
 					return nil, err
@@ -9,6 +9,8 @@ import (
 	"go.uber.org/zap"
 
 	"fmt"
+	gohttp "net/http"
+	"strconv"
 	"time"
 )
 
@@ -45,6 +47,22 @@ func init() {
 		Remove: func(self *ral.Instance, res *ral.Resource, ins *ral.Instance) bool {
 			return true
 		},
+		Check: func(res *ral.Resource, ins *ral.Instance, timeout time.Duration) error {
+			path := res.Snapshot().HealthCheck.Path
+			if path == "" {
+				return ral.CheckTCP(ins, timeout)
+			}
+			client := &gohttp.Client{Timeout: timeout}
+			resp, err := client.Get(fmt.Sprintf("http://%s:%d%s", ins.IP, ins.Port, path))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= gohttp.StatusBadRequest {
+				return fmt.Errorf("health check status %d", resp.StatusCode)
+			}
+			return nil
+		},
 		Method: func(ctx *gin.Context, res *ral.Resource, ins *ral.Instance, method string,
 			data map[string]interface{}, head map[string]string) (interface{}, error) {
 			start := time.Now()
@@ -55,6 +73,7 @@ func init() {
 				zap.String("conv", res.Encode),
 				zap.String("requestStartTime", utils.GetFormatRequestTime(start)),
 				zap.String("req_uri", head[HEAD_PATH]),
+				zap.Int("hedge", ral.HedgeIndex(ctx)),
 			}
 			client, ok := ins.Client.(*Client)
 			if !ok {
@@ -68,6 +87,7 @@ func init() {
 			}
 
 			buf, err := client.Send(req)
+			recordStatus(data, req.StatusCode)
 
 			fields = append(fields,
 				zap.String("remoteIp", client.Host),
@@ -102,15 +122,37 @@ func init() {
 
 func Call(ctx *gin.Context, method string, service string, data map[string]interface{},
 	head map[string]string) (buf []byte, err error) {
-	ins, err := ral.GetInstance(ctx, ral.TYPE_HTTP, service)
+	return CallByKey(ctx, method, service, "", data, head)
+}
+
+// 按key通过一致性hash选取实例，相同的key会请求到同一个实例
+func CallByKey(ctx *gin.Context, method string, service string, key string, data map[string]interface{},
+	head map[string]string) (buf []byte, err error) {
+	ins, err := ral.GetInstanceByKey(ctx, ral.TYPE_HTTP, service, key)
 	if err != nil {
 		zlog.WarnLogger(ctx, "GetInstance error: "+err.Error(), zap.String("service", service), zap.String("prot", "http"))
 		return nil, err
 	}
 	defer ins.Release()
 
-	ins.Retry(func(res *ral.Resource, ins *ral.Instance) bool {
-		if b, e := ins.Request(ctx, method, data, head); e == nil {
+	// 镜像请求需要在主请求修改 header 前复制参数
+	mirror := newMirror(ctx, method, service, key, data, head)
+	data = mirror.wrap(data)
+	defer func() { mirror.send(buf, err) }()
+
+	delay := hedgeDelay(head)
+	hedged := 0
+	ins.RetryContext(ctx, func(res *ral.Resource, ins *ral.Instance) bool {
+		b, n, e := ral.Hedge(ctx, ins, delay, func(ctx *gin.Context, ins *ral.Instance) (interface{}, error) {
+			// 对冲请求并发执行，header 需要各自复制一份
+			h := make(map[string]string, len(head))
+			for k, v := range head {
+				h[k] = v
+			}
+			return ins.Request(ctx, method, data, h)
+		})
+		hedged += n
+		if e == nil {
 			buf, _ = b.([]byte)
 			return false
 		} else {
@@ -125,6 +167,7 @@ func Call(ctx *gin.Context, method string, service string, data map[string]inter
 			zap.String("service", service),
 			zap.String("remoteIp", fmt.Sprintf("%s:%d", ins.IP, ins.Port)),
 			zap.String("module", env.GetAppName()),
+			zap.Int("hedged", hedged),
 			zap.String("error", err.Error()),
 		}
 		zlog.WarnLogger(ctx, "call failed", field...)
@@ -132,6 +175,20 @@ func Call(ctx *gin.Context, method string, service string, data map[string]inter
 
 	return buf, err
 }
+
+// 读取并移除 header 中的对冲延迟
+func hedgeDelay(head map[string]string) time.Duration {
+	v, ok := head[HEAD_HEDGE]
+	if !ok {
+		return 0
+	}
+	delete(head, HEAD_HEDGE)
+	if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return 0
+}
+
 func Post(ctx *gin.Context, service string, data map[string]interface{}, head map[string]string) ([]byte, error) {
 	if ctx != nil {
 		if data != nil {
@@ -0,0 +1,176 @@
+package http
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"math/rand"
+	gohttp "net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/utils"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// 镜像请求在 context 中的标记，镜像请求不会再次被镜像
+	ContextKeyMirror = "_ral_mirror"
+	// 请求参数中记录响应状态码的字段，不会发送给下游
+	dataStatus = "_ral_status"
+)
+
+// 一次镜像调用，请求参数在主请求发出前复制
+type mirror struct {
+	ctx     *gin.Context
+	service string
+	target  string
+	method  string
+	key     string
+	data    map[string]interface{}
+	head    map[string]string
+	diff    bool
+
+	// 主请求的状态码
+	status int32
+}
+
+// 返回请求是否为镜像请求
+func IsMirror(ctx *gin.Context) bool {
+	return ctx != nil && ctx.GetBool(ContextKeyMirror)
+}
+
+// 按资源的镜像配置采样，未命中时返回 nil
+func newMirror(ctx *gin.Context, method string, service string, key string, data map[string]interface{},
+	head map[string]string) *mirror {
+	if IsMirror(ctx) {
+		return nil
+	}
+	res, ok := ral.GetResource(ral.TYPE_HTTP, service)
+	if !ok {
+		return nil
+	}
+	conf := res.Snapshot().Mirror
+	if !conf.Enable || conf.Target == "" || conf.Target == service {
+		return nil
+	}
+	if conf.Percent > 0 && conf.Percent < 100 && rand.Intn(100) >= conf.Percent {
+		return nil
+	}
+
+	m := &mirror{
+		ctx:     mirrorContext(ctx),
+		service: service,
+		target:  conf.Target,
+		method:  method,
+		key:     key,
+		data:    make(map[string]interface{}, len(data)),
+		head:    make(map[string]string, len(head)),
+		diff:    conf.Diff,
+	}
+	for k, v := range data {
+		m.data[k] = v
+	}
+	if _, ok := data[ral.DATA_CONTEXT]; ok {
+		m.data[ral.DATA_CONTEXT] = m.ctx
+	}
+	for k, v := range head {
+		m.head[k] = v
+	}
+	return m
+}
+
+// 复制 context 并与调用方的超时及取消解绑，主请求结束后镜像请求仍可完成
+func mirrorContext(ctx *gin.Context) *gin.Context {
+	var cp *gin.Context
+	if ctx != nil {
+		cp = ctx.Copy()
+	} else {
+		cp = &gin.Context{}
+	}
+
+	if cp.Request != nil {
+		cp.Request = cp.Request.WithContext(context.Background())
+	} else {
+		cp.Request = (&gohttp.Request{Header: gohttp.Header{}}).WithContext(context.Background())
+	}
+	cp.Set(ContextKeyMirror, true)
+	return cp
+}
+
+// 主请求需要记录状态码时，返回带有状态码字段的请求参数
+func (m *mirror) wrap(data map[string]interface{}) map[string]interface{} {
+	if m == nil || !m.diff {
+		return data
+	}
+	d := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		d[k] = v
+	}
+	d[dataStatus] = &m.status
+	return d
+}
+
+// 异步发送镜像请求，响应丢弃，错误只记录日志
+func (m *mirror) send(buf []byte, err error) {
+	if m == nil {
+		return
+	}
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				zlog.ErrorLogger(m.ctx, "http mirror panic", zap.String("prot", "http"), zap.Any("panic", r))
+			}
+		}()
+
+		var status int32
+		if m.diff {
+			m.data[dataStatus] = &status
+		}
+
+		start := time.Now()
+		mbuf, merr := CallByKey(m.ctx, m.method, m.target, m.key, m.data, m.head)
+		fields := []zap.Field{
+			zap.String("prot", "http"),
+			zap.String("method", m.method),
+			zap.String("service", m.service),
+			zap.String("mirror", m.target),
+			zap.String("req_uri", m.head[HEAD_PATH]),
+			zap.Float64("cost", utils.GetRequestCost(start, time.Now())),
+		}
+		if merr != nil {
+			zlog.WarnLogger(m.ctx, "http mirror error: "+merr.Error(), fields...)
+			return
+		}
+		if !m.diff || err != nil {
+			return
+		}
+
+		code, mcode := atomic.LoadInt32(&m.status), atomic.LoadInt32(&status)
+		hash, mhash := bodyHash(buf), bodyHash(mbuf)
+		fields = append(fields,
+			zap.Int32("prot_code", code),
+			zap.Int32("mirror_code", mcode),
+			zap.String("body_md5", hash),
+			zap.String("mirror_md5", mhash),
+			zap.Bool("diff", code != mcode || hash != mhash),
+		)
+		zlog.InfoLogger(m.ctx, "http mirror diff", fields...)
+	}()
+}
+
+// 记录请求的响应状态码
+func recordStatus(data map[string]interface{}, code int) {
+	if p, ok := data[dataStatus].(*int32); ok {
+		atomic.StoreInt32(p, int32(code))
+	}
+}
+
+func bodyHash(buf []byte) string {
+	sum := md5.Sum(buf)
+	return hex.EncodeToString(sum[:])
+}
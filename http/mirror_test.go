@@ -0,0 +1,79 @@
+package http
+
+import (
+	"net"
+	gohttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/zlog"
+	"go.uber.org/zap"
+)
+
+func init() {
+	zlog.ModuleLogger = zap.NewNop()
+	zlog.SetSpanExporter(nil)
+}
+
+func addTestResource(t *testing.T, name string, url string) *ral.Resource {
+	host, p, err := net.SplitHostPort(url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(p)
+
+	res := ral.AddResource(&ral.Resource{Type: ral.TYPE_HTTP, Name: name, Encode: ENCODE_FORM})
+	_, err = ral.AddInstance(ral.TYPE_HTTP, name, &ral.Instance{IP: host, Port: port, Client: &Client{
+		IP: host, Port: port, ReadTimeOut: time.Second,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestMirror(t *testing.T) {
+	primary := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer primary.Close()
+
+	hits := make(chan string, 1)
+	shadow := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		hits <- r.URL.Path + "?" + r.URL.RawQuery
+		w.WriteHeader(gohttp.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	res := addTestResource(t, "mirror_primary", primary.URL)
+	res.Mirror.Enable, res.Mirror.Target, res.Mirror.Diff = true, "mirror_shadow", true
+	addTestResource(t, "mirror_shadow", shadow.URL)
+
+	buf, err := Get(nil, "mirror_primary", map[string]interface{}{"id": 1}, map[string]string{HEAD_PATH: "/user"})
+	if err != nil || string(buf) != "primary" {
+		t.Fatalf("unexpected response %s %v", buf, err)
+	}
+
+	select {
+	case uri := <-hits:
+		if uri != "/user?id=1" {
+			t.Fatalf("unexpected mirror request %s", uri)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("mirror request not sent")
+	}
+
+	// 关闭镜像后不再发送
+	res.Mirror.Enable = false
+	if _, err := Get(nil, "mirror_primary", nil, map[string]string{HEAD_PATH: "/user"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case uri := <-hits:
+		t.Fatalf("unexpected mirror request %s", uri)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
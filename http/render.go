@@ -0,0 +1,120 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/GitHub121380/golib/base"
+	"github.com/gin-gonic/gin"
+)
+
+var ERR_RESPONSE_FORMAT = errors.New("response is not a render envelope")
+
+// 以结构体发起 POST 请求，响应按 base.DefaultRender 解析，data 写入 resp
+func PostData(ctx *gin.Context, service string, path string, req interface{}, resp interface{}) error {
+	return CallData(ctx, METHOD_POST, service, path, req, resp)
+}
+
+// 以结构体发起 GET 请求，响应按 base.DefaultRender 解析，data 写入 resp
+func GetData(ctx *gin.Context, service string, path string, req interface{}, resp interface{}) error {
+	return CallData(ctx, METHOD_GET, service, path, req, resp)
+}
+
+// 请求参数按 json tag 转换后使用资源配置的编码方式发送，errNo 非0时返回 base.Error
+func CallData(ctx *gin.Context, method string, service string, path string, req interface{}, resp interface{}) error {
+	data, err := ToData(req)
+	if err != nil {
+		return err
+	}
+
+	head := map[string]string{HEAD_PATH: path}
+
+	var buf []byte
+	if method == METHOD_GET {
+		buf, err = Get(ctx, service, data, head)
+	} else {
+		buf, err = Post(ctx, service, data, head)
+	}
+	if err != nil {
+		return err
+	}
+	return Unpack(buf, resp)
+}
+
+// 将请求结构体转换为请求参数，字段名使用 json tag，数值保留为 json.Number
+func ToData(req interface{}) (map[string]interface{}, error) {
+	switch req := req.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		data := make(map[string]interface{}, len(req))
+		for k, v := range req {
+			data[k] = v
+		}
+		return data, nil
+	}
+
+	if v := reflect.ValueOf(req); v.Kind() == reflect.Ptr && v.IsNil() {
+		return map[string]interface{}{}, nil
+	}
+
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// 解析 base.DefaultRender 格式的响应，errNo 非0时返回 base.Error
+func Unpack(buf []byte, resp interface{}) error {
+	var render struct {
+		ErrNo  json.RawMessage `json:"errNo"`
+		ErrMsg string          `json:"errMsg"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(buf, &render); err != nil {
+		return err
+	}
+	if len(render.ErrNo) == 0 {
+		return ERR_RESPONSE_FORMAT
+	}
+
+	// mcpack 解码后的数值为字符串
+	errNo, err := strconv.Atoi(string(bytes.Trim(render.ErrNo, `"`)))
+	if err != nil {
+		return ERR_RESPONSE_FORMAT
+	}
+	if errNo != 0 {
+		return base.Error{ErrNo: errNo, ErrMsg: render.ErrMsg}
+	}
+
+	if resp == nil || len(render.Data) == 0 || string(render.Data) == "null" {
+		return nil
+	}
+
+	err = json.Unmarshal(render.Data, resp)
+	if err == nil || render.Data[0] != '"' {
+		return err
+	}
+	// mcpack 解码后的 data 为 JSON 字符串，直接解码失败时解析字符串中的对象或数组
+	var s string
+	if json.Unmarshal(render.Data, &s) != nil {
+		return err
+	}
+	if s = strings.TrimSpace(s); s == "" || (s[0] != '{' && s[0] != '[') {
+		return err
+	}
+	return json.Unmarshal([]byte(s), resp)
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/GitHub121380/golib/base"
+)
+
+type renderUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestUnpack(t *testing.T) {
+	var user renderUser
+	if err := Unpack([]byte(`{"errNo":0,"errMsg":"succ","data":{"name":"tom","age":18}}`), &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "tom" || user.Age != 18 {
+		t.Fatalf("unexpected data %+v", user)
+	}
+
+	// mcpack 解码后的响应
+	user = renderUser{}
+	if err := Unpack([]byte(`{"errNo":"0","errMsg":"succ","data":"{\"name\":\"jim\"}"}`), &user); err != nil || user.Name != "jim" {
+		t.Fatalf("unexpected data %+v %v", user, err)
+	}
+
+	// 字符串类型的 data 直接解码
+	for _, v := range []string{"ok", "123", "{x"} {
+		var str string
+		body, _ := json.Marshal(map[string]interface{}{"errNo": 0, "errMsg": "succ", "data": v})
+		if err := Unpack(body, &str); err != nil || str != v {
+			t.Fatalf("unexpected data %q %v", str, err)
+		}
+	}
+
+	err := Unpack([]byte(`{"errNo":3001,"errMsg":"not found","data":{}}`), &user)
+	if e, ok := err.(base.Error); !ok || e.ErrNo != 3001 || e.ErrMsg != "not found" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := Unpack([]byte(`{"name":"tom"}`), &user); err != ERR_RESPONSE_FORMAT {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestToData(t *testing.T) {
+	data, err := ToData(&renderUser{Name: "tom", Age: 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["name"] != "tom" || data["age"] != json.Number("18") {
+		t.Fatalf("unexpected data %v", data)
+	}
+
+	// JSON 编码时数值保持为数字，表单编码时使用原始文本
+	if buf, err := json.Marshal(data); err != nil || string(buf) != `{"age":18,"name":"tom"}` {
+		t.Fatalf("unexpected json %v %v", buf, err)
+	}
+	if buf, err := Encode(ENCODE_FORM, map[string]interface{}{"age": data["age"]}); err != nil || buf != "age=18" {
+		t.Fatalf("unexpected form %v %v", buf, err)
+	}
+
+	if data, err := ToData(nil); err != nil || len(data) != 0 {
+		t.Fatalf("unexpected data %v %v", data, err)
+	}
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 服务端请求统计
+var (
+	ServerDuration = NewHistogramVec("golib_server_request_duration_seconds",
+		"Server request latency in seconds.", DefBuckets, "prot", "method", "path", "code")
+	ServerInflight = NewGaugeVec("golib_server_requests_in_flight",
+		"Server requests currently being handled.", "prot")
+)
+
+// 下游调用统计
+var (
+	ClientDuration = NewHistogramVec("golib_client_request_duration_seconds",
+		"Downstream call latency in seconds.", DefBuckets, "prot", "service", "method", "code")
+	ClientErrors = NewCounterVec("golib_client_errors_total",
+		"Downstream calls that returned an error.", "prot", "service", "method")
+	ClientInflight = NewGaugeVec("golib_client_requests_in_flight",
+		"Downstream calls currently in flight.", "prot", "service")
+)
+
+const (
+	CodeOK    = "ok"
+	CodeError = "error"
+)
+
+// 开始统计一次服务端请求，处理结束时调用返回的函数
+func Server(prot string) func(method, path, code string) {
+	start := time.Now()
+	ServerInflight.Inc(prot)
+	return func(method, path, code string) {
+		ServerInflight.Dec(prot)
+		ServerDuration.Observe(time.Since(start).Seconds(), prot, method, path, code)
+	}
+}
+
+// 开始统计一次下游调用，调用结束时传入错误
+func Client(prot, service, method string) func(err error) {
+	start := time.Now()
+	ClientInflight.Inc(prot, service)
+	return func(err error) {
+		ClientInflight.Dec(prot, service)
+		code := CodeOK
+		if err != nil {
+			code = CodeError
+			ClientErrors.Inc(prot, service, method)
+		}
+		ClientDuration.Observe(time.Since(start).Seconds(), prot, service, method, code)
+	}
+}
+
+// 连接池统计
+type PoolStats struct {
+	// 当前连接数，包括空闲连接
+	Active int
+	// 空闲连接数
+	Idle int
+}
+
+var pools sync.Map
+
+func init() {
+	NewGaugeFunc("golib_pool_connections", "Connections held by client pools.",
+		[]string{"prot", "service", "addr", "state"},
+		func(observe func(f float64, values ...string)) {
+			var keys []string
+			stats := map[string]func() PoolStats{}
+			pools.Range(func(k, v interface{}) bool {
+				keys = append(keys, k.(string))
+				stats[k.(string)] = v.(func() PoolStats)
+				return true
+			})
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				s := stats[key]()
+				l := strings.SplitN(key, "\xff", 3)
+				observe(float64(s.Active), l[0], l[1], l[2], "active")
+				observe(float64(s.Idle), l[0], l[1], l[2], "idle")
+			}
+		})
+}
+
+// 注册连接池，输出指标时调用 stats 获取连接数
+func RegisterPool(prot, service, addr string, stats func() PoolStats) {
+	pools.Store(prot+"\xff"+service+"\xff"+addr, stats)
+}
+
+func UnregisterPool(prot, service, addr string) {
+	pools.Delete(prot + "\xff" + service + "\xff" + addr)
+}
+
+// 连接池及当前连接数
+type Pool struct {
+	Prot    string `json:"prot"`
+	Service string `json:"service"`
+	Addr    string `json:"addr"`
+	Active  int    `json:"active"`
+	Idle    int    `json:"idle"`
+}
+
+// 返回所有已注册连接池的连接数
+func Pools() []Pool {
+	var list []Pool
+	pools.Range(func(k, v interface{}) bool {
+		l := strings.SplitN(k.(string), "\xff", 3)
+		s := v.(func() PoolStats)()
+		list = append(list, Pool{Prot: l[0], Service: l[1], Addr: l[2], Active: s.Active, Idle: s.Idle})
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Prot != list[j].Prot {
+			return list[i].Prot < list[j].Prot
+		}
+		if list[i].Service != list[j].Service {
+			return list[i].Service < list[j].Service
+		}
+		return list[i].Addr < list[j].Addr
+	})
+	return list
+}
+
+var clients sync.Map
+
+// 注册客户端，调试接口输出 status 返回的状态
+func RegisterClient(prot, name string, status func() interface{}) {
+	clients.Store(prot+"\xff"+name, status)
+}
+
+func UnregisterClient(prot, name string) {
+	clients.Delete(prot + "\xff" + name)
+}
+
+// 返回所有已注册客户端的状态，按 prot、name 分组
+func Clients() map[string]map[string]interface{} {
+	list := map[string]map[string]interface{}{}
+	clients.Range(func(k, v interface{}) bool {
+		l := strings.SplitN(k.(string), "\xff", 2)
+		if list[l[0]] == nil {
+			list[l[0]] = map[string]interface{}{}
+		}
+		list[l[0]][l[1]] = v.(func() interface{})()
+		return true
+	})
+	return list
+}
@@ -0,0 +1,400 @@
+// Package metrics 提供兼容 Prometheus 文本格式的指标统计
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 默认的耗时分桶，单位秒
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector 负责输出一组指标
+type Collector interface {
+	Name() string
+	Write(w io.Writer)
+}
+
+// Registry 指标注册表
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]Collector{}}
+}
+
+// 默认注册表，New* 创建的指标均注册于此
+var DefaultRegistry = NewRegistry()
+
+// Register 注册指标，同名指标已存在时返回错误
+func (r *Registry) Register(c Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[c.Name()]; ok {
+		return fmt.Errorf("metrics: duplicate metric %s", c.Name())
+	}
+	r.collectors[c.Name()] = c
+	return nil
+}
+
+func (r *Registry) MustRegister(c Collector) {
+	if err := r.Register(c); err != nil {
+		panic(err)
+	}
+}
+
+// Write 按名称顺序输出所有指标
+func (r *Registry) Write(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]Collector, 0, len(names))
+	for _, name := range names {
+		list = append(list, r.collectors[name])
+	}
+	r.mu.RUnlock()
+
+	for _, c := range list {
+		c.Write(w)
+	}
+}
+
+// Handler 以 Prometheus 文本格式输出指标
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var buf bytes.Buffer
+		r.Write(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// Handler 输出默认注册表中的指标
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// 原子操作的 float64
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		n := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// 带标签的指标集合
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu       sync.RWMutex
+	children map[string]interface{}
+	values   map[string][]string
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{
+		name:     name,
+		help:     help,
+		typ:      typ,
+		labels:   labels,
+		children: map[string]interface{}{},
+		values:   map[string][]string{},
+	}
+}
+
+func (v *vec) Name() string {
+	return v.name
+}
+
+// 获取标签值对应的指标，不存在时使用 create 创建
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok = v.children[key]; !ok {
+		c = create()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// 按标签值顺序遍历
+func (v *vec) each(fn func(values []string, c interface{})) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	type item struct {
+		values []string
+		c      interface{}
+	}
+	items := make([]item, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, item{v.values[key], v.children[key]})
+	}
+	v.mu.RUnlock()
+
+	for _, it := range items {
+		fn(it.values, it.c)
+	}
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.typ)
+}
+
+// CounterVec 只增不减的计数器
+type CounterVec struct {
+	*vec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels)}
+	DefaultRegistry.MustRegister(c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.get(values, func() interface{} { return &value{} }).(*value).add(delta)
+}
+
+// Value 返回当前计数，用于测试及调试
+func (c *CounterVec) Value(values ...string) float64 {
+	return c.get(values, func() interface{} { return &value{} }).(*value).get()
+}
+
+func (c *CounterVec) Write(w io.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, v interface{}) {
+		writeSample(w, c.name, c.labels, values, "", "", v.(*value).get())
+	})
+}
+
+// GaugeVec 可增可减的瞬时值
+type GaugeVec struct {
+	*vec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels)}
+	DefaultRegistry.MustRegister(g)
+	return g
+}
+
+func (g *GaugeVec) gauge(values []string) *value {
+	return g.get(values, func() interface{} { return &value{} }).(*value)
+}
+
+func (g *GaugeVec) Set(f float64, values ...string) {
+	g.gauge(values).set(f)
+}
+
+func (g *GaugeVec) Add(delta float64, values ...string) {
+	g.gauge(values).add(delta)
+}
+
+func (g *GaugeVec) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+func (g *GaugeVec) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+func (g *GaugeVec) Value(values ...string) float64 {
+	return g.gauge(values).get()
+}
+
+func (g *GaugeVec) Write(w io.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, v interface{}) {
+		writeSample(w, g.name, g.labels, values, "", "", v.(*value).get())
+	})
+}
+
+// HistogramVec 分桶统计，用于记录耗时分布
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    value
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: buckets}
+	DefaultRegistry.MustRegister(h)
+	return h
+}
+
+func (h *HistogramVec) histogram(values []string) *histogram {
+	return h.get(values, func() interface{} {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	}).(*histogram)
+}
+
+func (h *HistogramVec) Observe(f float64, values ...string) {
+	o := h.histogram(values)
+	if i := sort.SearchFloat64s(h.buckets, f); i < len(h.buckets) {
+		atomic.AddUint64(&o.counts[i], 1)
+	}
+	atomic.AddUint64(&o.count, 1)
+	o.sum.add(f)
+}
+
+// Count 返回观测次数，用于测试及调试
+func (h *HistogramVec) Count(values ...string) uint64 {
+	return atomic.LoadUint64(&h.histogram(values).count)
+}
+
+func (h *HistogramVec) Write(w io.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, v interface{}) {
+		o := v.(*histogram)
+		var total uint64
+		for i, le := range h.buckets {
+			total += atomic.LoadUint64(&o.counts[i])
+			writeSample(w, h.name+"_bucket", h.labels, values, "le", formatFloat(le), float64(total))
+		}
+		count := atomic.LoadUint64(&o.count)
+		writeSample(w, h.name+"_bucket", h.labels, values, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, values, "", "", o.sum.get())
+		writeSample(w, h.name+"_count", h.labels, values, "", "", float64(count))
+	})
+}
+
+// GaugeFunc 在输出时通过回调采集的瞬时值，适用于连接池等外部状态
+type GaugeFunc struct {
+	*vec
+	fn func(observe func(f float64, values ...string))
+}
+
+func NewGaugeFunc(name, help string, labels []string, fn func(observe func(f float64, values ...string))) *GaugeFunc {
+	g := &GaugeFunc{vec: newVec(name, help, "gauge", labels), fn: fn}
+	DefaultRegistry.MustRegister(g)
+	return g
+}
+
+func (g *GaugeFunc) Write(w io.Writer) {
+	g.writeHeader(w)
+	g.fn(func(f float64, values ...string) {
+		if len(values) == len(g.labels) {
+			writeSample(w, g.name, g.labels, values, "", "", f)
+		}
+	})
+}
+
+func writeSample(w io.Writer, name string, labels, values []string, extraLabel, extraValue string, f float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(values[i]))
+			b.WriteByte('"')
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extraLabel)
+			b.WriteString(`="`)
+			b.WriteString(extraValue)
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(f))
+	b.WriteByte('\n')
+	_, _ = io.WriteString(w, b.String())
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := &CounterVec{newVec("test_total", "Test counter.", "counter", []string{"code"})}
+	h := &HistogramVec{vec: newVec("test_seconds", "Test histogram.", "histogram", []string{"path"}), buckets: []float64{0.1, 1}}
+	assert.NoError(t, r.Register(c))
+	assert.NoError(t, r.Register(h))
+	assert.Error(t, r.Register(c))
+
+	c.Inc("ok")
+	c.Add(2, `a"b`)
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(5, "/")
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	assert.Equal(t, strings.Join([]string{
+		"# HELP test_seconds Test histogram.",
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{path="/",le="0.1"} 1`,
+		`test_seconds_bucket{path="/",le="1"} 2`,
+		`test_seconds_bucket{path="/",le="+Inf"} 3`,
+		`test_seconds_sum{path="/"} 5.55`,
+		`test_seconds_count{path="/"} 3`,
+		"# HELP test_total Test counter.",
+		"# TYPE test_total counter",
+		`test_total{code="a\"b"} 2`,
+		`test_total{code="ok"} 1`,
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestClient(t *testing.T) {
+	done := Client("http", "test", "POST")
+	assert.Equal(t, float64(1), ClientInflight.Value("http", "test"))
+	done(errors.New("failed"))
+
+	assert.Equal(t, float64(0), ClientInflight.Value("http", "test"))
+	assert.Equal(t, float64(1), ClientErrors.Value("http", "test", "POST"))
+	assert.Equal(t, uint64(1), ClientDuration.Count("http", "test", "POST", CodeError))
+
+	RegisterPool("redis", "test", "127.0.0.1:6379", func() PoolStats {
+		return PoolStats{Active: 3, Idle: 1}
+	})
+	defer UnregisterPool("redis", "test", "127.0.0.1:6379")
+
+	var buf bytes.Buffer
+	DefaultRegistry.Write(&buf)
+	assert.Contains(t, buf.String(), `golib_pool_connections{prot="redis",service="test",addr="127.0.0.1:6379",state="active"} 3`)
+	assert.Equal(t, []Pool{{Prot: "redis", Service: "test", Addr: "127.0.0.1:6379", Active: 3, Idle: 1}}, Pools())
+
+	RegisterClient("kafka", "test", func() interface{} { return "running" })
+	assert.Equal(t, "running", Clients()["kafka"]["test"])
+	UnregisterClient("kafka", "test")
+	assert.Empty(t, Clients())
+}
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/GitHub121380/golib/base"
 	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/metrics"
 	"github.com/GitHub121380/golib/utils"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -89,6 +91,7 @@ func AccessLog() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 开始时间
 		start := time.Now()
+		done := metrics.Server("http")
 		// 请求url
 		path := c.Request.URL.Path
 		// 请求报文
@@ -116,6 +119,7 @@ func AccessLog() gin.HandlerFunc {
 
 		// 处理请求
 		c.Next()
+		done(c.Request.Method, c.FullPath(), strconv.Itoa(c.Writer.Status()))
 
 		response := ""
 		if blw.body != nil {
@@ -167,13 +171,18 @@ func AccessLog() gin.HandlerFunc {
 			customerFields = append(customerFields, zap.Reflect(k, v))
 		}
 
+		// 压测标记
+		_, pressMark := utils.GetPressureFlag(c)
+
 		// 固定notice
 		commonFields := []zap.Field{
 			zap.String("logId", logID),
 			zap.String("spanId", spanID),
+			zap.String("traceId", zlog.GetTraceID(c)),
 			zap.String("requestId", zlog.GetRequestID(c)),
 			zap.String("localIp", env.LocalIP),
 			zap.String("module", env.AppName),
+			zap.Int("press", pressMark),
 			zap.String("cuid", getReqValueByKey(c, "cuid")),
 			zap.String("device", getReqValueByKey(c, "device")),
 			zap.String("channel", getReqValueByKey(c, "channel")),
@@ -1,14 +1,34 @@
 package gin
 
 import (
+	"github.com/GitHub121380/golib/ral"
 	"github.com/GitHub121380/golib/utils/metadata"
+	"github.com/GitHub121380/golib/zlog"
 	"github.com/gin-gonic/gin"
 )
 
 func Metadata() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		UseMetadata(ctx)
+
+		// 上游透传的染色标记
+		if color := ctx.GetHeader(ral.HEAD_COLOR); color != "" {
+			ral.SetColor(ctx, color)
+		}
+
+		// 根据上游透传的剩余时间设置当前请求的截止时间
+		cancel := ral.WithBudget(ctx, ctx.GetHeader)
+		defer cancel()
+
+		// 开启当前请求的入口span
+		span := zlog.StartServerSpan(ctx, ctx.Request.URL.Path, ctx.GetHeader)
+		span.SetTag("http.method", ctx.Request.Method)
+		span.SetTag("http.url", ctx.Request.URL.Path)
+
 		ctx.Next()
+
+		span.SetTag("http.status_code", ctx.Writer.Status())
+		span.Finish()
 	}
 }
 
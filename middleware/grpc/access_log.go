@@ -5,8 +5,10 @@ import (
 	"go.uber.org/zap"
 	"time"
 
+	"github.com/GitHub121380/golib/metrics"
 	"github.com/GitHub121380/golib/zlog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 )
 
 // access日志打印
@@ -14,8 +16,10 @@ func AccessLog() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		// 开始时间
 		start := time.Now()
+		done := metrics.Server("grpc")
 
 		resp, err := handler(ctx, req)
+		done("unary", info.FullMethod, status.Code(err).String())
 		// 结束时间
 		end := time.Now()
 		// 执行时间 单位:微秒
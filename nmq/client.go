@@ -4,6 +4,7 @@ import (
 	"github.com/GitHub121380/golib/gomcpack/mcpacknpc"
 	"github.com/GitHub121380/golib/ral"
 	"github.com/GitHub121380/golib/utils"
+	"github.com/GitHub121380/golib/zlog"
 	"github.com/gin-gonic/gin"
 
 	"time"
@@ -14,6 +15,12 @@ const (
 	HEAD_TOPIC   = "_topic"
 	HEAD_CMD     = "_cmd"
 
+	// 链路追踪信息透传
+	HEAD_TRACEPARENT = "_traceparent"
+	HEAD_SPANID      = "_spanid"
+	// 染色标记透传
+	HEAD_COLOR = "_color"
+
 	// 编码方式使用的配置文件中的 Encode 字段，为防止歧义，不要使用该字段指定了
 	HEAD_ENCODE = "_encode"
 	HEAD_UAGENT = "User-Agent"
@@ -85,6 +92,17 @@ func (client *Client) Check(ctx *gin.Context, req *Request) error {
 	// 全链路压测标记透传
 	data[utils.HttpUrlPressureCallerKey], data[utils.HttpUrlPressureMarkKey] = utils.GetPressureFlag(ctx)
 
+	// 链路追踪信息透传，logid 通过 npc header 透传
+	if tp, ok := req.Header[zlog.HeaderTraceparent]; ok {
+		data[HEAD_TRACEPARENT] = tp
+	}
+	if span, ok := req.Header[zlog.HeaderXBDSpanID]; ok {
+		data[HEAD_SPANID] = span
+	}
+	if color, ok := req.Header[ral.HEAD_COLOR]; ok {
+		data[HEAD_COLOR] = color
+	}
+
 	if res, err := Encode(req.EncodeType, data); err != nil {
 		return err
 	} else {
@@ -64,11 +64,18 @@ func init() {
 			return nil, ral.ERR_NOT_FOUND_CLIENT
 		}
 
+		// 根据调用方剩余时间计算超时
+		timeout, err := ral.Budget(ctx, client.ReadTimeOut)
+		if err != nil {
+			zlog.WarnLogger(ctx, "budget error: "+err.Error(), fields...)
+			return nil, err
+		}
+
 		// 创建连接
 		agent := client.agent
 		if agent == nil || !res.LongConnect {
 			agent = mcpacknpc.NewClient([]string{client.Host})
-			agent.Timeout = client.ReadTimeOut
+			agent.Timeout = timeout
 		}
 
 		// 保存连接
@@ -93,7 +100,7 @@ func init() {
 
 		// 发送请求
 		enData, _ := req.EncodeData.([]byte)
-		result, err := agent.Send(ctx, enData)
+		result, err := agent.Send(ral.Context(ctx), enData)
 
 		// 处理响应
 		var resp NmqResponse
@@ -110,7 +117,7 @@ func init() {
 			zlog.WarnLogger(ctx, "call failed"+err.Error(), fields...)
 		}
 		return &resp, err
-	}})
+	}, Reply: (*NmqResponse)(nil)})
 }
 
 func Call(ctx *gin.Context, method string, service string, data map[string]interface{}, head map[string]string) (resp *NmqResponse, err error) {
@@ -121,7 +128,7 @@ func Call(ctx *gin.Context, method string, service string, data map[string]inter
 	}
 	defer ins.Release()
 
-	ins.Retry(func(res *ral.Resource, ins *ral.Instance) bool {
+	ins.RetryContext(ctx, func(res *ral.Resource, ins *ral.Instance) bool {
 		if r, e := ins.Request(ctx, method, data, head); e == nil {
 			resp, _ = r.(*NmqResponse)
 			return false
@@ -293,3 +293,11 @@ func (c *channelPool) Release() {
 func (c *channelPool) Len() int {
 	return len(c.getConns())
 }
+
+// Stats 返回当前连接数统计
+func (c *channelPool) Stats() Stats {
+	c.countMu.Lock()
+	active := c.openingConns
+	c.countMu.Unlock()
+	return Stats{Active: active, Idle: c.Len()}
+}
@@ -13,3 +13,16 @@ type Pool interface {
 
 	Len() int
 }
+
+// 连接数统计
+type Stats struct {
+	// 当前打开的连接数，包括空闲连接
+	Active int
+	// 空闲连接数
+	Idle int
+}
+
+// 支持连接数统计的连接池
+type StatsPool interface {
+	Stats() Stats
+}
@@ -0,0 +1,91 @@
+package ral
+
+import (
+	"sync/atomic"
+)
+
+// 未配置权重时的默认权重
+const defaultWeight = 1
+
+func (ins *Instance) weight() int {
+	if ins.Weight > 0 {
+		return ins.Weight
+	}
+	return defaultWeight
+}
+
+// 修改实例权重，同步修改依赖该实例的下游实例
+func (ins *Instance) SetWeight(weight int) {
+	if ins == nil {
+		return
+	}
+
+	if res := ins.res; res != nil {
+		res.pick.Lock()
+		ins.Weight = weight
+		res.pick.Unlock()
+	} else {
+		ins.Weight = weight
+	}
+
+	for _, sub := range ins.subs {
+		sub.SetWeight(weight)
+	}
+}
+
+// 返回实例当前未释放的调用数
+func (ins *Instance) Inflight() int64 {
+	if ins == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&ins.inflight)
+}
+
+func (ins *Instance) acquire() {
+	atomic.AddInt64(&ins.inflight, 1)
+}
+
+func (ins *Instance) release() {
+	for {
+		n := atomic.LoadInt64(&ins.inflight)
+		if n <= 0 || atomic.CompareAndSwapInt64(&ins.inflight, n, n-1) {
+			return
+		}
+	}
+}
+
+// 平滑加权轮询
+func (res *Resource) weighted(list []*Instance) int {
+	res.pick.Lock()
+	defer res.pick.Unlock()
+
+	which, total := 0, 0
+	for i, ins := range list {
+		w := ins.weight()
+		ins.current += w
+		total += w
+		if ins.current > list[which].current {
+			which = i
+		}
+	}
+	list[which].current -= total
+	return which
+}
+
+// 选取 未释放调用数/权重 最小的实例，起点轮转避免总是选中第一个
+func (res *Resource) least(list []*Instance) int {
+	res.pick.Lock()
+	defer res.pick.Unlock()
+
+	start := res.cursor
+	res.cursor++
+
+	which := start % len(list)
+	for i := 1; i < len(list); i++ {
+		j := (start + i) % len(list)
+		if list[j].Inflight()*int64(list[which].weight()) < list[which].Inflight()*int64(list[j].weight()) {
+			which = j
+		}
+	}
+	return which
+}
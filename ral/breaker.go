@@ -0,0 +1,245 @@
+package ral
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GitHub121380/golib/zlog"
+	"go.uber.org/zap"
+)
+
+const ( // 熔断状态
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half-open"
+)
+
+const ( // 熔断默认配置
+	defaultBreakerFailures    = 5
+	defaultBreakerMinRequests = 20
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerOpenTimeout = 10 * time.Second
+	defaultBreakerProbes      = 1
+)
+
+// 实例熔断器，连续失败或窗口内错误率过高时熔断，熔断一段时间后放行少量探测请求
+type breaker struct {
+	ins *Instance
+
+	mu    sync.Mutex
+	state string
+
+	// 连续失败次数
+	failures int
+
+	// 统计窗口
+	windowStart time.Time
+	total       int
+	errors      int
+
+	// 熔断及探测
+	openedAt  time.Time
+	probes    int
+	probedAt  time.Time
+	successes int
+}
+
+func newBreaker(ins *Instance) *breaker {
+	return &breaker{ins: ins, state: BREAKER_CLOSED, windowStart: time.Now()}
+}
+
+func (b *breaker) enabled() bool {
+	return b != nil && b.ins.res != nil && b.ins.res.Snapshot().Breaker.Enable
+}
+
+func (b *breaker) failureThreshold() int {
+	if n := b.ins.res.Snapshot().Breaker.Failures; n > 0 {
+		return n
+	}
+	return defaultBreakerFailures
+}
+
+func (b *breaker) minRequests() int {
+	if n := b.ins.res.Snapshot().Breaker.MinRequests; n > 0 {
+		return n
+	}
+	return defaultBreakerMinRequests
+}
+
+func (b *breaker) window() time.Duration {
+	if d := b.ins.res.Snapshot().Breaker.Window; d > 0 {
+		return d
+	}
+	return defaultBreakerWindow
+}
+
+func (b *breaker) openTimeout() time.Duration {
+	if d := b.ins.res.Snapshot().Breaker.OpenTimeout; d > 0 {
+		return d
+	}
+	return defaultBreakerOpenTimeout
+}
+
+func (b *breaker) maxProbes() int {
+	if n := b.ins.res.Snapshot().Breaker.HalfOpenProbes; n > 0 {
+		return n
+	}
+	return defaultBreakerProbes
+}
+
+// 判断实例当前是否可以被选取，不占用探测名额
+func (b *breaker) ready() bool {
+	if !b.enabled() {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case BREAKER_OPEN:
+		return now.Sub(b.openedAt) >= b.openTimeout()
+	case BREAKER_HALF_OPEN:
+		return b.probes < b.maxProbes() || now.Sub(b.probedAt) >= b.openTimeout()
+	}
+	return true
+}
+
+// 实例被选中时调用，半开状态下会占用一个探测名额
+func (b *breaker) allow() bool {
+	if !b.enabled() {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case BREAKER_OPEN:
+		if now.Sub(b.openedAt) < b.openTimeout() {
+			return false
+		}
+		b.setState(BREAKER_HALF_OPEN)
+		b.probes, b.successes = 0, 0
+	case BREAKER_HALF_OPEN:
+		// 探测请求长时间没有结果时，重新放行
+		if b.probes >= b.maxProbes() && now.Sub(b.probedAt) >= b.openTimeout() {
+			b.probes = 0
+		}
+	default:
+		return true
+	}
+
+	if b.probes >= b.maxProbes() {
+		return false
+	}
+	b.probes++
+	b.probedAt = now
+	return true
+}
+
+// 记录一次调用结果，failed 为调用失败，cost 为调用耗时
+func (b *breaker) report(failed bool, cost time.Duration) {
+	if !b.enabled() {
+		return
+	}
+
+	if slow := b.ins.res.Snapshot().Breaker.SlowThreshold; slow > 0 && cost >= slow {
+		failed = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BREAKER_HALF_OPEN:
+		if failed {
+			b.trip()
+			return
+		}
+		if b.successes++; b.successes >= b.maxProbes() {
+			b.reset()
+		}
+		return
+	case BREAKER_OPEN:
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(b.windowStart) >= b.window() {
+		b.windowStart, b.total, b.errors = now, 0, 0
+	}
+	b.total++
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.errors++
+	b.failures++
+
+	if b.failures >= b.failureThreshold() {
+		b.trip()
+		return
+	}
+	if rate := b.ins.res.Snapshot().Breaker.ErrorPercent; rate > 0 && b.total >= b.minRequests() && b.errors*100 >= rate*b.total {
+		b.trip()
+	}
+}
+
+func (b *breaker) trip() {
+	b.setState(BREAKER_OPEN)
+	b.openedAt = time.Now()
+	b.failures, b.total, b.errors = 0, 0, 0
+	b.probes, b.successes = 0, 0
+}
+
+func (b *breaker) reset() {
+	b.setState(BREAKER_CLOSED)
+	b.windowStart = time.Now()
+	b.failures, b.total, b.errors = 0, 0, 0
+	b.probes, b.successes = 0, 0
+}
+
+func (b *breaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	res := b.ins.res
+	zlog.WarnLogger(nil, fmt.Sprintf("ral breaker %s:%s %s:%d %s->%s", res.Type, res.Name, b.ins.IP, b.ins.Port, b.state, state), zap.String("prot", "ral"))
+	b.state = state
+}
+
+func (b *breaker) getState() string {
+	if !b.enabled() {
+		return BREAKER_CLOSED
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// 返回实例的熔断状态
+func (ins *Instance) BreakerState() string {
+	if ins == nil || ins.breaker == nil {
+		return BREAKER_CLOSED
+	}
+	return ins.breaker.getState()
+}
+
+func (ins *Instance) ready() bool {
+	return ins.breaker.ready()
+}
+
+func (ins *Instance) allow() bool {
+	return ins.breaker.allow()
+}
+
+func (ins *Instance) report(failed bool, cost time.Duration) {
+	if ins != nil {
+		ins.breaker.report(failed, cost)
+	}
+}
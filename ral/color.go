@@ -0,0 +1,103 @@
+package ral
+
+import (
+	"github.com/GitHub121380/golib/utils/metadata"
+	"github.com/gin-gonic/gin"
+)
+
+// 透传染色标记的header
+const HEAD_COLOR = "x_bd_color"
+
+// 返回当前请求的染色标记
+func Color(ctx *gin.Context) string {
+	if c, ok := metadata.CtxFromGinContext(ctx); ok {
+		return metadata.String(c, metadata.Color)
+	}
+	return ""
+}
+
+// 设置当前请求的染色标记，调用下游时会透传该标记
+func SetColor(ctx *gin.Context, color string) {
+	if ctx == nil {
+		return
+	}
+
+	c, ok := metadata.CtxFromGinContext(ctx)
+	if !ok {
+		c = metadata.NewContext4Gin()
+		metadata.GinCtxWithCtx(ctx, c)
+	}
+	if md, ok := metadata.FromContext(c); ok {
+		md[metadata.Color] = color
+	}
+}
+
+// 将染色标记写入下游请求的header
+func injectColor(ctx *gin.Context, head map[string]string) {
+	if color := Color(ctx); color != "" && head != nil {
+		head[HEAD_COLOR] = color
+	}
+}
+
+// 修改实例颜色，同步修改依赖该实例的下游实例
+func (ins *Instance) SetColor(color string) {
+	if ins == nil {
+		return
+	}
+
+	if res := ins.res; res != nil {
+		res.lock.Lock()
+		ins.Color = color
+		res.lock.Unlock()
+	} else {
+		ins.Color = color
+	}
+
+	for _, sub := range ins.subs {
+		sub.SetColor(color)
+	}
+}
+
+// 返回请求应选取的实例颜色：染色请求优先选取同色实例，没有时选取未染色实例，
+// 未染色的请求只选取未染色实例。ok 为 false 时不做筛选
+func laneColor(ctx *gin.Context, list []*Instance) (color string, ok bool) {
+	color = Color(ctx)
+
+	colored, same, plain := false, false, false
+	for _, ins := range list {
+		switch ins.Color {
+		case "":
+			plain = true
+		case color:
+			colored, same = true, true
+		default:
+			colored = true
+		}
+	}
+
+	switch {
+	case !colored:
+		return "", false
+	case color != "" && same:
+		return color, true
+	case plain:
+		return "", true
+	}
+	return "", false
+}
+
+// 按染色标记筛选实例
+func lane(ctx *gin.Context, list []*Instance) []*Instance {
+	color, ok := laneColor(ctx, list)
+	if !ok {
+		return list
+	}
+
+	l := make([]*Instance, 0, len(list))
+	for _, ins := range list {
+		if ins.Color == color {
+			l = append(l, ins)
+		}
+	}
+	return l
+}
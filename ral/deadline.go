@@ -0,0 +1,113 @@
+package ral
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 透传剩余超时时间的header，单位毫秒
+const HEAD_TIMEOUT = "x_bd_timeout"
+
+// 返回调用方请求的 context，用于感知超时和取消
+func Context(ctx *gin.Context) context.Context {
+	if ctx != nil && ctx.Request != nil {
+		return ctx.Request.Context()
+	}
+	return context.Background()
+}
+
+// 返回调用方请求的截止时间
+func Deadline(ctx *gin.Context) (time.Time, bool) {
+	return Context(ctx).Deadline()
+}
+
+// 调用方已超时或已取消时返回错误
+func Err(ctx *gin.Context) error {
+	switch Context(ctx).Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return ERR_BUDGET_EXHAUSTED
+	default:
+		return ERR_CONTEXT_CANCELED
+	}
+}
+
+// 根据调用方剩余时间计算本次调用的超时，剩余时间小于 timeout 时以剩余时间为准
+func Budget(ctx *gin.Context, timeout time.Duration) (time.Duration, error) {
+	if err := Err(ctx); err != nil {
+		return 0, err
+	}
+
+	deadline, ok := Deadline(ctx)
+	if !ok {
+		return timeout, nil
+	}
+
+	remain := time.Until(deadline)
+	if remain <= 0 {
+		return 0, ERR_BUDGET_EXHAUSTED
+	}
+	if timeout <= 0 || remain < timeout {
+		return remain, nil
+	}
+	return timeout, nil
+}
+
+// 根据上游透传的剩余时间为当前请求设置截止时间，请求结束时需调用返回的 cancel
+func WithBudget(ctx *gin.Context, get func(key string) string) context.CancelFunc {
+	if ctx == nil || ctx.Request == nil || get == nil {
+		return func() {}
+	}
+
+	ms, err := strconv.ParseInt(strings.TrimSpace(get(HEAD_TIMEOUT)), 10, 64)
+	if err != nil || ms <= 0 {
+		return func() {}
+	}
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), time.Duration(ms)*time.Millisecond)
+	ctx.Request = ctx.Request.WithContext(c)
+	return cancel
+}
+
+// 将剩余时间写入下游请求的header
+func injectBudget(ctx *gin.Context, head map[string]string) {
+	if deadline, ok := Deadline(ctx); ok && head != nil {
+		if remain := time.Until(deadline) / time.Millisecond; remain > 0 {
+			head[HEAD_TIMEOUT] = strconv.FormatInt(int64(remain), 10)
+		}
+	}
+}
+
+// 执行 fn，调用方超时或取消后立即返回，fn 在后台使用 ctx 的副本执行完毕后丢弃结果
+func Wait(ctx *gin.Context, fn func(ctx *gin.Context) (interface{}, error)) (interface{}, error) {
+	done := Context(ctx).Done()
+	if done == nil {
+		return fn(ctx)
+	}
+	if err := Err(ctx); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	ch := make(chan result, 1)
+	cp := ctx.Copy()
+	go func() {
+		reply, err := fn(cp)
+		ch <- result{reply, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.reply, r.err
+	case <-done:
+		return nil, Err(ctx)
+	}
+}
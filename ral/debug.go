@@ -0,0 +1,106 @@
+package ral
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// 资源状态，用于调试接口输出
+type ResourceStatus struct {
+	Type       string           `json:"type"`
+	Name       string           `json:"name"`
+	Strategy   string           `json:"strategy"`
+	Encode     string           `json:"encode,omitempty"`
+	Retry      int              `json:"retry"`
+	ZNS        string           `json:"zns,omitempty"`
+	IDC        string           `json:"idc,omitempty"`
+	ServingIDC string           `json:"servingIdc"`
+	Count      int              `json:"count"`
+	Total      int              `json:"total"`
+	Rejected   int64            `json:"rejected,omitempty"`
+	Depend     []string         `json:"depend,omitempty"`
+	Instances  []InstanceStatus `json:"instances"`
+}
+
+// 实例状态
+type InstanceStatus struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Weight   int    `json:"weight"`
+	Color    string `json:"color,omitempty"`
+	Healthy  bool   `json:"healthy"`
+	Breaker  string `json:"breaker"`
+	Inflight int64  `json:"inflight"`
+	Subs     int    `json:"subs,omitempty"`
+}
+
+// 返回所有资源的状态，按类型、名称排序
+func Resources() []ResourceStatus {
+	lock.RLock()
+	list := make([]*Resource, 0, len(resources))
+	for _, res := range resources {
+		list = append(list, res)
+	}
+	lock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Type != list[j].Type {
+			return list[i].Type < list[j].Type
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	status := make([]ResourceStatus, 0, len(list))
+	for _, res := range list {
+		status = append(status, res.status())
+	}
+	return status
+}
+
+func (res *Resource) status() ResourceStatus {
+	res.lock.RLock()
+	defer res.lock.RUnlock()
+
+	s := ResourceStatus{
+		Type:       res.Type,
+		Name:       res.Name,
+		Strategy:   res.Strategy,
+		Encode:     res.Encode,
+		Retry:      res.Snapshot().Retry,
+		ZNS:        res.ZNS.Name,
+		IDC:        res.idc,
+		ServingIDC: res.ServingIDC(),
+		Count:      res.count,
+		Total:      res.total,
+		Rejected:   res.Rejected(),
+		Depend:     res.Depend,
+		Instances:  make([]InstanceStatus, 0, len(res.list)),
+	}
+	for _, ins := range res.list {
+		s.Instances = append(s.Instances, InstanceStatus{
+			IP:       ins.IP,
+			Port:     ins.Port,
+			Weight:   ins.Weight,
+			Color:    ins.Color,
+			Healthy:  ins.healthy(),
+			Breaker:  ins.BreakerState(),
+			Inflight: atomic.LoadInt64(&ins.inflight),
+			Subs:     len(ins.subs),
+		})
+	}
+	return s
+}
+
+// 返回资源依赖关系，key 为被依赖的资源，value 为依赖它的资源
+func Dependons() map[string][]string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	deps := make(map[string][]string, len(dependons))
+	for dep, list := range dependons {
+		for _, res := range list {
+			deps[dep] = append(deps[dep], res.Type+":"+res.Name)
+		}
+	}
+	return deps
+}
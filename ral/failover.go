@@ -0,0 +1,108 @@
+package ral
+
+import (
+	"fmt"
+
+	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 默认本机房可用实例数低于1时切换
+const defaultFailoverMinHealthy = 1
+
+// 为其他机房创建备用资源，备用资源名为 name@idc
+func initFailover(res *Resource) error {
+	for _, idc := range res.Failover.IDC {
+		if idc == res.idc {
+			continue
+		}
+
+		standby := res.clone(res.Type, res.Name+"@"+idc)
+		if len(res.Manual[idc]) > 0 {
+			standby.Manual = res.Manual
+		} else if z := res.ZNS.IDC[idc]; z != "" {
+			standby.ZNS.Name = z
+		} else {
+			zlog.WarnLogger(nil, fmt.Sprintf("ral failover %s:%s not found instances of idc %s", res.Type, res.Name, idc), zap.String("prot", "ral"))
+			continue
+		}
+		standby.idc, standby.primary = idc, res
+
+		AddResource(standby)
+		if err := initIDC(standby, idc); err != nil {
+			return err
+		}
+		res.standby = append(res.standby, standby)
+	}
+	return nil
+}
+
+func (res *Resource) minHealthy() int {
+	if n := res.Failover.MinHealthy; n > 0 {
+		return n
+	}
+	return defaultFailoverMinHealthy
+}
+
+// 可用实例数
+func (res *Resource) usableCount() int {
+	res.lock.RLock()
+	defer res.lock.RUnlock()
+
+	n := 0
+	for _, ins := range res.list {
+		if ins.usable() {
+			n++
+		}
+	}
+	return n
+}
+
+// 返回当前提供服务的资源，本机房可用实例不足时按顺序选取备用机房，本机房恢复后切回
+func (res *Resource) failover(ctx *gin.Context) *Resource {
+	if len(res.standby) == 0 {
+		return res
+	}
+
+	target := res
+	if res.usableCount() < res.minHealthy() {
+		for _, s := range res.standby {
+			if s.usableCount() > 0 {
+				target = s
+				break
+			}
+		}
+	}
+
+	if prev, _ := res.serving.Load().(string); prev != target.idc {
+		res.serving.Store(target.idc)
+		if prev == "" {
+			prev = res.idc
+		}
+		if prev != target.idc {
+			zlog.WarnLogger(ctx, fmt.Sprintf("ral failover %s:%s idc %s->%s", res.Type, res.Name, prev, target.idc), zap.String("prot", "ral"))
+		}
+	}
+	return target
+}
+
+// 返回资源当前提供服务的机房
+func (res *Resource) ServingIDC() string {
+	if idc, _ := res.serving.Load().(string); idc != "" {
+		return idc
+	}
+	if res.idc != "" {
+		return res.idc
+	}
+	return env.IDC
+}
+
+// 返回资源当前提供服务的机房，资源不存在时返回空
+func ServingIDC(modType string, name string) string {
+	if res, ok := GetResource(modType, name); ok {
+		return res.ServingIDC()
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package ral
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// 默认每个实例的虚拟节点数
+const defaultHashReplicas = 160
+
+// 一致性hash环，实例变更时只影响相邻区间的key
+type hashRing struct {
+	nodes  []uint32
+	owners map[uint32]*Instance
+}
+
+func newHashRing(list []*Instance, replicas int) *hashRing {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+
+	ring := &hashRing{
+		nodes:  make([]uint32, 0, len(list)*replicas),
+		owners: make(map[uint32]*Instance, len(list)*replicas),
+	}
+	for _, ins := range list {
+		for i := 0; i < replicas; i++ {
+			h := hashKey(fmt.Sprintf("%s:%d#%d", ins.IP, ins.Port, i))
+			if _, ok := ring.owners[h]; ok {
+				continue
+			}
+			ring.owners[h] = ins
+			ring.nodes = append(ring.nodes, h)
+		}
+	}
+	sort.Slice(ring.nodes, func(i, j int) bool { return ring.nodes[i] < ring.nodes[j] })
+	return ring
+}
+
+// 顺时针查找第一个满足 ok 的实例，ok 为nil时不做过滤
+func (ring *hashRing) get(key string, ok func(*Instance) bool) *Instance {
+	if ring == nil || len(ring.nodes) == 0 {
+		return nil
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(ring.nodes), func(i int) bool { return ring.nodes[i] >= h })
+	for i := 0; i < len(ring.nodes); i++ {
+		ins := ring.owners[ring.nodes[(start+i)%len(ring.nodes)]]
+		if ok == nil || ok(ins) {
+			return ins
+		}
+	}
+	return nil
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
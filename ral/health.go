@@ -0,0 +1,169 @@
+package ral
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GitHub121380/golib/zlog"
+	"go.uber.org/zap"
+)
+
+const ( // 健康检查默认配置
+	defaultHealthInterval  = 5 * time.Second
+	defaultHealthTimeout   = time.Second
+	defaultHealthFailures  = 3
+	defaultHealthSuccesses = 1
+)
+
+// 实例健康状态
+type health struct {
+	unhealthy int32
+
+	// 连续失败、成功次数，只在检查协程中修改
+	failures  int
+	successes int
+}
+
+func (ins *Instance) healthy() bool {
+	return atomic.LoadInt32(&ins.health.unhealthy) == 0
+}
+
+// 返回实例是否通过健康检查
+func (ins *Instance) Healthy() bool {
+	return ins == nil || ins.healthy()
+}
+
+// 实例可以被选取：健康检查通过且未被熔断
+func (ins *Instance) usable() bool {
+	return ins.healthy() && ins.ready()
+}
+
+func (res *Resource) healthInterval() time.Duration {
+	if d := res.Snapshot().HealthCheck.Interval; d > 0 {
+		return d
+	}
+	return defaultHealthInterval
+}
+
+func (res *Resource) healthTimeout() time.Duration {
+	if d := res.Snapshot().HealthCheck.Timeout; d > 0 {
+		return d
+	}
+	if res.ConnTimeOut > 0 {
+		return res.ConnTimeOut
+	}
+	return defaultHealthTimeout
+}
+
+// 检查单个实例，模块未提供检查方法时使用TCP连接检查
+func (res *Resource) checkInstance(ins *Instance) error {
+	timeout := res.healthTimeout()
+	if mod := res.mod; mod != nil && mod.Check != nil {
+		return mod.Check(res, ins, timeout)
+	}
+	return CheckTCP(ins, timeout)
+}
+
+// TCP连接检查
+func CheckTCP(ins *Instance, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ins.IP, strconv.Itoa(ins.Port)), timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// 启动健康检查，已启动或未开启时不做处理
+func (res *Resource) startHealthCheck() {
+	if !res.Snapshot().HealthCheck.Enable || res.Type == TYPE_ZNS {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&res.checking, 0, 1) {
+		return
+	}
+
+	zlog.InfoLogger(nil, fmt.Sprintf("ral health check start %s:%s", res.Type, res.Name), zap.String("prot", "ral"))
+	go func() {
+		for {
+			time.Sleep(res.healthInterval())
+			// 配置热加载关闭检查时，恢复所有实例
+			if !res.Snapshot().HealthCheck.Enable {
+				res.lock.RLock()
+				for _, ins := range res.list {
+					res.setHealthy(ins, true, nil)
+				}
+				res.lock.RUnlock()
+				atomic.StoreInt32(&res.checking, 0)
+				zlog.InfoLogger(nil, fmt.Sprintf("ral health check stop %s:%s", res.Type, res.Name), zap.String("prot", "ral"))
+				return
+			}
+			res.checkHealth()
+		}
+	}()
+}
+
+// 并发检查所有实例
+func (res *Resource) checkHealth() {
+	res.lock.RLock()
+	list := append([]*Instance(nil), res.list...)
+	res.lock.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, ins := range list {
+		wg.Add(1)
+		go func(ins *Instance) {
+			defer wg.Done()
+			err := res.checkInstance(ins)
+			res.record(ins, err)
+		}(ins)
+	}
+	wg.Wait()
+}
+
+// 记录检查结果，连续失败或成功达到阈值时切换状态
+func (res *Resource) record(ins *Instance, err error) {
+	conf := res.Snapshot().HealthCheck
+	failures, successes := conf.Failures, conf.Successes
+	if failures <= 0 {
+		failures = defaultHealthFailures
+	}
+	if successes <= 0 {
+		successes = defaultHealthSuccesses
+	}
+
+	h := &ins.health
+	if err != nil {
+		h.failures++
+		h.successes = 0
+		if h.failures >= failures && ins.healthy() {
+			res.setHealthy(ins, false, err)
+		}
+		return
+	}
+
+	h.successes++
+	h.failures = 0
+	if h.successes >= successes && !ins.healthy() {
+		res.setHealthy(ins, true, nil)
+	}
+}
+
+func (res *Resource) setHealthy(ins *Instance, ok bool, err error) {
+	v := int32(1)
+	if ok {
+		v = 0
+	}
+	if atomic.SwapInt32(&ins.health.unhealthy, v) == v {
+		return
+	}
+
+	msg := fmt.Sprintf("ral health check %s:%s %s:%d recovered", res.Type, res.Name, ins.IP, ins.Port)
+	if !ok {
+		msg = fmt.Sprintf("ral health check %s:%s %s:%d unhealthy: %s", res.Type, res.Name, ins.IP, ins.Port, err.Error())
+	}
+	zlog.WarnLogger(nil, msg, zap.String("prot", "ral"))
+}
@@ -0,0 +1,232 @@
+package ral
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const ( // 对冲默认配置
+	defaultHedgeMax     = 1
+	defaultHedgeSamples = 20
+	hedgeWindowSize     = 128
+)
+
+// 对冲请求在 context 中的序号，0 为首个请求
+const ContextKeyHedge = "_ral_hedge"
+
+// 最近调用耗时，用于按分位数计算对冲延迟
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) observe(cost time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < hedgeWindowSize {
+		w.samples = append(w.samples, cost)
+		return
+	}
+	w.samples[w.next] = cost
+	w.next = (w.next + 1) % hedgeWindowSize
+}
+
+func (w *latencyWindow) percentile(p int) (time.Duration, bool) {
+	w.mu.Lock()
+	list := append([]time.Duration(nil), w.samples...)
+	w.mu.Unlock()
+
+	if len(list) < defaultHedgeSamples {
+		return 0, false
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	i := len(list) * p / 100
+	if i >= len(list) {
+		i = len(list) - 1
+	}
+	return list[i], true
+}
+
+// 返回资源配置的对冲延迟，未开启或样本不足时返回0
+func (res *Resource) HedgeDelay() time.Duration {
+	if res == nil || !res.Snapshot().Hedge.Enable {
+		return 0
+	}
+	if p := res.Snapshot().Hedge.Percentile; p > 0 && p < 100 {
+		if d, ok := res.latency.percentile(p); ok {
+			return d
+		}
+	}
+	return res.Snapshot().Hedge.Delay
+}
+
+func (res *Resource) hedgeMax() int {
+	if n := res.Snapshot().Hedge.Max; n > 0 {
+		return n
+	}
+	return defaultHedgeMax
+}
+
+// 返回当前调用的对冲序号
+func HedgeIndex(ctx *gin.Context) int {
+	if ctx == nil {
+		return 0
+	}
+	return ctx.GetInt(ContextKeyHedge)
+}
+
+// 对冲调用，仅用于幂等请求。fn 在 delay 内未返回时选取另一个实例再次发起，
+// 取最先成功的结果并取消其余调用，返回额外发起的请求数。
+// delay 小于等于0时使用资源配置，资源未开启对冲时直接调用 fn
+func Hedge(ctx *gin.Context, ins *Instance, delay time.Duration, fn func(ctx *gin.Context, ins *Instance) (interface{}, error)) (interface{}, int, error) {
+	if ins == nil || ins.res == nil {
+		reply, err := fn(ctx, ins)
+		return reply, 0, err
+	}
+
+	res := ins.res
+	if delay <= 0 {
+		delay = res.HedgeDelay()
+	}
+	if delay <= 0 {
+		start := time.Now()
+		reply, err := fn(ctx, ins)
+		if err == nil {
+			res.latency.observe(time.Since(start))
+		}
+		return reply, 0, err
+	}
+
+	parent := Context(ctx)
+	c, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	type result struct {
+		reply interface{}
+		err   error
+		ins   *Instance
+		cost  time.Duration
+	}
+	ch := make(chan result, res.hedgeMax()+1)
+	launch := func(index int, ins *Instance) {
+		cp := hedgeContext(ctx, c, index)
+		go func() {
+			start := time.Now()
+			reply, err := fn(cp, ins)
+			ch <- result{reply, err, ins, time.Since(start)}
+		}()
+	}
+
+	running := []*Instance{ins}
+	launch(0, ins)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	hedged, pending := 0, 1
+	// 返回后在后台等待其余请求结束并释放额外选取的实例
+	defer func() {
+		if pending == 0 {
+			return
+		}
+		go func(n int) {
+			for ; n > 0; n-- {
+				if r := <-ch; r.ins != ins {
+					r.ins.Release()
+				}
+			}
+		}(pending)
+	}()
+
+	var err error
+	for {
+		select {
+		case r := <-ch:
+			pending--
+			// 额外发起的请求在此统计熔断，首个请求由调用方统计
+			if r.ins != ins && Err(ctx) == nil {
+				r.ins.report(r.err != nil, r.cost)
+			}
+			if r.ins != ins {
+				r.ins.Release()
+			}
+			if r.err == nil {
+				res.latency.observe(r.cost)
+				return r.reply, hedged, nil
+			}
+			if err = r.err; pending == 0 {
+				return nil, hedged, err
+			}
+		case <-timer.C:
+			if hedged >= res.hedgeMax() {
+				continue
+			}
+			next := res.other(ctx, running)
+			if next == nil {
+				continue
+			}
+			hedged++
+			pending++
+			running = append(running, next)
+			zlog.DebugLogger(ctx, fmt.Sprintf("ral hedge %s:%s %s:%d after %s", res.Type, res.Name, next.IP, next.Port, delay), zap.String("prot", "ral"))
+			launch(hedged, next)
+			if hedged < res.hedgeMax() {
+				timer.Reset(delay)
+			}
+		case <-parent.Done():
+			return nil, hedged, Err(ctx)
+		}
+	}
+}
+
+// 从可用实例中选取一个与调用方泳道相同且不在 running 中的实例，优先选取熔断器
+// 放行的实例，没有可选实例时返回nil
+func (res *Resource) other(ctx *gin.Context, running []*Instance) *Instance {
+	res.lock.RLock()
+	defer res.lock.RUnlock()
+
+	list := make([]*Instance, 0, len(res.list))
+	for _, next := range lane(ctx, res.available()) {
+		found := false
+		for _, ins := range running {
+			found = found || ins == next
+		}
+		if !found {
+			list = append(list, next)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	next := allowed(list, rand.Intn(len(list)))
+	next.acquire()
+	return next
+}
+
+// 为对冲请求生成独立的 context，取消时不影响调用方
+func hedgeContext(ctx *gin.Context, c context.Context, index int) *gin.Context {
+	var cp *gin.Context
+	if ctx != nil {
+		cp = ctx.Copy()
+	} else {
+		cp = &gin.Context{}
+	}
+
+	if cp.Request != nil {
+		cp.Request = cp.Request.WithContext(c)
+	} else {
+		cp.Request = (&http.Request{Header: http.Header{}}).WithContext(c)
+	}
+	cp.Set(ContextKeyHedge, index)
+	return cp
+}
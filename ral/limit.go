@@ -0,0 +1,273 @@
+package ral
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const ( // 限流默认配置
+	defaultLimitMinConcurrency = 1
+	defaultLimitMaxConcurrency = 1000
+	defaultLimitInitial        = 20
+	// 自适应并发每次减小的比例
+	limitBackoff = 0.9
+	// 每隔多少个样本更新一次无负载耗时
+	limitRTTSamples = 100
+)
+
+// 资源限流器：令牌桶限制QPS，并发数限制支持按耗时自适应调整(AIMD)
+type limiter struct {
+	mu sync.Mutex
+
+	// 令牌桶
+	tokens float64
+	last   time.Time
+
+	// 并发限制
+	inflight int
+	limit    float64
+	wake     chan struct{}
+
+	// 无负载耗时，取最近一批样本的最小值
+	minRTT    time.Duration
+	nextRTT   time.Duration
+	samples   int
+	backoffAt time.Time
+
+	rejected int64
+}
+
+func (res *Resource) limited() bool {
+	conf := res.Snapshot().Limit
+	return conf.QPS > 0 || conf.Concurrency > 0 || conf.Adaptive
+}
+
+func (res *Resource) limitBurst() float64 {
+	if n := res.Snapshot().Limit.Burst; n > 0 {
+		return float64(n)
+	}
+	return math.Max(res.Snapshot().Limit.QPS, 1)
+}
+
+func (res *Resource) limitMin() float64 {
+	if n := res.Snapshot().Limit.MinConcurrency; n > 0 {
+		return float64(n)
+	}
+	return defaultLimitMinConcurrency
+}
+
+func (res *Resource) limitMax() float64 {
+	if n := res.Snapshot().Limit.Concurrency; n > 0 {
+		return float64(n)
+	}
+	return defaultLimitMaxConcurrency
+}
+
+// 返回当前并发上限，0 为不限制
+func (res *Resource) ConcurrencyLimit() int {
+	if conf := res.Snapshot().Limit; !conf.Adaptive {
+		return conf.Concurrency
+	}
+
+	l := &res.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(res.adaptiveLimit())
+}
+
+// 返回被限流的请求总数
+func (res *Resource) Rejected() int64 {
+	return atomic.LoadInt64(&res.limiter.rejected)
+}
+
+// 获取调用许可，返回的 release 需在调用结束后执行
+func (res *Resource) acquire(ctx *gin.Context) (release func(cost time.Duration, err error), err error) {
+	if !res.limited() {
+		return func(time.Duration, error) {}, nil
+	}
+
+	// 最长等待时间不超过调用方剩余时间
+	wait := time.Duration(0)
+	if w := res.Snapshot().Limit.Wait; w > 0 {
+		if wait, err = Budget(ctx, w); err != nil {
+			return nil, err
+		}
+	}
+	deadline := time.Now().Add(wait)
+
+	if res.Snapshot().Limit.QPS > 0 {
+		delay, ok := res.takeToken(wait)
+		if !ok {
+			return nil, res.reject(ctx, ERR_RATE_LIMITED)
+		}
+		if delay > 0 {
+			if err := sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if conf := res.Snapshot().Limit; conf.Concurrency <= 0 && !conf.Adaptive {
+		return func(time.Duration, error) {}, nil
+	}
+	if err := res.enter(ctx, deadline); err != nil {
+		return nil, err
+	}
+	return res.leave, nil
+}
+
+// 从令牌桶中取令牌，令牌不足时预支并返回需要等待的时间
+func (res *Resource) takeToken(wait time.Duration) (time.Duration, bool) {
+	l := &res.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now, qps, burst := time.Now(), res.Snapshot().Limit.QPS, res.limitBurst()
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*qps)
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	delay := time.Duration((1 - l.tokens) / qps * float64(time.Second))
+	if delay > wait {
+		return 0, false
+	}
+	l.tokens--
+	return delay, true
+}
+
+// 等待并发许可，超过截止时间时拒绝
+func (res *Resource) enter(ctx *gin.Context, deadline time.Time) error {
+	l := &res.limiter
+	for {
+		l.mu.Lock()
+		limit := res.limitMax()
+		if res.Snapshot().Limit.Adaptive {
+			limit = res.adaptiveLimit()
+		}
+		if float64(l.inflight) < limit {
+			l.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+		if l.wake == nil {
+			l.wake = make(chan struct{})
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return res.reject(ctx, ERR_CONCURRENCY_LIMITED)
+		}
+
+		timer := time.NewTimer(remain)
+		select {
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		case <-Context(ctx).Done():
+			timer.Stop()
+			return Err(ctx)
+		}
+	}
+}
+
+// 释放并发许可，开启自适应时根据耗时调整并发上限
+func (res *Resource) leave(cost time.Duration, err error) {
+	l := &res.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if res.Snapshot().Limit.Adaptive {
+		res.adapt(cost, err)
+	}
+	l.inflight--
+	if l.wake != nil {
+		close(l.wake)
+		l.wake = nil
+	}
+}
+
+// 自适应并发上限，需持有锁
+func (res *Resource) adaptiveLimit() float64 {
+	l := &res.limiter
+	min, max := res.limitMin(), res.limitMax()
+	if l.limit == 0 {
+		l.limit = math.Min(max, defaultLimitInitial)
+	}
+	return math.Max(min, math.Min(max, l.limit))
+}
+
+// 耗时超过阈值或超时时按比例减小上限，否则在并发较高时逐步增加上限，需持有锁
+func (res *Resource) adapt(cost time.Duration, err error) {
+	l := &res.limiter
+	limit := res.adaptiveLimit()
+
+	timeout := false
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		timeout = true
+	} else if err == nil {
+		if l.samples == 0 || cost < l.nextRTT {
+			l.nextRTT = cost
+		}
+		if l.samples++; l.samples >= limitRTTSamples {
+			l.minRTT, l.samples = l.nextRTT, 0
+		}
+		if l.minRTT == 0 || cost < l.minRTT {
+			l.minRTT = cost
+		}
+	}
+
+	threshold := res.Snapshot().Limit.Latency
+	if threshold <= 0 {
+		threshold = 2 * l.minRTT
+	}
+
+	now := time.Now()
+	switch {
+	case timeout || (threshold > 0 && cost > threshold):
+		// 同一批请求的慢响应只减小一次
+		if now.Sub(l.backoffAt) < threshold {
+			return
+		}
+		l.backoffAt = now
+		l.limit = math.Max(res.limitMin(), math.Floor(limit*limitBackoff))
+		zlog.DebugLogger(nil, fmt.Sprintf("ral limit %s:%s concurrency %d->%d", res.Type, res.Name, int(limit), int(l.limit)), zap.String("prot", "ral"))
+	case float64(l.inflight)*2 >= limit:
+		l.limit = math.Min(res.limitMax(), limit+1/limit)
+	}
+}
+
+func (res *Resource) reject(ctx *gin.Context, err error) error {
+	n := atomic.AddInt64(&res.limiter.rejected, 1)
+	zlog.WarnLogger(ctx, fmt.Sprintf("ral limit %s:%s rejected: %s", res.Type, res.Name, err.Error()),
+		zap.String("prot", "ral"), zap.Int64("rejected", n))
+	return err
+}
+
+// 等待一段时间，调用方超时或取消时提前返回
+func sleep(ctx *gin.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-Context(ctx).Done():
+		return Err(ctx)
+	}
+}
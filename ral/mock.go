@@ -0,0 +1,415 @@
+package ral
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 模拟调用未找到匹配规则
+var ERR_MOCK_NOT_FOUND = errors.New("mock not found")
+
+// http 请求路径所在的 header，与 http.HEAD_PATH 相同
+const mockPathHead = "_path"
+
+// 一次 ral 调用
+type Call struct {
+	Type    string
+	Service string
+	Method  string
+	// http 请求路径
+	Path string
+	Data map[string]interface{}
+	// redis 命令参数
+	Args []interface{}
+}
+
+// 请求参数的序列化结果，用于匹配录制文件
+func (c *Call) key() string {
+	buf, _ := json.Marshal(struct {
+		Data map[string]interface{} `json:"data,omitempty"`
+		Args []interface{}          `json:"args,omitempty"`
+	}{c.Data, c.Args})
+	return string(buf)
+}
+
+// 模拟规则，Type、Service、Method、Path 为空时匹配任意值
+type MockRule struct {
+	Type    string
+	Service string
+	Method  string
+	Path    string
+
+	match func(call *Call) bool
+	reply interface{}
+	err   error
+	// 可匹配次数，0 为不限制
+	times int
+	hits  int
+}
+
+// 按请求参数匹配
+func (r *MockRule) Match(match func(call *Call) bool) *MockRule {
+	r.match = match
+	return r
+}
+
+// 请求参数包含 data 中的所有字段时匹配
+func (r *MockRule) WithData(data map[string]interface{}) *MockRule {
+	return r.Match(func(call *Call) bool {
+		for k, v := range data {
+			if d, ok := call.Data[k]; !ok || fmt.Sprint(d) != fmt.Sprint(v) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// 设置返回值
+func (r *MockRule) Return(reply interface{}, err error) *MockRule {
+	r.reply, r.err = reply, err
+	return r
+}
+
+// 限制可匹配次数
+func (r *MockRule) Times(n int) *MockRule {
+	r.times = n
+	return r
+}
+
+func (r *MockRule) matches(call *Call) bool {
+	if r.times > 0 && r.hits >= r.times {
+		return false
+	}
+	if (r.Type != "" && r.Type != call.Type) || (r.Service != "" && r.Service != call.Service) ||
+		(r.Method != "" && r.Method != call.Method) || (r.Path != "" && r.Path != call.Path) {
+		return false
+	}
+	return r.match == nil || r.match(call)
+}
+
+// 测试用的模拟注册表，开启后所有 ral 调用由模拟规则返回，没有匹配规则时返回 ERR_MOCK_NOT_FOUND。
+// 录制模式下没有匹配规则的调用会请求真实服务，响应在 Stop 时写入录制文件
+type Mock struct {
+	mu        sync.Mutex
+	rules     []*MockRule
+	calls     []Call
+	unmatched []Call
+
+	// 录制
+	record   string
+	fixtures []fixture
+
+	// 资源不存在时使用的模拟实例
+	instances map[string]*Instance
+}
+
+func NewMock() *Mock {
+	return &Mock{instances: map[string]*Instance{}}
+}
+
+var mocking struct {
+	sync.RWMutex
+	mock *Mock
+}
+
+func currentMock() *Mock {
+	mocking.RLock()
+	defer mocking.RUnlock()
+	return mocking.mock
+}
+
+// 添加模拟规则，按添加顺序匹配
+func (m *Mock) On(modType string, service string, method string, path string) *MockRule {
+	r := &MockRule{Type: modType, Service: service, Method: method, Path: path}
+	m.mu.Lock()
+	m.rules = append(m.rules, r)
+	m.mu.Unlock()
+	return r
+}
+
+// 开启测试模式
+func (m *Mock) Start() {
+	mocking.Lock()
+	mocking.mock = m
+	mocking.Unlock()
+}
+
+// 关闭测试模式，录制模式下写入录制文件
+func (m *Mock) Stop() error {
+	mocking.Lock()
+	if mocking.mock == m {
+		mocking.mock = nil
+	}
+	mocking.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.record == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(m.fixtures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.record, buf, 0644)
+}
+
+// 开启录制，没有匹配规则的调用请求真实服务并记录响应
+func (m *Mock) Record(file string) *Mock {
+	m.mu.Lock()
+	m.record = file
+	m.mu.Unlock()
+	return m
+}
+
+// 加载录制文件，相同参数的调用按录制顺序返回
+func (m *Mock) Replay(file string) error {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var list []fixture
+	if err := json.Unmarshal(buf, &list); err != nil {
+		return err
+	}
+
+	for _, f := range list {
+		reply, err := f.Reply.decode(f.Type)
+		if err != nil {
+			return err
+		}
+		if f.Error != "" {
+			err = errors.New(f.Error)
+		}
+		key := f.Key
+		m.On(f.Type, f.Service, f.Method, f.Path).Times(1).Return(reply, err).Match(func(call *Call) bool {
+			return call.key() == key
+		})
+	}
+	return nil
+}
+
+// 返回所有调用
+func (m *Mock) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// 返回匹配的调用次数，参数为空时匹配任意值
+func (m *Mock) Called(modType string, service string, method string, path string) int {
+	r := &MockRule{Type: modType, Service: service, Method: method, Path: path}
+	n := 0
+	for _, c := range m.Calls() {
+		if r.matches(&c) {
+			n++
+		}
+	}
+	return n
+}
+
+// 返回没有匹配规则的调用
+func (m *Mock) Unmatched() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.unmatched...)
+}
+
+// 返回限制了次数但未全部匹配的规则
+func (m *Mock) Pending() []*MockRule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []*MockRule
+	for _, r := range m.rules {
+		if r.times > 0 && r.hits < r.times {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// 按模拟规则返回，录制模式下未匹配时执行 real 并记录响应
+func (m *Mock) do(ctx *gin.Context, call Call, real func() (interface{}, error)) (interface{}, error) {
+	// 调用记录中不保存 context
+	if data := call.Data; data[DATA_CONTEXT] != nil {
+		call.Data = make(map[string]interface{}, len(data))
+		for k, v := range data {
+			if k != DATA_CONTEXT {
+				call.Data[k] = v
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+	for _, r := range m.rules {
+		if r.matches(&call) {
+			r.hits++
+			m.mu.Unlock()
+			return r.reply, r.err
+		}
+	}
+	record := m.record != ""
+	if !record {
+		m.unmatched = append(m.unmatched, call)
+	}
+	m.mu.Unlock()
+
+	if !record {
+		zlog.WarnLogger(ctx, fmt.Sprintf("ral mock %s:%s %s %s not found", call.Type, call.Service, call.Method, call.Path), zap.String("prot", "ral"))
+		return nil, ERR_MOCK_NOT_FOUND
+	}
+
+	reply, err := real()
+	f := fixture{Type: call.Type, Service: call.Service, Method: call.Method, Path: call.Path, Key: call.key()}
+	f.Reply = encodeFixture(reply)
+	if err != nil {
+		f.Error = err.Error()
+	}
+	m.mu.Lock()
+	m.fixtures = append(m.fixtures, f)
+	m.mu.Unlock()
+	return reply, err
+}
+
+// 测试模式下，由当前模拟规则处理调用，供不经过 Instance.Request 的模块使用
+func MockCall(ctx *gin.Context, call Call, real func() (interface{}, error)) (interface{}, error) {
+	if m := currentMock(); m != nil {
+		return m.do(ctx, call, real)
+	}
+	return real()
+}
+
+// 测试模式下资源不存在时返回模拟实例，实例的 Client 由模块的 Mock 创建
+func mockInstance(modType string, name string) *Instance {
+	m := currentMock()
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ins, ok := m.instances[modType+":"+name]; ok {
+		return ins
+	}
+
+	lock.RLock()
+	mod := modules[modType]
+	lock.RUnlock()
+
+	res := &Resource{Type: modType, Name: name, mod: mod}
+	ins := &Instance{IP: "127.0.0.1", res: res}
+	if mod != nil && mod.Config != nil {
+		mod.Config(res)
+	}
+	// 不调用 Append，避免为模拟实例创建连接池及注册监控
+	if mod != nil && mod.Mock != nil {
+		ins.Client = mod.Mock(res, ins)
+	}
+	m.instances[modType+":"+name] = ins
+	return ins
+}
+
+// 录制文件中的一次调用
+type fixture struct {
+	Type    string        `json:"type"`
+	Service string        `json:"service"`
+	Method  string        `json:"method"`
+	Path    string        `json:"path,omitempty"`
+	Key     string        `json:"key"`
+	Reply   *fixtureValue `json:"reply,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// 录制的响应，保留类型以便回放时返回相同类型的值
+type fixtureValue struct {
+	Kind  string          `json:"kind"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Items []*fixtureValue `json:"items,omitempty"`
+}
+
+func encodeFixture(v interface{}) *fixtureValue {
+	raw := func(v interface{}) json.RawMessage {
+		buf, _ := json.Marshal(v)
+		return buf
+	}
+
+	switch r := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if utf8.Valid(r) {
+			return &fixtureValue{Kind: "text", Value: raw(string(r))}
+		}
+		return &fixtureValue{Kind: "bytes", Value: raw(r)}
+	case string:
+		return &fixtureValue{Kind: "string", Value: raw(r)}
+	case int64:
+		return &fixtureValue{Kind: "int64", Value: raw(r)}
+	case []interface{}:
+		f := &fixtureValue{Kind: "array", Items: make([]*fixtureValue, len(r))}
+		for i, item := range r {
+			f.Items[i] = encodeFixture(item)
+		}
+		return f
+	}
+	return &fixtureValue{Kind: "json", Value: raw(v)}
+}
+
+func (f *fixtureValue) decode(modType string) (interface{}, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	switch f.Kind {
+	case "text":
+		var s string
+		err := json.Unmarshal(f.Value, &s)
+		return []byte(s), err
+	case "bytes":
+		var b []byte
+		err := json.Unmarshal(f.Value, &b)
+		return b, err
+	case "string":
+		var s string
+		err := json.Unmarshal(f.Value, &s)
+		return s, err
+	case "int64":
+		var n int64
+		err := json.Unmarshal(f.Value, &n)
+		return n, err
+	case "array":
+		list := make([]interface{}, len(f.Items))
+		for i, item := range f.Items {
+			v, err := item.decode(modType)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	}
+
+	// 其他类型由模块按响应类型解码
+	lock.RLock()
+	mod := modules[modType]
+	lock.RUnlock()
+	if mod != nil && mod.Reply != nil {
+		reply := reflect.New(reflect.TypeOf(mod.Reply).Elem()).Interface()
+		err := json.Unmarshal(f.Value, reply)
+		return reply, err
+	}
+	var v interface{}
+	err := json.Unmarshal(f.Value, &v)
+	return v, err
+}
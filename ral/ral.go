@@ -16,9 +16,11 @@ import (
 	"net/http"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/metrics"
 	"github.com/GitHub121380/golib/utils"
 	"github.com/GitHub121380/golib/zlog"
 	"github.com/gin-gonic/gin"
@@ -41,31 +43,47 @@ const ( // 选取方法
 	WITH_ORDER  = "order"
 	WITH_FIRST  = "first"
 	WITH_LAST   = "last"
+	WITH_WEIGHT = "weight"
+	WITH_LEAST  = "least"
 )
 const (
 	DATA_CONTEXT = "_context"
 )
 
 var ( // 返回错误
-	ERR_NOT_FOUND_MODULE   = errors.New("not found module")
-	ERR_NOT_FOUND_RESOURCE = errors.New("not found resource")
-	ERR_NOT_FOUND_INSTANCE = errors.New("not found instance")
-	ERR_NOT_FOUND_CLIENT   = errors.New("not found client")
-	ERR_NOT_FOUND_METHOD   = errors.New("not found method")
-	ERR_NOT_FOUND_ENCODE   = errors.New("not found encode")
-	ERR_NOT_FOUND_LOGID    = errors.New("not found logid")
-	ERR_NOT_FOUND_PATH     = errors.New("not found path")
-	ERR_NOT_FOUND_CMD      = errors.New("not found cmd")
-	ERR_NOT_FOUND_ARG      = errors.New("not found arg")
+	ERR_NOT_FOUND_MODULE    = errors.New("not found module")
+	ERR_NOT_FOUND_RESOURCE  = errors.New("not found resource")
+	ERR_NOT_FOUND_INSTANCE  = errors.New("not found instance")
+	ERR_NOT_FOUND_CLIENT    = errors.New("not found client")
+	ERR_NOT_FOUND_METHOD    = errors.New("not found method")
+	ERR_NOT_FOUND_ENCODE    = errors.New("not found encode")
+	ERR_NOT_FOUND_LOGID     = errors.New("not found logid")
+	ERR_NOT_FOUND_PATH      = errors.New("not found path")
+	ERR_NOT_FOUND_CMD       = errors.New("not found cmd")
+	ERR_NOT_FOUND_ARG       = errors.New("not found arg")
+	ERR_BUDGET_EXHAUSTED    = errors.New("timeout budget exhausted")
+	ERR_CONTEXT_CANCELED    = errors.New("context canceled")
+	ERR_RATE_LIMITED        = errors.New("rate limited")
+	ERR_CONCURRENCY_LIMITED = errors.New("concurrency limited")
 )
 
 type Instance struct {
-	IP   string
-	Port int
+	IP     string
+	Port   int
+	Weight int
+	// 染色标记，为空时为基准实例
+	Color string
 
 	Client interface{}
 	subs   []*Instance
 	res    *Resource
+
+	breaker *breaker
+	health  health
+
+	// 负载均衡
+	current  int
+	inflight int64
 }
 
 func (ins *Instance) Request(ctx *gin.Context, method string, data map[string]interface{}, head map[string]string) (interface{}, error) {
@@ -78,6 +96,17 @@ func (ins *Instance) Request(ctx *gin.Context, method string, data map[string]in
 		return nil, ERR_NOT_FOUND_RESOURCE
 	}
 
+	// 测试模式下由模拟规则返回
+	if m := currentMock(); m != nil {
+		call := Call{Type: res.Type, Service: res.Name, Method: method, Path: head[mockPathHead], Data: data}
+		return m.do(ctx, call, func() (interface{}, error) {
+			return ins.invoke(ctx, res, method, data, head)
+		})
+	}
+	return ins.invoke(ctx, res, method, data, head)
+}
+
+func (ins *Instance) invoke(ctx *gin.Context, res *Resource, method string, data map[string]interface{}, head map[string]string) (interface{}, error) {
 	mod := res.mod
 	if mod == nil {
 		return nil, ERR_NOT_FOUND_MODULE
@@ -92,21 +121,59 @@ func (ins *Instance) Request(ctx *gin.Context, method string, data map[string]in
 		head["SERVICE"] = env.AppName
 	}
 	// new span and inject header
-	injectSpanContextToHeader(ctx, head)
+	span := zlog.StartSpan(ctx, res.Type+":"+res.Name)
+	span.SetTag("prot", res.Type)
+	span.SetTag("service", res.Name)
+	span.SetTag("method", method)
+	span.SetTag("remoteAddr", fmt.Sprintf("%s:%d", ins.IP, ins.Port))
+	injectSpanContextToHeader(span, head)
+	injectBudget(ctx, head)
+	injectColor(ctx, head)
+	done := metrics.Client(res.Type, res.Name, method)
+
+	// 超过限流配置时快速失败或等待
+	release, err := res.acquire(ctx)
+	if err != nil {
+		span.SetError(err)
+		span.Finish()
+		done(err)
+		return nil, err
+	}
+
+	// 模块通过 Budget 感知调用方的剩余时间，不感知 context 的模块在调用方超时或取消后立即返回
+	start := time.Now()
+	var reply interface{}
+	if err = Err(ctx); err == nil {
+		if mod.Blocking {
+			reply, err = Wait(ctx, func(ctx *gin.Context) (interface{}, error) {
+				return ins.request(ctx, mod, method, data, head)
+			})
+		} else {
+			reply, err = ins.request(ctx, mod, method, data, head)
+		}
+	}
+	release(time.Since(start), err)
+
+	span.SetError(err)
+	span.Finish()
+	done(err)
+	return reply, err
+}
 
+func (ins *Instance) request(ctx *gin.Context, mod *Module, method string, data map[string]interface{}, head map[string]string) (interface{}, error) {
 	if len(mod.Methods) > 0 {
 		if method, ok := mod.Methods[method]; ok {
-			return method(ctx, res, ins, data, head)
+			return method(ctx, ins.res, ins, data, head)
 		}
 	}
 
 	if mod.Method != nil {
-		return mod.Method(ctx, res, ins, method, data, head)
+		return mod.Method(ctx, ins.res, ins, method, data, head)
 	}
 	return nil, ERR_NOT_FOUND_METHOD
 }
 
-func injectSpanContextToHeader(ctx *gin.Context, head map[string]string) {
+func injectSpanContextToHeader(span *zlog.Span, head map[string]string) {
 	h := make(http.Header)
 	for k, v := range head {
 		h.Set(k, v)
@@ -115,40 +182,73 @@ func injectSpanContextToHeader(ctx *gin.Context, head map[string]string) {
 	if v, ok := h["Trace-Id"]; ok && len(v) > 0 {
 		head["Trace-Id"] = v[0]
 	}
-	head["x_bd_spanid"] = zlog.CreateSpan(ctx)
-	head["x_bd_logid"] = zlog.GetLogID(ctx)
+	span.Inject(head)
 }
 
 func (ins *Instance) Release() {
+	if ins != nil {
+		ins.release()
+	}
 	if ins != nil && ins.res != nil {
-		if ins.res.list != nil {
+		res := ins.res
+		res.lock.RLock()
+		empty := res.list == nil
+		res.lock.RUnlock()
+		if !empty {
 			return
 		}
-		ins.res.count++
+		res.lock.Lock()
+		res.count++
+		res.lock.Unlock()
 	}
 }
 
 // 返回retry值
 func (ins *Instance) Retry(cb func(res *Resource, ins *Instance) bool) int {
+	return ins.RetryContext(nil, cb)
+}
+
+// 返回retry值，调用方超时或取消后不再重试
+func (ins *Instance) RetryContext(ctx *gin.Context, cb func(res *Resource, ins *Instance) bool) int {
 	res := ins.res
-	if res.Retry < 0 {
-		res.Retry = 0
+	retry := res.Snapshot().Retry
+	if retry < 0 {
+		retry = 0
 	}
-	if res.Retry > 3 {
-		res.Retry = 3
+	if retry > 3 {
+		retry = 3
 	}
 	curIns := ins
-	for i := 0; i < res.Retry+1; i++ {
-		if !cb(res, curIns) || i == res.Retry {
+	// 重试选取的实例在调用期间保持占用，下次重试或返回时释放
+	defer func() {
+		if curIns != ins {
+			curIns.Release()
+		}
+	}()
+	for i := 0; i < retry+1; i++ {
+		start := time.Now()
+		failed := cb(res, curIns)
+		// 调用方超时或取消导致的失败不计入熔断统计
+		err := Err(ctx)
+		if !failed || err == nil {
+			curIns.report(failed, time.Since(start))
+		}
+		if !failed || i == retry {
+			return i
+		}
+		if err != nil {
+			zlog.WarnLogger(ctx, fmt.Sprintf("ral retry %s:%s stop: %s", res.Type, res.Name, err.Error()), zap.String("prot", "ral"))
 			return i
 		}
 
-		if next, err := GetInstance(nil, res.Type, res.Name); err == nil {
-			next.Release()
+		if next, err := GetInstance(ctx, res.Type, res.Name); err == nil {
+			if curIns != ins {
+				curIns.Release()
+			}
 			curIns = next
 		}
 	}
-	return res.Retry
+	return retry
 }
 
 type Resource struct {
@@ -177,6 +277,13 @@ type Resource struct {
 		Password    string
 		Database    int
 		Wait        bool
+		// 部署模式，为空时为单实例；cluster 为集群模式，实例为种子节点；
+		// sentinel 为哨兵模式，实例为哨兵节点
+		Mode string
+		// 哨兵模式下的主节点名
+		MasterName string
+		// 哨兵节点的密码，为空时不认证
+		SentinelPassword string
 	}
 
 	// Mysql配置
@@ -199,6 +306,86 @@ type Resource struct {
 
 	// 选取策略
 	Strategy string
+	// 一致性hash每个实例的虚拟节点数
+	HashReplicas int
+
+	// 熔断配置
+	Breaker struct {
+		Enable bool
+		// 连续失败次数
+		Failures int
+		// 统计窗口内的错误率(百分比)，0 为不开启
+		ErrorPercent int
+		MinRequests  int
+		Window       time.Duration
+		// 超过该耗时的调用视为失败，0 为不开启
+		SlowThreshold time.Duration
+		// 熔断持续时间，之后进入半开状态
+		OpenTimeout time.Duration
+		// 半开状态下的探测请求数
+		HalfOpenProbes int
+	}
+
+	// 健康检查配置，模块未提供检查方法时使用TCP连接检查
+	HealthCheck struct {
+		Enable   bool
+		Interval time.Duration
+		Timeout  time.Duration
+		// http 模块检查的路径，为空时使用TCP连接检查
+		Path string
+		// 连续失败多少次后摘除实例
+		Failures int
+		// 连续成功多少次后恢复实例
+		Successes int
+	}
+
+	// 机房切换配置，本机房可用实例不足时按顺序切换到其他机房
+	Failover struct {
+		// 备用机房，实例从 Manual 或 ZNS.IDC 中对应机房的配置加载
+		IDC []string
+		// 本机房可用实例数低于该值时切换，默认1
+		MinHealthy int
+	}
+
+	// 对冲请求配置，仅用于幂等的读请求
+	Hedge struct {
+		Enable bool
+		// 首个请求超过该时间未返回时发起对冲请求
+		Delay time.Duration
+		// 按最近调用耗时的分位数计算延迟，样本不足时使用 Delay，0 为不开启
+		Percentile int
+		// 最多额外发起的请求数，默认1
+		Max int
+	}
+
+	// 限流配置，超过限制的请求返回 ERR_RATE_LIMITED 或 ERR_CONCURRENCY_LIMITED
+	Limit struct {
+		// 每秒请求数，0 为不限制
+		QPS float64
+		// 令牌桶容量，默认与 QPS 相同
+		Burst int
+		// 最大并发数，0 为不限制，开启自适应时为并发上限，默认1000
+		Concurrency int
+		// 根据调用耗时自适应调整并发上限
+		Adaptive bool
+		// 自适应并发下限，默认1
+		MinConcurrency int
+		// 耗时超过该值时减小并发上限，为空时使用无负载耗时的2倍
+		Latency time.Duration
+		// 超过限制时的最长等待时间，0 为立即失败
+		Wait time.Duration
+	}
+
+	// 流量镜像配置，按比例将请求异步复制到另一个同类型资源，镜像响应丢弃
+	Mirror struct {
+		Enable bool
+		// 镜像资源名
+		Target string
+		// 采样比例(百分比)，默认100
+		Percent int
+		// 记录主请求与镜像请求的状态码及响应摘要差异
+		Diff bool
+	}
 
 	// 服务域名
 	ZNS struct {
@@ -207,16 +394,20 @@ type Resource struct {
 	}
 	// 服务地址
 	Manual map[string][]struct {
-		IP   string
-		Host string
-		Port int
+		IP     string
+		Host   string
+		Port   int
+		Weight int
+		Color  string
 	}
 
 	Depend []string
 
 	// 选取参数
-	order int
-	rand  *rand.Rand
+	order  int
+	rand   *rand.Rand
+	cursor int
+	pick   sync.Mutex
 
 	// 实例计数
 	limit int
@@ -226,6 +417,23 @@ type Resource struct {
 	// 实例列表
 	list []*Instance
 	busy []*Instance
+	ring *hashRing
+
+	// 最近调用耗时
+	latency latencyWindow
+	// 限流
+	limiter limiter
+	// 健康检查是否已启动
+	checking int32
+
+	// 机房切换
+	idc     string
+	primary *Resource
+	standby []*Resource
+	serving atomic.Value
+
+	// 热加载后的配置快照 *Resource，参见 Snapshot
+	conf atomic.Value
 
 	mod *Module
 
@@ -245,6 +453,18 @@ type Module struct {
 	// 方法列表
 	Methods map[string]func(*gin.Context, *Resource, *Instance, map[string]interface{}, map[string]string) (interface{}, error)
 	Method  func(*gin.Context, *Resource, *Instance, string, map[string]interface{}, map[string]string) (interface{}, error)
+
+	// 健康检查
+	Check func(res *Resource, ins *Instance, timeout time.Duration) error
+
+	// 方法不感知调用方的 context 及剩余时间，调用在后台执行，调用方超时或取消后立即返回
+	Blocking bool
+
+	// 响应类型，需为指针，回放录制文件时按该类型解码
+	Reply interface{}
+
+	// 模拟模式下为资源不存在时的模拟实例创建客户端，不应建立连接。为空时模拟实例不包含客户端
+	Mock func(res *Resource, ins *Instance) interface{}
 }
 
 var modules = map[string]*Module{}
@@ -282,6 +502,8 @@ func AddResource(res *Resource) *Resource {
 		dependons[dep] = append(dependons[dep], res)
 		zlog.InfoLogger(nil, fmt.Sprintf("ral add dependon %s->%s:%s", dep, res.Type, res.Name), zap.String("prot", "ral"))
 	}
+
+	res.startHealthCheck()
 	return res
 }
 func GetResource(modType string, name string) (*Resource, bool) {
@@ -305,6 +527,8 @@ func AddInstance(modType string, name string, ins *Instance) (*Instance, error)
 
 	zlog.InfoLogger(nil, fmt.Sprintf("ral add instance %s:%s %s:%d", modType, name, ins.IP, ins.Port), zap.String("prot", "ral"))
 	res.list, ins.res = append(res.list, ins), res
+	res.ring = newHashRing(res.list, res.HashReplicas)
+	ins.breaker = newBreaker(ins)
 	res.count++
 	res.total++
 
@@ -313,6 +537,12 @@ func AddInstance(modType string, name string, ins *Instance) (*Instance, error)
 			if sub, err := mod.Append(v, res, ins); err != nil {
 				return nil, err
 			} else if sub != nil {
+				if sub.Weight == 0 {
+					sub.Weight = ins.Weight
+				}
+				if sub.Color == "" {
+					sub.Color = ins.Color
+				}
 				i, err := AddInstance(v.Type, v.Name, sub)
 				if err != nil {
 					return nil, err
@@ -341,6 +571,7 @@ func DelInstance(Type string, Name string, ins *Instance) error {
 			res.list = res.list[:len(res.list)-1]
 		}
 	}
+	res.ring = newHashRing(res.list, res.HashReplicas)
 	res.count--
 	res.total--
 
@@ -357,22 +588,67 @@ func DelInstance(Type string, Name string, ins *Instance) error {
 }
 
 func GetInstance(ctx *gin.Context, modType string, name string) (*Instance, error) {
+	return getInstance(ctx, modType, name, "")
+}
+
+// 按key通过一致性hash选取实例，相同的key会落到同一个实例上
+func GetInstanceByKey(ctx *gin.Context, modType string, name string, key string) (*Instance, error) {
+	return getInstance(ctx, modType, name, key)
+}
+
+func getInstance(ctx *gin.Context, modType string, name string, key string) (*Instance, error) {
 	fields := []zap.Field{
 		zap.String("prot", "ral"),
 	}
 
 	res, ok := GetResource(modType, name)
 	if !ok {
+		if ins := mockInstance(modType, name); ins != nil {
+			return ins, nil
+		}
 		zlog.WarnLogger(ctx, "ral GetInstance nil, modType: "+modType+"name: "+name, fields...)
 		return nil, ERR_NOT_FOUND_RESOURCE
 	}
+	res = res.failover(ctx)
+
+	// 热加载时实例列表可能被并发修改，加锁后再读取
+	res.lock.RLock()
+	defer res.lock.RUnlock()
+
 	if res.count <= 0 {
+		if ins := mockInstance(modType, name); ins != nil {
+			return ins, nil
+		}
 		zlog.WarnLogger(ctx, "ral GetInstance count<=0 "+modType+"name: "+name, fields...)
 		return nil, ERR_NOT_FOUND_INSTANCE
 	}
 
-	res.lock.RLock()
-	defer res.lock.RUnlock()
+	if key != "" || res.Strategy == WITH_HASH {
+		if ins := res.hash(ctx, key); ins != nil {
+			// 熔断器不放行时顺时针选取下一个放行的实例
+			if !ins.allow() {
+				if next := res.ring.get(key, (*Instance).allow); next != nil {
+					ins = next
+				}
+			}
+			zlog.DebugLogger(ctx, fmt.Sprintf("ral get instance %s:%s %s:%s %s:%d", modType, name, WITH_HASH, key, ins.IP, ins.Port), fields...)
+			ins.acquire()
+			return ins, nil
+		}
+	}
+
+	list, count := res.list, res.count
+	if conf := res.Snapshot(); (conf.Breaker.Enable || conf.HealthCheck.Enable) && len(list) > 0 {
+		// 剔除熔断中及健康检查失败的实例，全部不可用时不做剔除
+		if l := res.available(); len(l) > 0 {
+			list, count = l, len(l)
+		} else {
+			zlog.WarnLogger(ctx, "ral GetInstance all instances unavailable "+modType+"name: "+name, fields...)
+		}
+	}
+	if l := lane(ctx, list); len(l) != len(list) {
+		list, count = l, len(l)
+	}
 
 	which := 0
 	switch res.Strategy {
@@ -380,26 +656,82 @@ func GetInstance(ctx *gin.Context, modType string, name string) (*Instance, erro
 		if res.rand == nil {
 			res.rand = rand.New(rand.NewSource(time.Now().Unix()))
 		}
-		which = res.rand.Intn(res.count)
-	case WITH_HASH:
+		which = res.rand.Intn(count)
 	case WITH_FIRST:
 		which = 0
 	case WITH_LAST:
-		if res.count > 1 {
-			which = res.count - 1
+		if count > 1 {
+			which = count - 1
 		}
 	case WITH_ORDER:
-		which = res.order % res.count
+		which = res.order % count
 		res.order++
+	case WITH_WEIGHT:
+		if len(list) > 0 {
+			which = res.weighted(list)
+		}
+	case WITH_LEAST:
+		if len(list) > 0 {
+			which = res.least(list)
+		}
 	}
 
-	if res.list != nil && which < len(res.list) {
-		zlog.DebugLogger(ctx, fmt.Sprintf("ral get instance %s:%s %s:%d %s:%d", modType, name, res.Strategy, which, res.list[which].IP, res.list[which].Port), fields...)
-		return res.list[which], nil
+	if list != nil && which < len(list) {
+		ins := allowed(list, which)
+		zlog.DebugLogger(ctx, fmt.Sprintf("ral get instance %s:%s %s:%d %s:%d", modType, name, res.Strategy, which, ins.IP, ins.Port), fields...)
+		ins.acquire()
+		return ins, nil
 	}
 	res.count--
 	return &Instance{res: res}, nil
 }
+
+// 从选中的实例开始依次查找熔断器放行的实例，半开状态下占用探测名额。
+// 全部不放行时返回选中的实例
+func allowed(list []*Instance, which int) *Instance {
+	for i := 0; i < len(list); i++ {
+		if ins := list[(which+i)%len(list)]; ins.allow() {
+			return ins
+		}
+	}
+	return list[which]
+}
+
+// 一致性hash选取实例，未指定key时使用logid，优先跳过熔断中及不健康的实例
+func (res *Resource) hash(ctx *gin.Context, key string) *Instance {
+	if key == "" {
+		if ctx == nil {
+			return nil
+		}
+		key = zlog.GetLogID(ctx)
+	}
+
+	// 优先选取染色标记匹配的实例
+	if color, ok := laneColor(ctx, res.list); ok {
+		if ins := res.ring.get(key, func(ins *Instance) bool {
+			return ins.Color == color && ins.usable()
+		}); ins != nil {
+			return ins
+		}
+	}
+	if conf := res.Snapshot(); conf.Breaker.Enable || conf.HealthCheck.Enable {
+		if ins := res.ring.get(key, (*Instance).usable); ins != nil {
+			return ins
+		}
+	}
+	return res.ring.get(key, nil)
+}
+
+// 返回未被熔断且健康的实例列表
+func (res *Resource) available() []*Instance {
+	list := make([]*Instance, 0, len(res.list))
+	for _, ins := range res.list {
+		if ins.usable() {
+			list = append(list, ins)
+		}
+	}
+	return list
+}
 func GetAllInstance(Type string, Name string) []*Instance {
 	if res, ok := GetResource(Type, Name); ok {
 		return res.list
@@ -442,56 +774,98 @@ func Init(dir string) (err error) {
 			continue
 		}
 		AddResource(resource)
+
+		confLock.Lock()
+		confResources[resource.Type+":"+resource.Name] = true
+		confLock.Unlock()
 	}
 
 	for _, res := range resources {
-		if mod := res.mod; len(res.Manual) > 0 {
-			for _, v := range res.Manual[env.IDC] {
-				if mod != nil && mod.Append != nil {
-					ins, err := mod.Append(res, res, &Instance{IP: v.IP, Port: v.Port})
-					if err != nil {
-						return err
-					}
-					if _, err = AddInstance(res.Type, res.Name, ins); err != nil {
-						return err
-					}
-				} else {
-					i := &Instance{
-						IP:   v.IP,
-						Port: v.Port,
-					}
-					if _, err = AddInstance(res.Type, res.Name, i); err != nil {
-						return err
-					}
-				}
-			}
-		} else {
-			var znsName string
-			if res.ZNS.Name != "" {
-				znsName = res.ZNS.Name
-			} else if z, exist := res.ZNS.IDC[env.IDC]; exist {
-				znsName = z
-			}
+		if err := initResource(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			if znsName != "" {
-				// todo: 由于值拷贝存在锁问题，直接初始化。Resource 变更需要同步修改此处
-				r := &Resource{
-					Type:         TYPE_ZNS,
-					Name:         znsName,
-					ConnTimeOut:  res.ConnTimeOut,
-					ReadTimeOut:  res.ReadTimeOut,
-					WriteTimeOut: res.WriteTimeOut,
-					Retry:        res.Retry,
-					Encode:       res.Encode,
-					Decode:       res.Decode,
-					Redis:        res.Redis,
-					Mysql:        res.Mysql,
-					HBase:        res.HBase,
-					Strategy:     res.Strategy,
-				}
-				AddResource(r)
+// 初始化资源实例及其他机房的备用实例
+func initResource(res *Resource) error {
+	// 备用资源由所属资源初始化
+	if res.primary != nil {
+		return nil
+	}
+
+	res.idc = env.IDC
+	if err := initIDC(res, env.IDC); err != nil {
+		return err
+	}
+	return initFailover(res)
+}
+
+// 初始化指定机房的实例，配置了Manual时直接添加实例，否则添加zns依赖
+func initIDC(res *Resource, idc string) error {
+	if len(res.Manual) > 0 {
+		for _, v := range res.Manual[idc] {
+			if _, err := appendManual(res, v.IP, v.Port, v.Weight, v.Color); err != nil {
+				return err
 			}
 		}
+		return nil
+	}
+
+	var znsName string
+	if res.ZNS.Name != "" {
+		znsName = res.ZNS.Name
+	} else if z, exist := res.ZNS.IDC[idc]; exist {
+		znsName = z
+	}
+
+	if znsName != "" {
+		AddResource(res.clone(TYPE_ZNS, znsName))
 	}
 	return nil
 }
+
+// 复制资源配置，不包含实例、服务地址及机房切换配置
+func (res *Resource) clone(modType string, name string) *Resource {
+	// todo: 由于值拷贝存在锁问题，直接初始化。Resource 变更需要同步修改此处
+	conf := res.Snapshot()
+	return &Resource{
+		Type:         modType,
+		Name:         name,
+		ConnTimeOut:  res.ConnTimeOut,
+		ReadTimeOut:  res.ReadTimeOut,
+		WriteTimeOut: res.WriteTimeOut,
+		Retry:        conf.Retry,
+		Encode:       res.Encode,
+		Decode:       res.Decode,
+		LongConnect:  res.LongConnect,
+		Redis:        res.Redis,
+		Mysql:        res.Mysql,
+		HBase:        res.HBase,
+		Strategy:     res.Strategy,
+		HashReplicas: res.HashReplicas,
+		Breaker:      conf.Breaker,
+		HealthCheck:  conf.HealthCheck,
+		Hedge:        conf.Hedge,
+		Limit:        conf.Limit,
+	}
+}
+
+// 添加手动配置的实例
+func appendManual(res *Resource, ip string, port int, weight int, color string) (*Instance, error) {
+	if mod := res.mod; mod != nil && mod.Append != nil {
+		ins, err := mod.Append(res, res, &Instance{IP: ip, Port: port, Weight: weight, Color: color})
+		if err != nil {
+			return nil, err
+		}
+		if ins != nil && ins.Weight == 0 {
+			ins.Weight = weight
+		}
+		if ins != nil && ins.Color == "" {
+			ins.Color = color
+		}
+		return AddInstance(res.Type, res.Name, ins)
+	}
+	return AddInstance(res.Type, res.Name, &Instance{IP: ip, Port: port, Weight: weight, Color: color})
+}
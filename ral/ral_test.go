@@ -0,0 +1,808 @@
+package ral
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/utils"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func init() {
+	zlog.ModuleLogger = zap.NewNop()
+	zlog.SetSpanExporter(nil)
+}
+
+func newTestResource(name string, ports ...int) *Resource {
+	res := AddResource(&Resource{Type: "test", Name: name})
+	for _, port := range ports {
+		_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: port})
+	}
+	return res
+}
+
+func TestBreaker(t *testing.T) {
+	res := newTestResource("breaker", 8001, 8002)
+	res.Breaker.Enable = true
+	res.Breaker.Failures = 3
+	res.Breaker.OpenTimeout = 50 * time.Millisecond
+
+	bad := res.list[0]
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, BREAKER_CLOSED, bad.BreakerState())
+		bad.report(true, time.Millisecond)
+	}
+	assert.Equal(t, BREAKER_OPEN, bad.BreakerState())
+
+	// 熔断中的实例不会被选取
+	for i := 0; i < 10; i++ {
+		ins, err := GetInstance(nil, res.Type, res.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, 8002, ins.Port)
+	}
+
+	// 熔断超时后放行一个探测请求
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, bad.allow())
+	assert.Equal(t, BREAKER_HALF_OPEN, bad.BreakerState())
+	assert.False(t, bad.allow())
+
+	bad.report(true, time.Millisecond)
+	assert.Equal(t, BREAKER_OPEN, bad.BreakerState())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, bad.allow())
+	bad.report(false, time.Millisecond)
+	assert.Equal(t, BREAKER_CLOSED, bad.BreakerState())
+
+	// 探测名额用完的实例不会被选取
+	for i := 0; i < 3; i++ {
+		bad.report(true, time.Millisecond)
+	}
+	time.Sleep(60 * time.Millisecond)
+	res.Strategy = WITH_FIRST
+	ins, err := GetInstance(nil, res.Type, res.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, bad, ins)
+	assert.Equal(t, BREAKER_HALF_OPEN, bad.BreakerState())
+	for i := 0; i < 5; i++ {
+		ins, err = GetInstance(nil, res.Type, res.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, 8002, ins.Port)
+	}
+}
+
+func TestBreakerErrorRateAndSlow(t *testing.T) {
+	res := newTestResource("breaker-rate", 8001)
+	res.Breaker.Enable = true
+	res.Breaker.Failures = 100
+	res.Breaker.ErrorPercent = 50
+	res.Breaker.MinRequests = 4
+	res.Breaker.SlowThreshold = 100 * time.Millisecond
+
+	ins := res.list[0]
+	ins.report(false, time.Millisecond)
+	ins.report(true, time.Millisecond)
+	ins.report(false, time.Millisecond)
+	assert.Equal(t, BREAKER_CLOSED, ins.BreakerState())
+	// 慢调用视为失败
+	ins.report(false, time.Second)
+	assert.Equal(t, BREAKER_OPEN, ins.BreakerState())
+
+	// 全部熔断时不做剔除
+	got, err := GetInstance(nil, res.Type, res.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, ins, got)
+}
+
+func TestGetInstanceByKey(t *testing.T) {
+	res := newTestResource("hash", 8001, 8002, 8003, 8004)
+
+	keys := make([]string, 1000)
+	before := map[string]int{}
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		ins, err := GetInstanceByKey(nil, res.Type, res.Name, keys[i])
+		assert.NoError(t, err)
+		before[keys[i]] = ins.Port
+
+		again, _ := GetInstanceByKey(nil, res.Type, res.Name, keys[i])
+		assert.Equal(t, ins, again)
+	}
+
+	// 新增实例后只有少量key发生迁移
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8005})
+	moved := 0
+	for _, key := range keys {
+		ins, _ := GetInstanceByKey(nil, res.Type, res.Name, key)
+		if ins.Port != before[key] {
+			assert.Equal(t, 8005, ins.Port)
+			moved++
+		}
+	}
+	assert.True(t, moved > 0 && moved < len(keys)/2, "moved %d", moved)
+
+	// 删除实例后其余key不受影响
+	_ = DelInstance(res.Type, res.Name, res.list[len(res.list)-1])
+	for _, key := range keys {
+		ins, _ := GetInstanceByKey(nil, res.Type, res.Name, key)
+		assert.Equal(t, before[key], ins.Port)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	res := AddResource(&Resource{Type: "test", Name: "weight", Strategy: WITH_WEIGHT})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8001, Weight: 1})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8002, Weight: 3})
+
+	hits := map[int]int{}
+	for i := 0; i < 400; i++ {
+		ins, err := GetInstance(nil, res.Type, res.Name)
+		assert.NoError(t, err)
+		hits[ins.Port]++
+		ins.Release()
+	}
+	assert.Equal(t, 100, hits[8001])
+	assert.Equal(t, 300, hits[8002])
+
+	res.list[0].SetWeight(3)
+	hits = map[int]int{}
+	for i := 0; i < 400; i++ {
+		ins, _ := GetInstance(nil, res.Type, res.Name)
+		hits[ins.Port]++
+		ins.Release()
+	}
+	assert.Equal(t, 200, hits[8001])
+	assert.Equal(t, 200, hits[8002])
+}
+
+func TestLeast(t *testing.T) {
+	res := AddResource(&Resource{Type: "test", Name: "least", Strategy: WITH_LEAST})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8001})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8002})
+
+	a, _ := GetInstance(nil, res.Type, res.Name)
+	b, _ := GetInstance(nil, res.Type, res.Name)
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, int64(1), a.Inflight())
+
+	// 未释放的实例不会被继续选取
+	c, _ := GetInstance(nil, res.Type, res.Name)
+	assert.Equal(t, a, c)
+	b.Release()
+	for i := 0; i < 5; i++ {
+		ins, _ := GetInstance(nil, res.Type, res.Name)
+		assert.Equal(t, b, ins)
+		ins.Release()
+	}
+	c.Release()
+	a.Release()
+	assert.Equal(t, int64(0), a.Inflight())
+	assert.Equal(t, int64(0), b.Inflight())
+
+	// 重试期间占用选取的实例，返回后释放
+	res.Retry = 1
+	ins, _ := GetInstance(nil, res.Type, res.Name)
+	var inflight []int64
+	ins.Retry(func(res *Resource, cur *Instance) bool {
+		inflight = append(inflight, a.Inflight()+b.Inflight())
+		return true
+	})
+	ins.Release()
+	assert.Equal(t, []int64{1, 2}, inflight)
+	assert.Equal(t, int64(0), a.Inflight())
+	assert.Equal(t, int64(0), b.Inflight())
+}
+
+func TestReload(t *testing.T) {
+	removed := 0
+	AddModule(&Module{
+		Type: "reload",
+		Append: func(self *Resource, res *Resource, ins *Instance) (*Instance, error) {
+			return &Instance{IP: ins.IP, Port: ins.Port, Client: res.ReadTimeOut}, nil
+		},
+		Remove: func(self *Instance, res *Resource, ins *Instance) bool {
+			removed++
+			return true
+		},
+	})
+
+	dir, err := ioutil.TempDir("", "ral")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "reload.yaml")
+	write := func(conf string) {
+		assert.NoError(t, ioutil.WriteFile(file, []byte(conf), 0644))
+	}
+	conf := `
+type: reload
+name: service
+readTimeOut: %s
+retry: %d
+manual:
+  %s:
+%s`
+	write(fmt.Sprintf(conf, "1s", 1, env.IDC, "    - ip: 127.0.0.1\n      port: 8001\n    - ip: 127.0.0.1\n      port: 8002\n"))
+	assert.NoError(t, Init(dir))
+
+	res, ok := GetResource("reload", "service")
+	assert.True(t, ok)
+	assert.Equal(t, 1, res.Retry)
+	assert.Len(t, res.list, 2)
+
+	// 热加载与调用并发时不加锁读取的配置通过快照替换
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if ins, err := GetInstance(nil, res.Type, res.Name); err == nil {
+				ins.RetryContext(nil, func(res *Resource, ins *Instance) bool { return false })
+				ins.Release()
+			}
+		}
+	}()
+
+	// 修改重试次数及实例列表
+	write(fmt.Sprintf(conf, "1s", 2, env.IDC, "    - ip: 127.0.0.1\n      port: 8002\n      weight: 5\n    - ip: 127.0.0.1\n      port: 8003\n"))
+	assert.NoError(t, Reload(dir))
+	close(stop)
+	<-done
+	assert.Equal(t, 2, res.Snapshot().Retry)
+	assert.Len(t, res.list, 2)
+	assert.Equal(t, 8002, res.list[0].Port)
+	assert.Equal(t, 5, res.list[0].Weight)
+	assert.Equal(t, 8003, res.list[1].Port)
+	assert.Equal(t, 1, removed)
+
+	// 修改超时需要重建实例
+	write(fmt.Sprintf(conf, "2s", 2, env.IDC, "    - ip: 127.0.0.1\n      port: 8002\n      weight: 5\n    - ip: 127.0.0.1\n      port: 8003\n"))
+	assert.NoError(t, Reload(dir))
+	assert.Len(t, res.list, 2)
+	for _, ins := range res.list {
+		assert.Equal(t, 2*time.Second, ins.Client)
+	}
+	assert.Equal(t, 3, removed)
+
+	// 解析失败时保留原有配置
+	write("type: [reload")
+	assert.Error(t, Reload(dir))
+	assert.Equal(t, 2, res.Snapshot().Retry)
+	assert.Len(t, res.list, 2)
+}
+
+func TestBudget(t *testing.T) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/", nil)
+	ctx.Request.Header.Set(HEAD_TIMEOUT, "50")
+
+	// 未透传剩余时间时使用配置的超时
+	d, err := Budget(ctx, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, d)
+
+	cancel := WithBudget(ctx, ctx.GetHeader)
+	defer cancel()
+
+	d, err = Budget(ctx, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, d > 0 && d <= 50*time.Millisecond)
+
+	head := map[string]string{}
+	injectBudget(ctx, head)
+	assert.NotEmpty(t, head[HEAD_TIMEOUT])
+
+	// 超时后立即返回
+	start := time.Now()
+	_, err = Wait(ctx, func(ctx *gin.Context) (interface{}, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	})
+	assert.Equal(t, ERR_BUDGET_EXHAUSTED, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	_, err = Budget(ctx, time.Second)
+	assert.Equal(t, ERR_BUDGET_EXHAUSTED, err)
+
+	// 超时后不再重试
+	res := newTestResource("budget", 8001, 8002)
+	res.Retry = 3
+	calls := 0
+	retry := res.list[0].RetryContext(ctx, func(res *Resource, ins *Instance) bool {
+		calls++
+		return true
+	})
+	assert.Equal(t, 0, retry)
+	assert.Equal(t, 1, calls)
+}
+
+func TestInvoke(t *testing.T) {
+	method := func(ctx *gin.Context, res *Resource, ins *Instance, method string,
+		data map[string]interface{}, head map[string]string) (interface{}, error) {
+		if method == "slow" {
+			time.Sleep(time.Second)
+		}
+		ctx.Set("invoked", method)
+		return nil, nil
+	}
+	AddModule(&Module{Type: "invoke", Method: method})
+	AddModule(&Module{Type: "invoke-blocking", Method: method, Blocking: true})
+	AddResource(&Resource{Type: "invoke", Name: "invoke"})
+	AddResource(&Resource{Type: "invoke-blocking", Name: "invoke"})
+	_, _ = AddInstance("invoke", "invoke", &Instance{IP: "127.0.0.1", Port: 8001})
+	_, _ = AddInstance("invoke-blocking", "invoke", &Instance{IP: "127.0.0.1", Port: 8001})
+
+	// 模块在调用方的 context 上执行
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/", nil)
+	ins, err := GetInstance(ctx, "invoke", "invoke")
+	assert.NoError(t, err)
+	_, err = ins.Request(ctx, "get", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "get", ctx.GetString("invoked"))
+
+	// 不感知 context 的模块在调用方超时后立即返回
+	ctx.Request.Header.Set(HEAD_TIMEOUT, "50")
+	cancel := WithBudget(ctx, ctx.GetHeader)
+	defer cancel()
+	ins, err = GetInstance(ctx, "invoke-blocking", "invoke")
+	assert.NoError(t, err)
+	start := time.Now()
+	_, err = ins.Request(ctx, "slow", nil, nil)
+	assert.Equal(t, ERR_BUDGET_EXHAUSTED, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestHedge(t *testing.T) {
+	res := newTestResource("hedge", 8001, 8002)
+	res.Strategy = WITH_ORDER
+	res.Hedge.Enable, res.Hedge.Percentile = false, 0
+	res.latency = latencyWindow{}
+
+	slow := func(ctx *gin.Context, ins *Instance) (interface{}, error) {
+		if ins.Port == 8001 {
+			select {
+			case <-time.After(time.Second):
+			case <-Context(ctx).Done():
+				return nil, Err(ctx)
+			}
+		}
+		return ins.Port, nil
+	}
+
+	// 未开启对冲时直接调用
+	reply, hedged, err := Hedge(nil, res.list[1], 0, slow)
+	assert.NoError(t, err)
+	assert.Equal(t, 8002, reply)
+	assert.Equal(t, 0, hedged)
+
+	// 首个请求超时未返回，对冲请求先返回
+	start := time.Now()
+	reply, hedged, err = Hedge(nil, res.list[0], 20*time.Millisecond, slow)
+	assert.NoError(t, err)
+	assert.Equal(t, 8002, reply)
+	assert.Equal(t, 1, hedged)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	// 资源配置开启对冲
+	res.Hedge.Enable = true
+	res.Hedge.Delay = 20 * time.Millisecond
+	assert.Equal(t, 20*time.Millisecond, res.HedgeDelay())
+	reply, hedged, err = Hedge(nil, res.list[0], 0, slow)
+	assert.NoError(t, err)
+	assert.Equal(t, 8002, reply)
+	assert.Equal(t, 1, hedged)
+
+	// 按分位数计算延迟
+	res.Hedge.Percentile = 90
+	res.latency = latencyWindow{}
+	for i := 1; i <= 100; i++ {
+		res.latency.observe(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, 91*time.Millisecond, res.HedgeDelay())
+
+	// 首个请求先失败时等待对冲请求
+	fail := func(ctx *gin.Context, ins *Instance) (interface{}, error) {
+		if ins.Port == 8001 {
+			time.Sleep(30 * time.Millisecond)
+			return nil, ERR_NOT_FOUND_CLIENT
+		}
+		time.Sleep(50 * time.Millisecond)
+		return HedgeIndex(ctx), nil
+	}
+	reply, hedged, err = Hedge(nil, res.list[0], 10*time.Millisecond, fail)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reply)
+	assert.Equal(t, 1, hedged)
+
+	// 固定选取首个实例时仍对冲到其他实例，首个请求先返回后释放对冲请求的实例
+	res.Strategy = WITH_FIRST
+	first := func(ctx *gin.Context, ins *Instance) (interface{}, error) {
+		if ins.Port == 8001 {
+			time.Sleep(30 * time.Millisecond)
+			return ins.Port, nil
+		}
+		<-Context(ctx).Done()
+		return nil, Err(ctx)
+	}
+	reply, hedged, err = Hedge(nil, res.list[0], 10*time.Millisecond, first)
+	assert.NoError(t, err)
+	assert.Equal(t, 8001, reply)
+	assert.Equal(t, 1, hedged)
+	deadline := time.Now().Add(time.Second)
+	for res.list[1].Inflight() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int64(0), res.list[1].Inflight())
+}
+
+func TestHealthCheck(t *testing.T) {
+	var down sync.Map
+	AddModule(&Module{Type: "health", Check: func(res *Resource, ins *Instance, timeout time.Duration) error {
+		if _, ok := down.Load(ins.Port); ok {
+			return ERR_NOT_FOUND_CLIENT
+		}
+		return nil
+	}})
+
+	res := AddResource(&Resource{Type: "health", Name: "check", Strategy: WITH_ORDER})
+	res.HealthCheck.Failures = 2
+	for _, port := range []int{8001, 8002} {
+		_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: port})
+	}
+	res.HealthCheck.Enable = true
+
+	bad := res.list[0]
+	down.Store(8001, true)
+	res.checkHealth()
+	assert.True(t, bad.Healthy())
+	res.checkHealth()
+	assert.False(t, bad.Healthy())
+
+	for i := 0; i < 10; i++ {
+		ins, err := GetInstance(nil, res.Type, res.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, 8002, ins.Port)
+		ins.Release()
+	}
+	ins, err := GetInstanceByKey(nil, res.Type, res.Name, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 8002, ins.Port)
+
+	// 恢复
+	down.Delete(8001)
+	res.checkHealth()
+	assert.True(t, bad.Healthy())
+
+	// 定时检查
+	res.HealthCheck.Interval = 10 * time.Millisecond
+	res.HealthCheck.Failures = 1
+	res.startHealthCheck()
+	down.Store(8002, true)
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, res.list[1].Healthy())
+
+	// TCP检查
+	assert.Error(t, CheckTCP(&Instance{IP: "127.0.0.1", Port: 1}, 100*time.Millisecond))
+}
+
+func TestFailover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ral")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "failover.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(fmt.Sprintf(`
+type: failover
+name: service
+strategy: order
+healthCheck:
+  enable: true
+  interval: 1h
+failover:
+  idc: [%s, backup, missing]
+manual:
+  %s:
+    - ip: 127.0.0.1
+      port: 8001
+  backup:
+    - ip: 127.0.0.2
+      port: 8001
+`, env.IDC, env.IDC)), 0644))
+
+	res := &Resource{}
+	_, err = utils.Load(file, &res)
+	assert.NoError(t, err)
+	res = AddResource(res)
+	assert.NoError(t, initResource(res))
+	assert.Len(t, res.standby, 1)
+	assert.Equal(t, env.IDC, res.ServingIDC())
+
+	get := func() string {
+		ins, err := GetInstance(nil, "failover", "service")
+		assert.NoError(t, err)
+		ins.Release()
+		return ins.IP
+	}
+	assert.Equal(t, "127.0.0.1", get())
+
+	// 本机房不可用时切换到备用机房
+	res.setHealthy(res.list[0], false, ERR_NOT_FOUND_CLIENT)
+	assert.Equal(t, "127.0.0.2", get())
+	assert.Equal(t, "backup", ServingIDC("failover", "service"))
+
+	// 本机房恢复后切回
+	res.setHealthy(res.list[0], true, nil)
+	assert.Equal(t, "127.0.0.1", get())
+	assert.Equal(t, env.IDC, res.ServingIDC())
+
+	// 热加载同时更新备用机房的资源
+	conf := &Resource{}
+	_, err = utils.Load(file, &conf)
+	assert.NoError(t, err)
+	conf.Retry = 2
+	conf.Manual["backup"][0].Port = 8002
+	res.reload(conf)
+	standby := res.standby[0]
+	assert.Equal(t, 2, res.Snapshot().Retry)
+	assert.Equal(t, 2, standby.Snapshot().Retry)
+	if assert.Len(t, standby.list, 1) {
+		assert.Equal(t, 8002, standby.list[0].Port)
+	}
+	assert.Len(t, res.list, 1)
+}
+
+func TestColor(t *testing.T) {
+	res := AddResource(&Resource{Type: "color", Name: "lane", Strategy: WITH_ORDER})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8001})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8002, Color: "gray"})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8003})
+
+	ports := func(ctx *gin.Context, key string) map[int]bool {
+		m := map[int]bool{}
+		for i := 0; i < 6; i++ {
+			ins, err := GetInstanceByKey(ctx, res.Type, res.Name, key)
+			assert.NoError(t, err)
+			ins.Release()
+			m[ins.Port] = true
+		}
+		return m
+	}
+
+	// 未染色的请求只选取未染色实例
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Equal(t, map[int]bool{8001: true, 8003: true}, ports(ctx, ""))
+
+	// 染色请求优先选取同色实例
+	SetColor(ctx, "gray")
+	assert.Equal(t, "gray", Color(ctx))
+	assert.Equal(t, map[int]bool{8002: true}, ports(ctx, ""))
+	assert.Equal(t, map[int]bool{8002: true}, ports(ctx, "key"))
+
+	head := map[string]string{}
+	injectColor(ctx, head)
+	assert.Equal(t, "gray", head[HEAD_COLOR])
+
+	// 没有同色实例时回退到未染色实例
+	SetColor(ctx, "blue")
+	assert.Equal(t, map[int]bool{8001: true, 8003: true}, ports(ctx, ""))
+
+	res.list[1].SetColor("blue")
+	assert.Equal(t, map[int]bool{8002: true}, ports(ctx, ""))
+}
+
+// 重试及对冲选取的实例与调用方泳道相同
+func TestColorRetryHedge(t *testing.T) {
+	res := AddResource(&Resource{Type: "color", Name: "retry", Strategy: WITH_ORDER, Retry: 3})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8001})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8002, Color: "gray"})
+	_, _ = AddInstance(res.Type, res.Name, &Instance{IP: "127.0.0.1", Port: 8003})
+
+	retried := func(ctx *gin.Context, ins *Instance) map[int]bool {
+		m := map[int]bool{}
+		ins.RetryContext(ctx, func(res *Resource, ins *Instance) bool {
+			m[ins.Port] = true
+			return true
+		})
+		return m
+	}
+	plain, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gray, _ := gin.CreateTestContext(httptest.NewRecorder())
+	SetColor(gray, "gray")
+	assert.Equal(t, map[int]bool{8001: true, 8003: true}, retried(plain, res.list[0]))
+	assert.Equal(t, map[int]bool{8002: true}, retried(gray, res.list[1]))
+
+	slow := func(ctx *gin.Context, ins *Instance) (interface{}, error) {
+		if ins.Port != 8003 {
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-Context(ctx).Done():
+				return nil, Err(ctx)
+			}
+		}
+		return ins.Port, nil
+	}
+	// 未染色请求只对冲到未染色实例
+	for i := 0; i < 5; i++ {
+		reply, hedged, err := Hedge(plain, res.list[0], 10*time.Millisecond, slow)
+		assert.NoError(t, err)
+		assert.Equal(t, 8003, reply)
+		assert.Equal(t, 1, hedged)
+	}
+	// 染色请求没有其他同色实例时不对冲
+	reply, hedged, err := Hedge(gray, res.list[1], 10*time.Millisecond, slow)
+	assert.NoError(t, err)
+	assert.Equal(t, 8002, reply)
+	assert.Equal(t, 0, hedged)
+}
+
+func TestLimit(t *testing.T) {
+	res := newTestResource("limit", 8001)
+	res.limiter = limiter{}
+
+	// 令牌桶容量用尽后快速失败
+	res.Limit.QPS, res.Limit.Burst = 10, 2
+	for i := 0; i < 2; i++ {
+		release, err := res.acquire(nil)
+		assert.NoError(t, err)
+		release(time.Millisecond, nil)
+	}
+	_, err := res.acquire(nil)
+	assert.Equal(t, ERR_RATE_LIMITED, err)
+	assert.Equal(t, int64(1), res.Rejected())
+
+	// 配置等待时间时等待令牌
+	res.Limit.Wait = 500 * time.Millisecond
+	start := time.Now()
+	release, err := res.acquire(nil)
+	assert.NoError(t, err)
+	release(time.Millisecond, nil)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	// 并发数限制
+	res.Limit.QPS, res.Limit.Wait, res.Limit.Concurrency = 0, 0, 1
+	release, err = res.acquire(nil)
+	assert.NoError(t, err)
+	_, err = res.acquire(nil)
+	assert.Equal(t, ERR_CONCURRENCY_LIMITED, err)
+	assert.Equal(t, int64(2), res.Rejected())
+
+	res.Limit.Wait = 500 * time.Millisecond
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		release(time.Millisecond, nil)
+	}()
+	release, err = res.acquire(nil)
+	assert.NoError(t, err)
+	release(time.Millisecond, nil)
+
+	// 自适应并发：慢响应减小上限，同一批慢响应只减小一次
+	res.Limit.Concurrency, res.Limit.Adaptive, res.Limit.Latency = 50, true, 10*time.Millisecond
+	assert.Equal(t, defaultLimitInitial, res.ConcurrencyLimit())
+	for i := 0; i < 3; i++ {
+		release, err = res.acquire(nil)
+		assert.NoError(t, err)
+		release(20*time.Millisecond, nil)
+	}
+	assert.Equal(t, 18, res.ConcurrencyLimit())
+}
+
+func TestMock(t *testing.T) {
+	AddModule(&Module{Type: "mock", Method: func(ctx *gin.Context, res *Resource, ins *Instance, method string,
+		data map[string]interface{}, head map[string]string) (interface{}, error) {
+		return []byte(fmt.Sprintf("%s %s %v", method, head[mockPathHead], data["id"])), nil
+	}})
+	AddResource(&Resource{Type: "mock", Name: "real"})
+	_, _ = AddInstance("mock", "real", &Instance{IP: "127.0.0.1", Port: 8001})
+
+	m := NewMock()
+	m.On("mock", "user", "get", "/user").WithData(map[string]interface{}{"id": 1}).Return([]byte("tom"), nil).Times(1)
+	m.Start()
+
+	// 资源不存在时使用模拟实例
+	ins, err := GetInstance(nil, "mock", "user")
+	assert.NoError(t, err)
+	reply, err := ins.Request(nil, "get", map[string]interface{}{"id": 1}, map[string]string{mockPathHead: "/user"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("tom"), reply)
+	assert.Empty(t, m.Pending())
+
+	// 没有匹配规则时不请求真实服务
+	ins, err = GetInstance(nil, "mock", "real")
+	assert.NoError(t, err)
+	_, err = ins.Request(nil, "get", map[string]interface{}{"id": 2}, map[string]string{mockPathHead: "/user"})
+	assert.Equal(t, ERR_MOCK_NOT_FOUND, err)
+	assert.Len(t, m.Unmatched(), 1)
+	assert.Equal(t, 2, m.Called("mock", "", "get", "/user"))
+	assert.NoError(t, m.Stop())
+
+	// 录制真实响应
+	dir, err := ioutil.TempDir("", "ral")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "fixture.json")
+
+	m = NewMock().Record(file)
+	m.Start()
+	for _, id := range []int{2, 3} {
+		reply, err = ins.Request(nil, "get", map[string]interface{}{"id": id}, map[string]string{mockPathHead: "/user"})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("get /user %d", id)), reply)
+	}
+	assert.NoError(t, m.Stop())
+
+	// 回放录制文件
+	m = NewMock()
+	assert.NoError(t, m.Replay(file))
+	m.Start()
+	defer m.Stop()
+	reply, err = ins.Request(nil, "get", map[string]interface{}{"id": 3}, map[string]string{mockPathHead: "/user"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("get /user 3"), reply)
+	assert.Len(t, m.Pending(), 1)
+	assert.Len(t, m.Calls(), 1)
+}
+
+func TestMockInstance(t *testing.T) {
+	appended := 0
+	AddModule(&Module{
+		Type: "mock-client",
+		Append: func(self *Resource, res *Resource, ins *Instance) (*Instance, error) {
+			appended++
+			return ins, nil
+		},
+		Mock: func(res *Resource, ins *Instance) interface{} {
+			return res.Name
+		},
+	})
+
+	m := NewMock()
+	m.Start()
+	defer m.Stop()
+
+	// 模拟实例的客户端由 Mock 创建，不调用 Append
+	ins, err := GetInstance(nil, "mock-client", "user")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", ins.Client)
+	assert.Equal(t, 0, appended)
+
+	// 模块未提供 Mock 时不包含客户端
+	ins, err = GetInstance(nil, "mock", "order")
+	assert.NoError(t, err)
+	assert.Nil(t, ins.Client)
+}
+
+func TestResources(t *testing.T) {
+	newTestResource("status", 8001)
+	AddResource(&Resource{Type: "test", Name: "status_dep", Depend: []string{"test:status"}})
+
+	var status *ResourceStatus
+	list := Resources()
+	for i := range list {
+		if list[i].Type == "test" && list[i].Name == "status" {
+			status = &list[i]
+		}
+	}
+	if assert.NotNil(t, status) && assert.Len(t, status.Instances, 1) {
+		assert.Equal(t, 8001, status.Instances[0].Port)
+		assert.True(t, status.Instances[0].Healthy)
+		assert.Equal(t, BREAKER_CLOSED, status.Instances[0].Breaker)
+	}
+	assert.Contains(t, Dependons()["test:status"], "test:status_dep")
+}
@@ -0,0 +1,307 @@
+package ral
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GitHub121380/golib/env"
+	"github.com/GitHub121380/golib/utils"
+	"github.com/GitHub121380/golib/zlog"
+	"go.uber.org/zap"
+)
+
+// 支持热加载的配置项，rebuild 表示变更后需要重建实例，snapshot 表示调用时不加锁读取，
+// 热加载时写入新的配置快照，通过 Snapshot 读取
+var reloadFields = []struct {
+	name     string
+	rebuild  bool
+	snapshot bool
+}{
+	{"ConnTimeOut", true, false},
+	{"ReadTimeOut", true, false},
+	{"WriteTimeOut", true, false},
+	{"Retry", false, true},
+	{"Encode", true, false},
+	{"Decode", true, false},
+	{"LongConnect", true, false},
+	{"Redis", true, false},
+	{"Mysql", true, false},
+	{"HBase", true, false},
+	{"Strategy", false, false},
+	{"HashReplicas", false, false},
+	{"Breaker", false, true},
+	{"Hedge", false, true},
+	{"Mirror", false, true},
+	{"Limit", false, true},
+	{"HealthCheck", false, true},
+	{"Manual", false, false},
+}
+
+// 配置目录中加载的资源
+var confResources = map[string]bool{}
+var confLock sync.Mutex
+
+// 定时检查配置目录，文件变更时重新加载
+func Watch(dir string, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	go func() {
+		sign := dirSign(dir)
+		for {
+			time.Sleep(interval)
+			if s := dirSign(dir); s != sign {
+				sign = s
+				zlog.InfoLogger(nil, "ral config changed, reload "+dir, zap.String("prot", "ral"))
+				reload(dir)
+			}
+		}
+	}()
+}
+
+func reload(dir string) {
+	defer func() {
+		if err := recover(); err != nil {
+			zlog.ErrorLogger(nil, fmt.Sprintf("ral reload panic, error: %+v", err), zap.String("prot", "ral"))
+		}
+	}()
+	_ = Reload(dir)
+}
+
+func dirSign(dir string) string {
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	sign := make([]string, 0, len(list))
+	for _, file := range list {
+		sign = append(sign, fmt.Sprintf("%s|%d|%d", file.Name(), file.Size(), file.ModTime().UnixNano()))
+	}
+	return strings.Join(sign, ",")
+}
+
+// 重新加载配置目录，任一文件解析失败时保留原有配置
+func Reload(dir string) error {
+	fields := []zap.Field{
+		zap.String("prot", "ral"),
+	}
+
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		zlog.ErrorLogger(nil, "ral reload config: "+err.Error(), fields...)
+		return err
+	}
+
+	loaded := map[string]*Resource{}
+	for _, file := range list {
+		resource := &Resource{}
+		if _, e := utils.Load(path.Join(dir, file.Name()), &resource); e != nil {
+			zlog.ErrorLogger(nil, "ral reload config: "+e.Error()+", keep previous config", fields...)
+			return e
+		}
+		loaded[resource.Type+":"+resource.Name] = resource
+	}
+
+	confLock.Lock()
+	defer confLock.Unlock()
+
+	for key, conf := range loaded {
+		res, ok := GetResource(conf.Type, conf.Name)
+		if !ok {
+			zlog.InfoLogger(nil, "ral reload add resource "+key, fields...)
+			res = AddResource(conf)
+			confResources[key] = true
+			if err := initResource(res); err != nil {
+				zlog.ErrorLogger(nil, "ral reload init resource "+key+" error: "+err.Error(), fields...)
+			}
+			continue
+		}
+		res.reload(conf)
+	}
+
+	for key := range confResources {
+		if loaded[key] == nil {
+			zlog.WarnLogger(nil, "ral reload resource "+key+" removed from config, keep instances", fields...)
+		}
+	}
+	return nil
+}
+
+// 应用新的资源配置
+func (res *Resource) reload(conf *Resource) {
+	fields := []zap.Field{
+		zap.String("prot", "ral"),
+	}
+
+	// zns 资源的 Config 会注册到下载列表，不能用于临时配置
+	if res.mod != nil && res.mod.Config != nil && res.Type != TYPE_ZNS {
+		res.mod.Config(conf)
+	}
+
+	res.lock.Lock()
+	if (len(res.Manual) > 0) != (len(conf.Manual) > 0) {
+		// 手动配置与zns之间切换需要重启
+		zlog.WarnLogger(nil, fmt.Sprintf("ral reload %s:%s switch between manual and zns, need restart", res.Type, res.Name), fields...)
+		conf.Manual = res.Manual
+	}
+	changed, rebuild := res.update(conf)
+	if len(changed) > 0 {
+		res.ring = newHashRing(res.list, res.HashReplicas)
+	}
+	zns := reflect.DeepEqual(res.ZNS, conf.ZNS)
+	res.lock.Unlock()
+
+	// 备用机房的资源使用相同的配置，服务地址保持各自机房的配置
+	for _, s := range res.standby {
+		standby := conf.clone(s.Type, s.Name)
+		standby.ZNS = s.ZNS
+		if len(s.Manual) > 0 {
+			standby.Manual = conf.Manual
+		}
+		s.reload(standby)
+	}
+
+	if !zns {
+		zlog.WarnLogger(nil, fmt.Sprintf("ral reload %s:%s zns changed, need restart", res.Type, res.Name), fields...)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	zlog.InfoLogger(nil, fmt.Sprintf("ral reload %s:%s changed: %s", res.Type, res.Name, strings.Join(changed, ",")), fields...)
+	res.startHealthCheck()
+
+	if len(res.Manual) > 0 {
+		res.syncManual(rebuild)
+	} else if rebuild {
+		res.rebuildSubs()
+	}
+}
+
+// 复制变更的配置项，返回变更的字段及是否需要重建实例。需持有 res.lock，
+// 不加锁读取的配置项写入新的快照后整体替换，不修改 res 上的字段
+func (res *Resource) update(conf *Resource) (changed []string, rebuild bool) {
+	cur := res.Snapshot()
+	next := &Resource{Type: res.Type, Name: res.Name}
+	dst, src := reflect.ValueOf(res).Elem(), reflect.ValueOf(conf).Elem()
+	old, snap := reflect.ValueOf(cur).Elem(), reflect.ValueOf(next).Elem()
+	for _, f := range reloadFields {
+		d, s := dst.FieldByName(f.name), src.FieldByName(f.name)
+		if f.snapshot {
+			d = snap.FieldByName(f.name)
+			d.Set(old.FieldByName(f.name))
+		}
+		if reflect.DeepEqual(d.Interface(), s.Interface()) {
+			continue
+		}
+		d.Set(s)
+		changed = append(changed, f.name)
+		rebuild = rebuild || f.rebuild
+	}
+	res.conf.Store(next)
+	return changed, rebuild
+}
+
+// 返回当前生效的配置快照，只读。Retry、Breaker、Hedge、Mirror、Limit、HealthCheck
+// 在调用时不加锁读取，需通过快照读取热加载后的配置
+func (res *Resource) Snapshot() *Resource {
+	if conf, ok := res.conf.Load().(*Resource); ok {
+		return conf
+	}
+	return res
+}
+
+// 按Manual配置同步实例，先添加再删除，避免实例列表为空
+func (res *Resource) syncManual(rebuild bool) {
+	res.lock.RLock()
+	have := make(map[string]*Instance, len(res.list))
+	for _, ins := range res.list {
+		have[fmt.Sprintf("%s:%d", ins.IP, ins.Port)] = ins
+	}
+	res.lock.RUnlock()
+
+	// 备用资源使用所在机房的配置
+	idc := res.idc
+	if idc == "" {
+		idc = env.IDC
+	}
+	for _, v := range res.Manual[idc] {
+		node := fmt.Sprintf("%s:%d", v.IP, v.Port)
+		if ins, ok := have[node]; ok && !rebuild {
+			if ins.Weight != v.Weight {
+				ins.SetWeight(v.Weight)
+			}
+			if ins.Color != v.Color {
+				ins.SetColor(v.Color)
+			}
+			delete(have, node)
+			continue
+		}
+		if _, err := appendManual(res, v.IP, v.Port, v.Weight, v.Color); err != nil {
+			zlog.ErrorLogger(nil, fmt.Sprintf("ral reload add instance %s:%s %s error: %s", res.Type, res.Name, node, err.Error()), zap.String("prot", "ral"))
+		}
+	}
+
+	for _, ins := range have {
+		if mod := res.mod; mod != nil && mod.Remove != nil {
+			mod.Remove(ins, res, ins)
+		}
+		_ = DelInstance(res.Type, res.Name, ins)
+	}
+}
+
+// 依赖zns的资源，按新配置重新创建下游实例
+func (res *Resource) rebuildSubs() {
+	mod := res.mod
+	if mod == nil || mod.Append == nil {
+		return
+	}
+
+	for _, dep := range res.Depend {
+		kv := strings.SplitN(dep, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		parent, ok := GetResource(kv[0], kv[1])
+		if !ok {
+			continue
+		}
+
+		parent.lock.Lock()
+		for _, p := range parent.list {
+			for i, sub := range p.subs {
+				if sub == nil || sub.res != res {
+					continue
+				}
+
+				n, err := mod.Append(res, parent, p)
+				if err != nil || n == nil {
+					zlog.ErrorLogger(nil, fmt.Sprintf("ral reload rebuild instance %s:%s %s:%d error: %v", res.Type, res.Name, p.IP, p.Port, err), zap.String("prot", "ral"))
+					continue
+				}
+				if n.Weight == 0 {
+					n.Weight = p.Weight
+				}
+				if n.Color == "" {
+					n.Color = p.Color
+				}
+				if n, err = AddInstance(res.Type, res.Name, n); err != nil {
+					continue
+				}
+
+				p.subs[i] = n
+				if mod.Remove != nil {
+					mod.Remove(sub, parent, sub)
+				}
+				_ = DelInstance(res.Type, res.Name, sub)
+			}
+		}
+		parent.lock.Unlock()
+	}
+}
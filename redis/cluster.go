@@ -0,0 +1,387 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+// 部署模式，通过 ral.Resource.Redis.Mode 配置
+const (
+	ModeCluster  = "cluster"
+	ModeSentinel = "sentinel"
+)
+
+const ( // 集群默认配置
+	clusterSlots = 16384
+	// 单次调用最多跟随的重定向次数
+	clusterMaxRedirects = 3
+	// 两次刷新槽分布的最小间隔
+	clusterRefreshMinInterval = time.Second
+	// 超过该时间未刷新时，在下次调用时异步刷新
+	clusterRefreshInterval = time.Minute
+	clusterTryAgainDelay   = 50 * time.Millisecond
+)
+
+// 集群拓扑，通过 CLUSTER SLOTS 获取槽分布，按 key 所在的槽将命令发送到对应的主节点。
+// 收到 MOVED 重定向或节点连接异常时重新获取槽分布
+type cluster struct {
+	res  *ral.Resource
+	seed string
+
+	mu sync.RWMutex
+	// 槽对应的主节点地址，未获取到槽分布时为 nil
+	slots     []string
+	nodes     map[string]*redis.Pool
+	refreshAt time.Time
+	closed    bool
+
+	refreshing int32
+}
+
+func newCluster(res *ral.Resource, seed string) *cluster {
+	c := &cluster{
+		res:   res,
+		seed:  seed,
+		nodes: map[string]*redis.Pool{},
+	}
+	if err := c.refresh(); err != nil {
+		zlog.WarnLogger(nil, fmt.Sprintf("redis cluster %s refresh slots from %s error: %s", res.Name, seed, err.Error()), zap.String("prot", "redis"))
+	}
+	return c
+}
+
+// 返回命令 key 所在槽的节点连接池，没有 key 或槽分布未知时使用种子节点
+func (c *cluster) route(cmd string, args []interface{}) (*redis.Pool, string) {
+	c.mu.RLock()
+	stale := time.Since(c.refreshAt) > clusterRefreshInterval
+	addr := c.seed
+	if s := commandSlot(cmd, args); s >= 0 && c.slots != nil && c.slots[s] != "" {
+		addr = c.slots[s]
+	}
+	c.mu.RUnlock()
+
+	if stale {
+		c.refreshAsync()
+	}
+	return c.pool(addr), addr
+}
+
+// 返回节点的连接池，不存在时创建，已关闭时返回 nil
+func (c *cluster) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	p := c.nodes[addr]
+	c.mu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	if p = c.nodes[addr]; p == nil {
+		// 集群模式只支持 0 号数据库
+		p = newPool(c.res, addr, c.res.Redis.Password, 0)
+		c.nodes[addr] = p
+		go preloadScripts(p, c.res.Name, addr)
+	}
+	return p
+}
+
+// 执行命令，跟随 MOVED、ASK 重定向
+func (c *cluster) do(ctx *gin.Context, timeout time.Duration, cmd string, args []interface{}) (interface{}, error) {
+	p, addr := c.route(cmd, args)
+	asking := false
+	for i := 0; ; i++ {
+		if p == nil {
+			return nil, GetRedisConnErr
+		}
+		conn, err := poolConn(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+
+		var reply interface{}
+		// ASK 重定向需先发送 ASKING，仅对下一条命令生效
+		if asking {
+			_, err = doWithBudget(ctx, conn, timeout, "ASKING")
+		}
+		if err == nil {
+			reply, err = do(ctx, conn, timeout, cmd, args...)
+		}
+		conn.Close()
+
+		kind, slot, target := clusterRedirect(err)
+		if kind == "" || i >= clusterMaxRedirects {
+			if kind != "" || clusterConnErr(err) {
+				c.refreshAsync()
+			}
+			return reply, err
+		}
+
+		switch kind {
+		case "MOVED":
+			c.move(slot, target)
+			c.refreshAsync()
+			asking = false
+		case "ASK":
+			asking = true
+		case "TRYAGAIN":
+			// 槽迁移中的多 key 命令，稍后在原节点重试，调用方超时或取消时立即返回
+			timer := time.NewTimer(clusterTryAgainDelay)
+			select {
+			case <-timer.C:
+			case <-ral.Context(ctx).Done():
+				timer.Stop()
+				return nil, ral.Err(ctx)
+			}
+			target = addr
+		}
+		addr = target
+		p = c.pool(addr)
+	}
+}
+
+// 收到 MOVED 后先更新单个槽，完整的槽分布异步刷新
+func (c *cluster) move(slot int, addr string) {
+	if slot < 0 || slot >= clusterSlots {
+		return
+	}
+	c.mu.Lock()
+	if c.slots == nil {
+		c.slots = make([]string, clusterSlots)
+	}
+	c.slots[slot] = addr
+	c.mu.Unlock()
+}
+
+// 异步刷新槽分布，距离上次刷新不足最小间隔时忽略
+func (c *cluster) refreshAsync() {
+	c.mu.RLock()
+	skip := c.closed || time.Since(c.refreshAt) < clusterRefreshMinInterval
+	c.mu.RUnlock()
+	if skip || !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		if err := c.refresh(); err != nil {
+			zlog.WarnLogger(nil, fmt.Sprintf("redis cluster %s refresh slots error: %s", c.res.Name, err.Error()), zap.String("prot", "redis"))
+		}
+	}()
+}
+
+// 依次从种子节点及已知的主节点获取槽分布
+func (c *cluster) refresh() error {
+	c.mu.Lock()
+	c.refreshAt = time.Now()
+	addrs := []string{c.seed}
+	known := map[string]bool{c.seed: true}
+	for _, addr := range c.slots {
+		if addr != "" && !known[addr] {
+			known[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	c.mu.Unlock()
+
+	var err error
+	for _, addr := range addrs {
+		var slots []string
+		if slots, err = c.load(addr); err == nil {
+			c.update(addr, slots)
+			return nil
+		}
+	}
+	return err
+}
+
+// 从节点获取槽分布
+func (c *cluster) load(addr string) ([]string, error) {
+	p := c.pool(addr)
+	if p == nil {
+		return nil, GetRedisConnErr
+	}
+	conn := p.Get()
+	defer conn.Close()
+
+	// 每项为 [start, end, [ip, port, id], 从节点...]
+	reply, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	slots := make([]string, clusterSlots)
+	for _, item := range reply {
+		v, err := redis.Values(item, nil)
+		if err != nil || len(v) < 3 {
+			return nil, ClusterSlotsErr
+		}
+		start, err1 := redis.Int(v[0], nil)
+		end, err2 := redis.Int(v[1], nil)
+		node, err3 := redis.Values(v[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(node) < 2 || start < 0 {
+			return nil, ClusterSlotsErr
+		}
+		ip, _ := redis.String(node[0], nil)
+		port, err := redis.Int(node[1], nil)
+		if err != nil {
+			return nil, ClusterSlotsErr
+		}
+		// 节点未配置地址时使用当前连接的地址
+		if ip == "" {
+			ip = host
+		}
+		master := net.JoinHostPort(ip, strconv.Itoa(port))
+		for i := start; i <= end && i < clusterSlots; i++ {
+			slots[i] = master
+		}
+	}
+	return slots, nil
+}
+
+// 更新槽分布，关闭不再使用的节点连接池
+func (c *cluster) update(from string, slots []string) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	changed := len(c.slots) != len(slots)
+	masters := map[string]bool{}
+	for i, addr := range slots {
+		if addr != "" {
+			masters[addr] = true
+		}
+		if !changed && c.slots[i] != addr {
+			changed = true
+		}
+	}
+	var unused []*redis.Pool
+	for addr, p := range c.nodes {
+		if addr != c.seed && !masters[addr] {
+			unused = append(unused, p)
+			delete(c.nodes, addr)
+		}
+	}
+	c.slots = slots
+	c.mu.Unlock()
+
+	for _, p := range unused {
+		closePool(p)
+	}
+	if changed {
+		zlog.InfoLogger(nil, fmt.Sprintf("redis cluster %s slots updated from %s, %d masters", c.res.Name, from, len(masters)), zap.String("prot", "redis"))
+	}
+}
+
+func (c *cluster) pools() []*redis.Pool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	list := make([]*redis.Pool, 0, len(c.nodes))
+	for _, p := range c.nodes {
+		list = append(list, p)
+	}
+	return list
+}
+
+func (c *cluster) close() {
+	c.mu.Lock()
+	c.closed = true
+	nodes := c.nodes
+	c.nodes = map[string]*redis.Pool{}
+	c.mu.Unlock()
+
+	for _, p := range nodes {
+		closePool(p)
+	}
+}
+
+// 解析集群重定向错误，返回类型、槽及目标节点
+func clusterRedirect(err error) (kind string, slot int, addr string) {
+	e, ok := err.(redis.Error)
+	if !ok {
+		return "", 0, ""
+	}
+	f := strings.Fields(string(e))
+	switch {
+	case len(f) == 3 && (f[0] == "MOVED" || f[0] == "ASK"):
+		slot, err := strconv.Atoi(f[1])
+		if err != nil {
+			return "", 0, ""
+		}
+		return f[0], slot, f[2]
+	case len(f) > 0 && f[0] == "TRYAGAIN":
+		return f[0], -1, ""
+	}
+	return "", 0, ""
+}
+
+// 节点连接异常或集群不可用，需要刷新槽分布
+func clusterConnErr(err error) bool {
+	if err == nil || err == ral.ERR_BUDGET_EXHAUSTED || err == ral.ERR_CONTEXT_CANCELED {
+		return false
+	}
+	if e, ok := err.(redis.Error); ok {
+		return strings.HasPrefix(string(e), "CLUSTERDOWN")
+	}
+	return true
+}
+
+// 返回命令第一个 key 所在的槽，没有 key 时返回 -1
+func commandSlot(cmd string, args []interface{}) int {
+	index := keyIndexes(cmd, args)
+	if len(index) == 0 {
+		return -1
+	}
+
+	var key string
+	switch k := args[index[0]].(type) {
+	case string:
+		key = k
+	case []byte:
+		key = string(k)
+	default:
+		key = fmt.Sprint(k)
+	}
+	return keySlot(key)
+}
+
+// 返回 key 所在的槽，key 中包含 {tag} 时只按 tag 计算
+func keySlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// CRC16-CCITT(XMODEM)
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySlot(t *testing.T) {
+	assert.Equal(t, uint16(0x31C3), crc16("123456789"))
+	assert.Equal(t, 12182, keySlot("foo"))
+	// 相同 tag 的 key 位于同一个槽
+	assert.Equal(t, keySlot("{user1000}.following"), keySlot("{user1000}.followers"))
+	// 空 tag 按完整 key 计算
+	assert.Equal(t, int(crc16("foo{}{bar}")%clusterSlots), keySlot("foo{}{bar}"))
+
+	assert.Equal(t, keySlot("foo"), commandSlot("GET", []interface{}{"foo"}))
+	assert.Equal(t, -1, commandSlot("PING", nil))
+}
+
+func TestClusterRedirect(t *testing.T) {
+	kind, slot, addr := clusterRedirect(redis.Error("MOVED 3999 127.0.0.1:6381"))
+	assert.Equal(t, "MOVED", kind)
+	assert.Equal(t, 3999, slot)
+	assert.Equal(t, "127.0.0.1:6381", addr)
+
+	kind, _, addr = clusterRedirect(redis.Error("ASK 3999 127.0.0.1:6381"))
+	assert.Equal(t, "ASK", kind)
+	assert.Equal(t, "127.0.0.1:6381", addr)
+
+	kind, _, _ = clusterRedirect(redis.Error("ERR unknown command"))
+	assert.Equal(t, "", kind)
+
+	assert.True(t, clusterConnErr(redis.Error("CLUSTERDOWN The cluster is down")))
+	assert.False(t, clusterConnErr(redis.Error("ERR unknown command")))
+}
+
+// 模拟两个主节点的集群，A 负责 0-8191，B 负责 8192-16383，migrated 后全部由 A 负责
+type fakeCluster struct {
+	a, b *fakeServer
+
+	mu       sync.Mutex
+	migrated bool
+	tryAgain int
+}
+
+func newFakeCluster(t *testing.T) *fakeCluster {
+	c := &fakeCluster{}
+	c.a = newFakeServer(t, func(args []string) string { return c.handle("a", args) })
+	c.b = newFakeServer(t, func(args []string) string { return c.handle("b", args) })
+	return c
+}
+
+func (c *fakeCluster) slots() string {
+	node := func(s *fakeServer) string {
+		host, port, _ := net.SplitHostPort(s.addr)
+		return arrayReply(bulkReply(host), ":"+port+"\r\n", bulkReply("id"))
+	}
+	if c.migrated {
+		return arrayReply(arrayReply(":0\r\n", ":16383\r\n", node(c.a)))
+	}
+	return arrayReply(
+		arrayReply(":0\r\n", ":8191\r\n", node(c.a)),
+		arrayReply(":8192\r\n", ":16383\r\n", node(c.b)),
+	)
+}
+
+func (c *fakeCluster) handle(name string, args []string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "CLUSTER":
+		return c.slots()
+	case "GET":
+		if name == "b" && c.migrated {
+			return fmt.Sprintf("-MOVED %d %s\r\n", keySlot(args[1]), c.a.addr)
+		}
+		if name == "b" && strings.HasPrefix(args[1], "ask") {
+			return fmt.Sprintf("-ASK %d %s\r\n", keySlot(args[1]), c.a.addr)
+		}
+		return bulkReply(name)
+	case "MGET":
+		if c.tryAgain != 0 {
+			c.tryAgain--
+			return "-TRYAGAIN Multiple keys request during rehashing of slot\r\n"
+		}
+		return arrayReply(bulkReply(name))
+	}
+	return "+OK\r\n"
+}
+
+func (c *fakeCluster) Close() {
+	c.a.Close()
+	c.b.Close()
+}
+
+func TestCluster(t *testing.T) {
+	c := newFakeCluster(t)
+	defer c.Close()
+	addService(t, "cluster", c.a.addr, func(res *ral.Resource) {
+		res.Redis.Mode = ModeCluster
+	})
+	objRedis, err := GetInstance(nil, "cluster")
+	assert.NoError(t, err)
+	defer objRedis.Release()
+	get := func(key string) string {
+		v, err := redis.String(objRedis.Do("GET", key))
+		assert.NoError(t, err)
+		return v
+	}
+
+	// 按 CLUSTER SLOTS 的槽分布路由
+	assert.True(t, keySlot("bar") < 8192 && keySlot("foo") >= 8192)
+	assert.Equal(t, "a", get("bar"))
+	assert.Equal(t, "b", get("foo"))
+
+	// ASK 重定向先发送 ASKING，不更新槽分布
+	assert.Equal(t, "a", get("ask{foo}"))
+	cmds := c.a.commands()
+	assert.Equal(t, []string{"ASKING", "GET ask{foo}"}, cmds[len(cmds)-2:])
+	assert.Equal(t, "a", get("ask{foo}"))
+	assert.Equal(t, "GET ask{foo}", c.b.commands()[len(c.b.commands())-1])
+
+	// MOVED 重定向后更新槽，之后直接发送到新节点
+	c.mu.Lock()
+	c.migrated = true
+	c.mu.Unlock()
+	assert.Equal(t, "a", get("foo"))
+	n := len(c.b.commands())
+	assert.Equal(t, "a", get("foo"))
+	assert.Equal(t, n, len(c.b.commands()))
+}
+
+func TestClusterTryAgain(t *testing.T) {
+	c := newFakeCluster(t)
+	defer c.Close()
+	addService(t, "cluster-tryagain", c.a.addr, func(res *ral.Resource) {
+		res.Redis.Mode = ModeCluster
+	})
+
+	// 稍后在原节点重试
+	c.mu.Lock()
+	c.tryAgain = 1
+	c.mu.Unlock()
+	objRedis, err := GetInstance(nil, "cluster-tryagain")
+	assert.NoError(t, err)
+	defer objRedis.Release()
+	v, err := redis.Strings(objRedis.Do("MGET", "{bar}1", "{bar}2"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, v)
+
+	// 等待重试时调用方超时立即返回
+	c.mu.Lock()
+	c.tryAgain = -1
+	c.mu.Unlock()
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ctx.Request = httptest.NewRequest("GET", "/", nil).WithContext(timeout)
+	objRedis, err = GetInstance(ctx, "cluster-tryagain")
+	assert.NoError(t, err)
+	defer objRedis.Release()
+	start := time.Now()
+	_, err = objRedis.Do("MGET", "{bar}1", "{bar}2")
+	assert.Equal(t, ral.ERR_BUDGET_EXHAUSTED, err)
+	assert.True(t, time.Since(start) < clusterTryAgainDelay)
+}
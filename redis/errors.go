@@ -8,4 +8,9 @@ var (
 	GetRedisConnErr       = errors.New("get redis conn fail")
 	NotExistServerNameErr = errors.New("redis server name not exist")
 	RemoveNodeErr         = errors.New("remove redis node err")
+	LockNotHeldErr        = errors.New("redis lock not held")
+	LockTimeoutErr        = errors.New("redis lock acquire timeout")
+	StreamReplyErr        = errors.New("redis stream reply invalid")
+	ClusterSlotsErr       = errors.New("redis cluster slots reply invalid")
+	SentinelMasterErr     = errors.New("redis sentinel master not found")
 )
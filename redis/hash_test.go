@@ -9,7 +9,7 @@ import (
 )
 
 func TestRedis_HSet_HGet_HLen(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestHSetHGet"
 	fieldMap := map[string]string{
 		"TestHSetHGet_key1": "TestHSetHGet_Value1",
@@ -51,7 +51,7 @@ func TestRedis_HSet_HGet_HLen(t *testing.T) {
 }
 
 func TestRedis_HMSetHMGet(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_HMSetHMGet"
 	fieldMap := map[string]interface{}{
 		"TestRedis_HMSetHMGet_field1": "TestRedis_HMSetHMGet_Value1",
@@ -85,7 +85,7 @@ func TestRedis_HMSetHMGet(t *testing.T) {
 }
 
 func TestRedis_HDel(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_HDel"
 	fieldMap := map[string]interface{}{
 		"TestRedis_HDel_field1": "TestRedis_HDel_Value1",
@@ -118,7 +118,7 @@ func TestRedis_HDel(t *testing.T) {
 }
 
 func TestRedis_HKeys_HVals(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_HKeys"
 	fieldMap := map[string]interface{}{
 		"TestRedis_HKeys_field1": "TestRedis_HDel_Value1",
@@ -148,7 +148,7 @@ func TestRedis_HKeys_HVals(t *testing.T) {
 }
 
 func TestRedis_HIncrBy(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_HIncrBy_Key"
 	fieldMap := map[string]interface{}{
 		"TestRedis_HIncrBy_field1": 0,
@@ -170,7 +170,7 @@ func TestRedis_HIncrBy(t *testing.T) {
 }
 
 func TestRedis_HScan(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_HScan_Key"
 	fieldMap := map[string]interface{}{
 		"TestRedis_HScan_field1": "1",
@@ -8,7 +8,7 @@ import (
 )
 
 func TestRedis_LPush_LPushX(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_LPush"
 	list := []interface{}{"1", "2", "3", "4", "5"}
 
@@ -31,7 +31,7 @@ func TestRedis_LPush_LPushX(t *testing.T) {
 }
 
 func TestRedis_RPush_RPushX(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_RPush"
 	list := []interface{}{"1", "2", "3", "4", "5"}
 
@@ -54,7 +54,7 @@ func TestRedis_RPush_RPushX(t *testing.T) {
 }
 
 func TestRedis_LPop_RPop_LLen(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_LPop_RPop"
 	list := []interface{}{"1", "2", "3", "4", "5"}
 
@@ -76,7 +76,7 @@ func TestRedis_LPop_RPop_LLen(t *testing.T) {
 }
 
 func TestRedis_LIndex_LSet(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_LIndex_LSet"
 	list := []interface{}{"1", "2", "3", "4", "5"}
 
@@ -97,7 +97,7 @@ func TestRedis_LIndex_LSet(t *testing.T) {
 
 }
 func TestRedis_LRem(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_LRem"
 	list := []interface{}{"1", "1", "1", "4", "5"}
 
@@ -152,7 +152,7 @@ func TestRedis_LRem(t *testing.T) {
 }
 
 func TestRedis_LInsert(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_LInsert"
 	list := []interface{}{"1", "2", "3", "4", "5"}
 
@@ -182,7 +182,7 @@ func TestRedis_LInsert(t *testing.T) {
 
 }
 func TestRedis_LTrim(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_LTrim"
 	list := []interface{}{"1", "2", "3", "4", "5"}
 
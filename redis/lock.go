@@ -1,9 +1,19 @@
 package redis
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/utils"
 	"github.com/GitHub121380/golib/zlog"
+	"github.com/gin-gonic/gin"
 	"github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
 const (
@@ -39,3 +49,290 @@ func (objRedis Redis) tryLock(key string, value interface{}, expire uint64, exTy
 	}
 	return true, nil
 }
+
+const ( // 分布式锁默认配置
+	defaultLockTTL     = 10 * time.Second
+	defaultLockBackoff = 50 * time.Millisecond
+	maxLockBackoff     = time.Second
+	// Redlock 时钟漂移系数
+	lockDriftFactor = 0.01
+)
+
+// 值与持有者一致时删除
+var unlockScript = NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`)
+
+// 值与持有者一致时续期
+var extendScript = NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`)
+
+// 分布式锁配置
+type LockOptions struct {
+	// 锁过期时间，默认10s
+	TTL time.Duration
+	// 阻塞获取锁的最长时间，0 为只尝试一次
+	Timeout time.Duration
+	// 重试的初始间隔，之后按指数增长，最大1s，默认50ms
+	Backoff time.Duration
+	// 持有期间每 TTL/3 自动续期，直到 Unlock
+	Watchdog bool
+}
+
+// 带持有者标识的分布式锁。指定多个 redis 服务时使用 Redlock，多数服务加锁成功才视为持有
+type Lock struct {
+	ctx      *gin.Context
+	key      string
+	token    string
+	opt      LockOptions
+	services []string
+
+	mu   sync.Mutex
+	held bool
+	stop chan struct{}
+	done chan struct{}
+}
+
+// 创建分布式锁，services 为 ral 中配置的 redis 服务名
+func NewLock(ctx *gin.Context, key string, opt LockOptions, services ...string) *Lock {
+	if opt.TTL <= 0 {
+		opt.TTL = defaultLockTTL
+	}
+	if opt.Backoff <= 0 {
+		opt.Backoff = defaultLockBackoff
+	}
+
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return &Lock{
+		ctx: ctx,
+		// 提前处理压测前缀，续期时不依赖请求的 context
+		key:      utils.ShadowName(ctx, key),
+		token:    hex.EncodeToString(buf),
+		opt:      opt,
+		services: services,
+	}
+}
+
+// 在当前 redis 服务上创建分布式锁
+func (objRedis Redis) NewLock(key string, opt LockOptions) *Lock {
+	return NewLock(objRedis.ctx, key, opt, objRedis.r.Service)
+}
+
+// 返回持有者标识
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// 返回当前是否持有锁，续期失败后返回 false
+func (l *Lock) Held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held
+}
+
+// 尝试获取锁一次
+func (l *Lock) TryLock() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held {
+		return true, nil
+	}
+
+	ok, err := l.acquire()
+	if ok {
+		l.held = true
+		if l.opt.Watchdog {
+			l.startWatchdog()
+		}
+	}
+	return ok, err
+}
+
+// 获取锁，未获取到时按退避间隔重试，超过 Timeout 返回 LockTimeoutErr
+func (l *Lock) Lock() error {
+	deadline := time.Now().Add(l.opt.Timeout)
+	backoff := l.opt.Backoff
+	for {
+		ok, err := l.TryLock()
+		if ok {
+			return nil
+		}
+
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			if err != nil {
+				return err
+			}
+			return LockTimeoutErr
+		}
+		if backoff > remain {
+			backoff = remain
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ral.Context(l.ctx).Done():
+			timer.Stop()
+			return ral.Err(l.ctx)
+		}
+		if backoff *= 2; backoff > maxLockBackoff {
+			backoff = maxLockBackoff
+		}
+	}
+}
+
+// 释放锁，只删除自己持有的锁
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stopWatchdog()
+	if !l.held {
+		return LockNotHeldErr
+	}
+	l.held = false
+
+	n, err := l.eval(l.ctx, unlockScript)
+	if err != nil {
+		return err
+	}
+	if n < l.quorum() {
+		return LockNotHeldErr
+	}
+	return nil
+}
+
+// 延长锁的过期时间
+func (l *Lock) Extend() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		return LockNotHeldErr
+	}
+	return l.extend(l.ctx)
+}
+
+func (l *Lock) quorum() int {
+	return len(l.services)/2 + 1
+}
+
+// 在所有服务上加锁，多数成功且剩余有效时间大于0时成功，否则释放已获取的锁
+func (l *Lock) acquire() (bool, error) {
+	if len(l.services) == 0 {
+		return false, NotExistServerNameErr
+	}
+
+	start := time.Now()
+	n, errs := 0, []error(nil)
+	for _, service := range l.services {
+		ok, err := l.do(l.ctx, service, func(r *Redis) (bool, error) {
+			_, err := redis.String(r.Do("SET", l.key, l.token, PXMILLISSECONDS, l.opt.TTL.Milliseconds(), NOTEXISTS))
+			if err == redis.ErrNil {
+				return false, nil
+			}
+			return err == nil, err
+		})
+		if ok {
+			n++
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	drift := time.Duration(float64(l.opt.TTL)*lockDriftFactor) + 2*time.Millisecond
+	if n >= l.quorum() && l.opt.TTL-time.Since(start)-drift > 0 {
+		return true, nil
+	}
+
+	if n > 0 {
+		_, _ = l.eval(l.ctx, unlockScript)
+	}
+	if len(errs) > 0 && len(errs) > len(l.services)-l.quorum() {
+		return false, errs[0]
+	}
+	return false, nil
+}
+
+func (l *Lock) extend(ctx *gin.Context) error {
+	n, err := l.eval(ctx, extendScript, l.opt.TTL.Milliseconds())
+	if n >= l.quorum() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return LockNotHeldErr
+}
+
+// 在所有服务上执行脚本，返回执行结果为1的服务数
+func (l *Lock) eval(ctx *gin.Context, script *Script, args ...interface{}) (int, error) {
+	n, errs := 0, []error(nil)
+	for _, service := range l.services {
+		ok, err := l.do(ctx, service, func(r *Redis) (bool, error) {
+			reply, err := redis.Int(r.Eval(script, append([]interface{}{l.key, l.token}, args...)...))
+			return reply == 1, err
+		})
+		if ok {
+			n++
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return n, errs[0]
+	}
+	return n, nil
+}
+
+func (l *Lock) do(ctx *gin.Context, service string, fn func(r *Redis) (bool, error)) (bool, error) {
+	r, err := GetInstanceByKey(ctx, service, l.key)
+	if err != nil {
+		return false, err
+	}
+	defer r.Release()
+	return fn(r)
+}
+
+// 启动续期协程，需持有 l.mu
+func (l *Lock) startWatchdog() {
+	l.stop, l.done = make(chan struct{}), make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(l.opt.TTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			// 续期不受请求超时影响
+			if err := l.extend(nil); err != nil {
+				zlog.WarnLogger(l.ctx, fmt.Sprintf("redis lock %s extend failed: %s", l.key, err.Error()), zap.String("prot", "redis"))
+				if err == LockNotHeldErr {
+					l.mu.Lock()
+					if l.stop == stop {
+						l.held, l.stop, l.done = false, nil, nil
+					}
+					l.mu.Unlock()
+					return
+				}
+			}
+		}
+	}(l.stop, l.done)
+}
+
+// 停止续期协程，需持有 l.mu
+func (l *Lock) stopWatchdog() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	done := l.done
+	l.stop, l.done = nil, nil
+
+	// 续期协程可能在等待 l.mu，先释放锁再等待退出
+	l.mu.Unlock()
+	<-done
+	l.mu.Lock()
+}
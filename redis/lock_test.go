@@ -1,13 +1,16 @@
 package redis
 
 import (
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestRedis_SetNxByEX(t *testing.T) {
-	setup()
+	setup(t)
 
 	type args struct {
 		key    string
@@ -46,11 +49,10 @@ func TestRedis_SetNxByEX(t *testing.T) {
 }
 
 func TestRedis_SetNxByPX(t *testing.T) {
-	setup()
-	objRedis, err := GetInstance("")
+	setup(t)
+	objRedis, err := GetInstance(nil, ServiceName)
 	if err != nil {
-		log.Error("[FixTaskMedal]Get redis error.ERROR:", err)
-		return
+		t.Fatal("Get redis error:", err)
 	}
 	type args struct {
 		key    string
@@ -83,14 +85,66 @@ func TestRedis_SetNxByPX(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			res, err := objRedis.SetNxByPX(tt.args.key, tt.args.value, tt.args.expire)
 			if err != nil {
-				log.Errorf("Redis.SetNxByPX() error = %v, res %v", err, res)
+				t.Errorf("Redis.SetNxByPX() error = %v, res %v", err, res)
 				return
 			}
-			if res == true {
-				log.Errorf("Redis.SetNxByPX() error = %v, res %v", err, res)
-			} else {
-				log.Errorf("Redis.SetNxByPX() error = %v, res %v", err, res)
-			}
+			t.Logf("Redis.SetNxByPX() res %v", res)
 		})
 	}
 }
+
+func TestLock(t *testing.T) {
+	setup(t)
+
+	l := r.NewLock("lock", LockOptions{TTL: time.Second, Watchdog: true})
+	ok, err := l.TryLock()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 其他持有者无法获取及释放
+	other := NewLock(nil, "lock", LockOptions{TTL: time.Second, Timeout: 100 * time.Millisecond}, ServiceName)
+	assert.Equal(t, LockTimeoutErr, other.Lock())
+	assert.Equal(t, LockNotHeldErr, other.Unlock())
+
+	// 自动续期超过 TTL 后仍持有
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(t, l.Held())
+	token, err := redis.String(r.Do("GET", "lock"))
+	assert.NoError(t, err)
+	assert.Equal(t, l.Token(), token)
+
+	assert.NoError(t, l.Unlock())
+	assert.NoError(t, other.Lock())
+	assert.NoError(t, other.Unlock())
+}
+
+// 执行失败时返回错误，不视为未持有锁
+func TestLockError(t *testing.T) {
+	var mu sync.Mutex
+	fail := false
+	s := newFakeServer(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return "-ERR unavailable\r\n"
+		}
+		return "+OK\r\n"
+	})
+	defer s.Close()
+	addService(t, "lock-error", s.addr, nil)
+
+	l := NewLock(nil, "lock", LockOptions{TTL: time.Second}, "lock-error")
+	ok, err := l.TryLock()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	assert.EqualError(t, l.Extend(), "ERR unavailable")
+	assert.EqualError(t, l.Unlock(), "ERR unavailable")
+
+	ok, err = NewLock(nil, "other", LockOptions{TTL: time.Second}, "lock-error").TryLock()
+	assert.False(t, ok)
+	assert.EqualError(t, err, "ERR unavailable")
+}
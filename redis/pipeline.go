@@ -2,9 +2,11 @@ package redis
 
 import (
 	"errors"
+	"github.com/GitHub121380/golib/metrics"
 	"github.com/GitHub121380/golib/utils"
 	"github.com/GitHub121380/golib/zlog"
 	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
 	"go.uber.org/zap"
 	"time"
 )
@@ -37,6 +39,7 @@ func (p *Pipeline) Put(ctx *gin.Context, cmd string, args ...interface{}) error
 	if len(args) < 1 {
 		return errors.New("no key found in args")
 	}
+	args = shadowArgs(ctx, cmd, args)
 	c := commands{
 		cmd:  cmd,
 		args: args,
@@ -56,9 +59,22 @@ func (p *Pipeline) Put(ctx *gin.Context, cmd string, args ...interface{}) error
 
 func (p *Pipeline) Exec(ctx *gin.Context) (res []interface{}, err error) {
 	start := time.Now()
-	zlog.CreateSpan(ctx)
+	span := zlog.StartSpan(ctx, "redis:"+p.redis.r.Service)
+	done := metrics.Client("redis", p.redis.r.Service, "pipeline")
 
-	conn := p.redis.r.pool.Get()
+	// 集群模式下按第一个命令的 key 选取节点
+	var conn redis.Conn
+	if len(p.cmds) > 0 {
+		conn, err = p.redis.r.routeConn(ctx, p.cmds[0].cmd, p.cmds[0].args)
+	} else {
+		conn, err = p.redis.r.getPoolConn(ctx)
+	}
+	if err != nil {
+		span.SetError(err)
+		span.Finish()
+		done(err)
+		return nil, err
+	}
 	defer conn.Close()
 
 	for i := range p.cmds {
@@ -71,7 +87,7 @@ func (p *Pipeline) Exec(ctx *gin.Context) (res []interface{}, err error) {
 	if err == nil {
 		for i := range p.cmds {
 			var reply interface{}
-			reply, err = conn.Receive()
+			reply, err = receive(ctx, conn, 0)
 			res = append(res, reply)
 			p.cmds[i].reply, p.cmds[i].err = reply, err
 		}
@@ -93,5 +109,12 @@ func (p *Pipeline) Exec(ctx *gin.Context) (res []interface{}, err error) {
 
 	zlog.InfoLogger(ctx, msg, field...)
 
+	span.SetTag("prot", "redis")
+	span.SetTag("command", "pipeline")
+	span.SetTag("cmds", len(p.cmds))
+	span.SetError(err)
+	span.Finish()
+	done(err)
+
 	return res, err
 }
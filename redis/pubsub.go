@@ -0,0 +1,306 @@
+package redis
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+const ( // 订阅默认配置
+	// 心跳间隔，超过两个间隔未收到任何数据时重连
+	subscribePingInterval = 30 * time.Second
+	subscribeMinBackoff   = 100 * time.Millisecond
+	subscribeMaxBackoff   = 5 * time.Second
+)
+
+// 发布消息，返回收到消息的订阅者数量
+func (objRedis *Redis) Publish(channel string, message interface{}) (int64, error) {
+	return redis.Int64(objRedis.Do("PUBLISH", channel, message))
+}
+
+// 订阅收到的消息，Pattern 为匹配的模式，普通订阅时为空
+type Message struct {
+	Channel string
+	Pattern string
+	Data    []byte
+}
+
+// 订阅者，独占 ral redis 实例的一个连接，连接断开后自动重连并重新订阅。
+// 消息在接收协程中依次处理，handler 阻塞会影响后续消息的接收
+type Subscriber struct {
+	service string
+	handler func(msg Message)
+
+	mu       sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+	conn     *redis.PubSubConn
+	// 当前连接上的订阅数
+	count int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// 创建订阅者并开始接收消息，service 为 ral 中配置的 redis 服务名
+func NewSubscriber(service string, handler func(msg Message)) *Subscriber {
+	s := &Subscriber{
+		service:  service,
+		handler:  handler,
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// 订阅 channel，重连后自动重新订阅
+func (s *Subscriber) Subscribe(channels ...string) error {
+	return s.update(s.channels, true, channels, func(c *redis.PubSubConn, args []interface{}) error {
+		return c.Subscribe(args...)
+	})
+}
+
+// 按模式订阅 channel
+func (s *Subscriber) PSubscribe(patterns ...string) error {
+	return s.update(s.patterns, true, patterns, func(c *redis.PubSubConn, args []interface{}) error {
+		return c.PSubscribe(args...)
+	})
+}
+
+// 取消订阅 channel
+func (s *Subscriber) Unsubscribe(channels ...string) error {
+	return s.update(s.channels, false, channels, func(c *redis.PubSubConn, args []interface{}) error {
+		return c.Unsubscribe(args...)
+	})
+}
+
+// 取消按模式订阅
+func (s *Subscriber) PUnsubscribe(patterns ...string) error {
+	return s.update(s.patterns, false, patterns, func(c *redis.PubSubConn, args []interface{}) error {
+		return c.PUnsubscribe(args...)
+	})
+}
+
+// 更新订阅列表，已连接时立即发送订阅命令，发送失败时由重连后重新订阅
+func (s *Subscriber) update(set map[string]bool, add bool, list []string, send func(c *redis.PubSubConn, args []interface{}) error) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	args := make([]interface{}, 0, len(list))
+	for _, v := range list {
+		if set[v] != add {
+			args = append(args, v)
+		}
+		if add {
+			set[v] = true
+		} else {
+			delete(set, v)
+		}
+	}
+	if s.conn == nil || len(args) == 0 {
+		return nil
+	}
+	return send(s.conn, args)
+}
+
+// 停止接收消息并关闭连接
+func (s *Subscriber) Close() {
+	s.mu.Lock()
+	if !s.stopped() {
+		close(s.stop)
+		// 取消所有订阅，接收协程收到回复后退出
+		if s.conn != nil {
+			s.conn.Unsubscribe()
+			s.conn.PUnsubscribe()
+		}
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+func (s *Subscriber) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// 接收循环，出错后按指数退避重连
+func (s *Subscriber) run() {
+	defer close(s.done)
+	backoff := subscribeMinBackoff
+	for !s.stopped() {
+		received, err := s.serve()
+		if s.stopped() {
+			return
+		}
+		zlog.WarnLogger(nil, fmt.Sprintf("redis subscriber %s disconnected: %s", s.service, err.Error()), zap.String("prot", "redis"))
+
+		if received {
+			backoff = subscribeMinBackoff
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > subscribeMaxBackoff {
+			backoff = subscribeMaxBackoff
+		}
+	}
+}
+
+// 建立连接、订阅并接收消息，直到连接出错或停止。返回是否收到过数据
+func (s *Subscriber) serve() (bool, error) {
+	conn, addr, err := dedicatedConn(s.service)
+	if err != nil {
+		return false, err
+	}
+	c := &redis.PubSubConn{Conn: conn}
+	defer c.Close()
+
+	s.mu.Lock()
+	if s.stopped() {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.count = 0
+	if err := s.resubscribe(c); err != nil {
+		s.mu.Unlock()
+		return false, fmt.Errorf("%s %s", addr, err.Error())
+	}
+	s.conn = c
+	s.mu.Unlock()
+
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+	go s.heartbeat(c, stop)
+
+	received := false
+	for {
+		// 没有订阅时不能发送 PING，不设置读超时
+		timeout := time.Duration(0)
+		s.mu.Lock()
+		if s.count > 0 {
+			timeout = 2 * subscribePingInterval
+		}
+		s.mu.Unlock()
+
+		switch v := c.ReceiveWithTimeout(timeout).(type) {
+		case redis.Message:
+			received = true
+			s.dispatch(Message{Channel: v.Channel, Pattern: v.Pattern, Data: v.Data})
+		case redis.Subscription:
+			received = true
+			s.mu.Lock()
+			s.count = v.Count
+			s.mu.Unlock()
+		case redis.Pong:
+			received = true
+		case error:
+			if s.stopped() {
+				return received, nil
+			}
+			return received, fmt.Errorf("%s %s", addr, v.Error())
+		}
+		if s.stopped() {
+			return received, nil
+		}
+	}
+}
+
+// 重新订阅所有 channel 及模式，需持有 s.mu
+func (s *Subscriber) resubscribe(c *redis.PubSubConn) error {
+	if len(s.channels) > 0 {
+		if err := c.Subscribe(setList(s.channels)...); err != nil {
+			return err
+		}
+	}
+	if len(s.patterns) > 0 {
+		if err := c.PSubscribe(setList(s.patterns)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 定期发送 PING，连接异常时接收协程读超时后重连
+func (s *Subscriber) heartbeat(c *redis.PubSubConn, stop chan struct{}) {
+	ticker := time.NewTicker(subscribePingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		// 连接已退出接收时不再发送
+		if s.conn == c && s.count > 0 {
+			c.Ping("")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Subscriber) dispatch(msg Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			zlog.ErrorLogger(nil, fmt.Sprintf("redis subscriber %s handle %s panic: %v", s.service, msg.Channel, r), zap.String("prot", "redis"))
+		}
+	}()
+	s.handler(msg)
+}
+
+func setList(set map[string]bool) []interface{} {
+	list := make([]interface{}, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	return list
+}
+
+// 从 ral 实例的连接池中获取独占连接，用于订阅、阻塞读取等长期占用连接的场景
+func dedicatedConn(service string) (redis.Conn, string, error) {
+	ins, err := ral.GetInstance(nil, ral.TYPE_REDIS, service)
+	if err != nil {
+		return nil, "", err
+	}
+	defer ins.Release()
+
+	r, ok := ins.Client.(*RedisClient)
+	if !ok {
+		return nil, "", ral.ERR_NOT_FOUND_CLIENT
+	}
+	addr := fmt.Sprintf("%s:%d", ins.IP, ins.Port)
+	conn, err := r.getPoolConn(nil)
+	if err != nil {
+		return nil, addr, err
+	}
+	if err := conn.Err(); err != nil {
+		conn.Close()
+		return nil, addr, err
+	}
+	return conn, addr, nil
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/GitHub121380/golib/metrics"
 	"github.com/GitHub121380/golib/ral"
 	"github.com/GitHub121380/golib/zlog"
 	"github.com/gomodule/redigo/redis"
@@ -21,6 +22,11 @@ type RedisClient struct {
 	ins  *ral.Instance
 	pool *redis.Pool
 
+	// 集群模式下的槽分布及节点连接池
+	cluster *cluster
+	// 哨兵模式下跟踪的主节点
+	sentinel *sentinel
+
 	Service    string         // 服务单元名称
 	roundRobin uint64         // 轮询计数
 	config     *RedisConfig   // 配置记录
@@ -32,17 +38,47 @@ type RedisClient struct {
 type Redis struct {
 	r   *RedisClient
 	ctx *gin.Context
+
+	// 对冲请求延迟，0 时使用资源配置
+	hedge time.Duration
+	// 阻塞命令的等待时间，读超时需加上该时间
+	block time.Duration
+}
+
+// 对冲请求返回的结果及实际响应的实例
+type hedgeReply struct {
+	reply interface{}
+	ins   *ral.Instance
+}
+
+// 返回开启对冲请求的副本，仅用于幂等的读命令。副本与原对象共用实例，只需 Release 一次
+func (objRedis *Redis) WithHedge(delay time.Duration) *Redis {
+	c := *objRedis
+	c.hedge = delay
+	return &c
 }
 
 func (objRedis *Redis) Send(commandName string, args ...interface{}) (err error) {
-	objRedis.r.ins.Retry(func(res *ral.Resource, ins *ral.Instance) bool {
+	args = shadowArgs(objRedis.ctx, commandName, args)
+	// 测试模式下由模拟规则返回
+	_, err = ral.MockCall(objRedis.ctx, objRedis.mockCall(commandName, args), func() (interface{}, error) {
+		return nil, objRedis.send(commandName, args...)
+	})
+	return err
+}
+
+func (objRedis *Redis) send(commandName string, args ...interface{}) (err error) {
+	objRedis.r.ins.RetryContext(objRedis.ctx, func(res *ral.Resource, ins *ral.Instance) bool {
 		if r, ok := ins.Client.(*RedisClient); ok {
-			conn := r.pool.Get()
+			var conn redis.Conn
+			if conn, err = r.routeConn(objRedis.ctx, commandName, args); err != nil {
+				return err != ral.ERR_BUDGET_EXHAUSTED && err != ral.ERR_CONTEXT_CANCELED
+			}
 			defer conn.Close()
 
 			if err = conn.Send(commandName, args...); err == nil {
 				conn.Flush()
-				_, err = conn.Receive()
+				_, err = receive(objRedis.ctx, conn, res.ReadTimeOut)
 				return false
 			}
 		}
@@ -52,21 +88,49 @@ func (objRedis *Redis) Send(commandName string, args ...interface{}) (err error)
 }
 
 func (objRedis *Redis) Do(commandName string, args ...interface{}) (reply interface{}, err error) {
+	args = shadowArgs(objRedis.ctx, commandName, args)
+	return ral.MockCall(objRedis.ctx, objRedis.mockCall(commandName, args), func() (interface{}, error) {
+		return objRedis.call(commandName, args...)
+	})
+}
+
+func (objRedis *Redis) mockCall(commandName string, args []interface{}) ral.Call {
+	return ral.Call{Type: ral.TYPE_REDIS, Service: objRedis.r.Service, Method: commandName, Args: args}
+}
+
+func (objRedis *Redis) call(commandName string, args ...interface{}) (reply interface{}, err error) {
+	span := zlog.StartSpan(objRedis.ctx, "redis:"+objRedis.r.Service)
+	done := metrics.Client("redis", objRedis.r.Service, commandName)
 	start := time.Now()
 	remoteIp, remotePort := objRedis.r.ins.IP, objRedis.r.ins.Port
-	retry := objRedis.r.ins.Retry(func(res *ral.Resource, ins *ral.Instance) bool {
-		if r, ok := ins.Client.(*RedisClient); ok {
-			conn := r.pool.Get()
-			defer conn.Close()
-
-			if reply, err = conn.Do(commandName, args...); err == nil {
-				remoteIp, remotePort = ins.IP, ins.Port
-				return false
-			} else {
-				zlog.WarnLogger(objRedis.ctx, err.Error(), zap.String("service", objRedis.r.Service))
+	hedged := 0
+	retry := objRedis.r.ins.RetryContext(objRedis.ctx, func(res *ral.Resource, ins *ral.Instance) bool {
+		fn := func(ctx *gin.Context, ins *ral.Instance) (interface{}, error) {
+			r, ok := ins.Client.(*RedisClient)
+			if !ok {
+				return nil, ral.ERR_NOT_FOUND_CLIENT
+			}
+			reply, err := r.do(ctx, res.ReadTimeOut+objRedis.block, commandName, args)
+			if err != nil {
+				zlog.WarnLogger(ctx, err.Error(), zap.String("service", objRedis.r.Service))
+				return nil, err
 			}
+			return hedgeReply{reply, ins}, nil
 		}
-		return true
+		var n int
+		if objRedis.block > 0 {
+			// 阻塞命令不发起对冲请求
+			reply, err = fn(objRedis.ctx, ins)
+		} else {
+			reply, n, err = ral.Hedge(objRedis.ctx, ins, objRedis.hedge, fn)
+		}
+		hedged += n
+		if err != nil {
+			return err != ral.ERR_BUDGET_EXHAUSTED && err != ral.ERR_CONTEXT_CANCELED
+		}
+		r := reply.(hedgeReply)
+		reply, remoteIp, remotePort = r.reply, r.ins.IP, r.ins.Port
+		return false
 	})
 
 	end := time.Now()
@@ -80,6 +144,7 @@ func (objRedis *Redis) Do(commandName string, args ...interface{}) (reply interf
 		zap.String("commandVal", utils.JoinArgs(logForRedisValue, args)),
 		zap.String("remoteAddr", fmt.Sprintf("%s:%d", remoteIp, remotePort)),
 		zap.Int("retry", retry),
+		zap.Int("hedge", hedged),
 	}
 
 	// 执行时间 单位:毫秒
@@ -88,7 +153,141 @@ func (objRedis *Redis) Do(commandName string, args ...interface{}) (reply interf
 		msg = fmt.Sprintf("redis do error: %s", err.Error())
 	}
 	zlog.InfoLogger(objRedis.ctx, msg, fields...)
-	return reply, nil
+
+	span.SetTag("prot", "redis")
+	span.SetTag("command", commandName)
+	span.SetTag("remoteAddr", fmt.Sprintf("%s:%d", remoteIp, remotePort))
+	span.SetTag("retry", retry)
+	span.SetTag("hedge", hedged)
+	span.SetError(err)
+	span.Finish()
+	done(err)
+	return reply, err
+}
+
+// 从连接池获取连接，调用方超时或取消时返回错误
+func (r *RedisClient) getPoolConn(ctx *gin.Context) (redis.Conn, error) {
+	return r.routeConn(ctx, "", nil)
+}
+
+// 按命令获取连接，集群模式下选取 key 所在槽的节点，哨兵模式下为当前主节点
+func (r *RedisClient) routeConn(ctx *gin.Context, cmd string, args []interface{}) (redis.Conn, error) {
+	if err := ral.Err(ctx); err != nil {
+		return nil, err
+	}
+
+	p := r.pool
+	switch {
+	case r.cluster != nil:
+		p, _ = r.cluster.route(cmd, args)
+	case r.sentinel != nil:
+		p = r.sentinel.current()
+	}
+	if p == nil {
+		return nil, GetRedisConnErr
+	}
+	return poolConn(ctx, p)
+}
+
+func poolConn(ctx *gin.Context, p *redis.Pool) (redis.Conn, error) {
+	if _, ok := ral.Deadline(ctx); !ok {
+		return p.Get(), nil
+	}
+	return p.GetContext(ral.Context(ctx))
+}
+
+// 执行命令，集群模式下跟随 MOVED/ASK 重定向，哨兵模式下主节点异常时重新查询主节点
+func (r *RedisClient) do(ctx *gin.Context, timeout time.Duration, cmd string, args []interface{}) (interface{}, error) {
+	if r.cluster != nil {
+		return r.cluster.do(ctx, timeout, cmd, args)
+	}
+
+	conn, err := r.getPoolConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	reply, err := do(ctx, conn, timeout, cmd, args...)
+	if err != nil && r.sentinel != nil {
+		r.sentinel.failed(err)
+	}
+	return reply, err
+}
+
+// 返回当前使用的所有连接池
+func (r *RedisClient) allPools() []*redis.Pool {
+	switch {
+	case r.cluster != nil:
+		return r.cluster.pools()
+	case r.sentinel != nil:
+		if p := r.sentinel.current(); p != nil {
+			return []*redis.Pool{p}
+		}
+		return nil
+	}
+	return []*redis.Pool{r.pool}
+}
+
+func (r *RedisClient) stats() metrics.PoolStats {
+	var stats metrics.PoolStats
+	for _, p := range r.allPools() {
+		s := p.Stats()
+		stats.Active += s.ActiveCount
+		stats.Idle += s.IdleCount
+	}
+	return stats
+}
+
+func (r *RedisClient) close() {
+	switch {
+	case r.cluster != nil:
+		r.cluster.close()
+	case r.sentinel != nil:
+		r.sentinel.close()
+	case r.pool != nil:
+		closePool(r.pool)
+	}
+}
+
+func closePool(p *redis.Pool) {
+	if err := p.Close(); err != nil {
+		zlog.WarnLogger(nil, "redis pool close error: "+err.Error(), zap.String("prot", "redis"))
+	}
+}
+
+// 执行命令，调用方设置了截止时间时以剩余时间作为读超时
+func do(ctx *gin.Context, conn redis.Conn, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := doWithBudget(ctx, conn, timeout, cmd, args...)
+	// 脚本未缓存时在同一连接上使用 EVAL 执行
+	if c, a, ok := evalFallback(cmd, args, err); ok {
+		return doWithBudget(ctx, conn, timeout, c, a...)
+	}
+	return reply, err
+}
+
+func doWithBudget(ctx *gin.Context, conn redis.Conn, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if _, ok := ral.Deadline(ctx); !ok {
+		if timeout > 0 {
+			return redis.DoWithTimeout(conn, timeout, cmd, args...)
+		}
+		return conn.Do(cmd, args...)
+	}
+	budget, err := ral.Budget(ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return redis.DoWithTimeout(conn, budget, cmd, args...)
+}
+
+func receive(ctx *gin.Context, conn redis.Conn, timeout time.Duration) (interface{}, error) {
+	if _, ok := ral.Deadline(ctx); !ok {
+		return conn.Receive()
+	}
+	budget, err := ral.Budget(ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return redis.ReceiveWithTimeout(conn, budget)
 }
 
 func (objRedis *Redis) Release() {
@@ -100,41 +299,55 @@ func (objRedis *Redis) Release() {
 
 var mod *ral.Module
 
+// 创建到 addr 的连接池
+func newPool(res *ral.Resource, addr string, password string, database int) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     res.Redis.MaxIdle,
+		MaxActive:   res.Redis.MaxActive,
+		IdleTimeout: res.Redis.IdleTimeout,
+		Wait:        res.Redis.Wait,
+		Dial: func() (conn redis.Conn, e error) {
+			con, err := redis.Dial(
+				"tcp",
+				addr,
+				redis.DialPassword(password),
+				redis.DialDatabase(database),
+				redis.DialConnectTimeout(res.ConnTimeOut),
+				redis.DialReadTimeout(res.ReadTimeOut),
+				redis.DialWriteTimeout(res.WriteTimeOut),
+			)
+			if err != nil {
+				zlog.WarnLogger(nil, "get_redis_conn_fail: "+err.Error(), zap.String("prot", "redis"))
+				return nil, err
+			}
+			return con, nil
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
+
 func init() {
 	appendFun := func(self *ral.Resource, res *ral.Resource, ins *ral.Instance) (*ral.Instance, error) {
-		p := &redis.Pool{
-			MaxIdle:     res.Redis.MaxIdle,
-			MaxActive:   res.Redis.MaxActive,
-			IdleTimeout: res.Redis.IdleTimeout,
-			Wait:        res.Redis.Wait,
-			Dial: func() (conn redis.Conn, e error) {
-				con, err := redis.Dial(
-					"tcp",
-					fmt.Sprintf("%s:%d", ins.IP, ins.Port),
-					redis.DialPassword(res.Redis.Password),
-					redis.DialDatabase(res.Redis.Database),
-					redis.DialConnectTimeout(res.ConnTimeOut),
-					redis.DialReadTimeout(res.ReadTimeOut),
-					redis.DialWriteTimeout(res.WriteTimeOut),
-				)
-				if err != nil {
-					zlog.WarnLogger(nil, "get_redis_conn_fail: "+err.Error(), zap.String("prot", "redis"))
-					return nil, err
-				}
-				return con, nil
-			},
-			TestOnBorrow: func(c redis.Conn, t time.Time) error {
-				if time.Since(t) < time.Minute {
-					return nil
-				}
-				_, err := c.Do("PING")
-				return err
-			},
-		}
+		addr := fmt.Sprintf("%s:%d", ins.IP, ins.Port)
 		client := &RedisClient{
-			pool:    p,
 			Service: res.Name,
 		}
+		switch res.Redis.Mode {
+		case ModeCluster:
+			client.cluster = newCluster(res, addr)
+		case ModeSentinel:
+			client.sentinel = newSentinel(res, addr)
+		default:
+			client.pool = newPool(res, addr, res.Redis.Password, res.Redis.Database)
+			go preloadScripts(client.pool, res.Name, addr)
+		}
+		metrics.RegisterPool("redis", res.Name, addr, client.stats)
 		sub := &ral.Instance{
 			IP:     ins.IP,
 			Port:   ins.Port,
@@ -145,10 +358,10 @@ func init() {
 	}
 
 	removeFun := func(self *ral.Instance, res *ral.Resource, ins *ral.Instance) bool {
-		if r, ok := ins.Client.(*RedisClient); ok && r.pool != nil {
-			if err := r.pool.Close(); err != nil {
-				zlog.WarnLogger(nil, "redis pool close error: "+err.Error(), zap.String("prot", "redis"))
-			}
+		// ins 可能是 zns 的父实例，连接池属于本模块创建的 self
+		if r, ok := self.Client.(*RedisClient); ok {
+			metrics.UnregisterPool("redis", r.Service, fmt.Sprintf("%s:%d", self.IP, self.Port))
+			r.close()
 		}
 		return true
 	}
@@ -160,6 +373,23 @@ func init() {
 		},
 		Append: appendFun,
 		Remove: removeFun,
+		Mock: func(res *ral.Resource, ins *ral.Instance) interface{} {
+			// 模拟实例不创建连接池，录制模式下未匹配的调用返回 GetRedisConnErr
+			return &RedisClient{Service: res.Name, ins: ins}
+		},
+		Check: func(res *ral.Resource, ins *ral.Instance, timeout time.Duration) error {
+			r, ok := ins.Client.(*RedisClient)
+			if !ok {
+				return ral.ERR_NOT_FOUND_CLIENT
+			}
+			conn, err := r.getPoolConn(nil)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = redis.DoWithTimeout(conn, timeout, "PING")
+			return err
+		},
 		Method: func(ctx *gin.Context, res *ral.Resource, ins *ral.Instance, method string, data map[string]interface{}, head map[string]string) (interface{}, error) {
 			return nil, nil
 		}}
@@ -168,7 +398,16 @@ func init() {
 }
 
 func GetInstance(ctx *gin.Context, service string) (*Redis, error) {
-	ins, err := ral.GetInstance(ctx, ral.TYPE_REDIS, service)
+	return newRedis(ctx, service, "")
+}
+
+// 按key通过一致性hash选取实例，适用于按key分片的场景
+func GetInstanceByKey(ctx *gin.Context, service string, key string) (*Redis, error) {
+	return newRedis(ctx, service, key)
+}
+
+func newRedis(ctx *gin.Context, service string, key string) (*Redis, error) {
+	ins, err := ral.GetInstanceByKey(ctx, ral.TYPE_REDIS, service, key)
 	if err != nil {
 		zlog.WarnLogger(ctx, "redis GetInstance error: not found client", zap.String("prot", "redis"))
 		return nil, ral.ERR_NOT_FOUND_CLIENT
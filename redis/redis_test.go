@@ -1,10 +1,19 @@
 package redis
 
 import (
+	"bufio"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gomodule/redigo/redis"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 var r *Redis
@@ -20,21 +29,123 @@ var RedisConnConfig = &RedisConfig{
 }
 
 func init() {
-	// log.Setup("testredis", "/home/homework/go-common/conf/redis", "./")
-	setup()
+	zlog.ModuleLogger = zap.NewNop()
+	zlog.ServerLogger = zap.NewNop().Sugar()
+	zlog.SetSpanExporter(nil)
 }
 
-func setup() {
-	RedisInit(ServiceName, Hosts, RedisConnConfig)
-	objRedis, err := GetInstance(nil, ServiceName)
-	if err != nil {
-		fmt.Println("setup fail")
-	} else {
+// 连接本地 redis，未启动时跳过依赖 redis 的测试
+func setup(t *testing.T) {
+	if r == nil {
+		if _, ok := ral.GetResource(ral.TYPE_REDIS, ServiceName); !ok {
+			addService(t, ServiceName, Hosts[0], nil)
+		}
+		objRedis, err := GetInstance(nil, ServiceName)
+		if err != nil {
+			t.Fatal("setup fail:", err)
+		}
 		r = objRedis
 	}
+	if _, err := r.Do("PING"); err != nil {
+		t.Skip("redis not available:", err)
+	}
+}
+
+// 注册连接到 addr 的 redis 服务，conf 用于修改资源配置
+func addService(t *testing.T, name string, addr string, conf func(res *ral.Resource)) {
+	res := ral.AddResource(&ral.Resource{Type: ral.TYPE_REDIS, Name: name})
+	if conf != nil {
+		conf(res)
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	ins, err := mod.Append(res, res, &ral.Instance{IP: host, Port: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ral.AddInstance(ral.TYPE_REDIS, name, ins); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 模拟的 redis 服务，handler 按命令参数返回 RESP 格式的响应
+type fakeServer struct {
+	addr    string
+	ln      net.Listener
+	handler func(args []string) string
+
+	mu   sync.Mutex
+	cmds []string
+}
+
+func newFakeServer(t *testing.T, handler func(args []string) string) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{addr: ln.Addr().String(), ln: ln, handler: handler}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil || len(line) < 2 {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			if _, err := rd.ReadString('\n'); err != nil {
+				return
+			}
+			arg, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args[i] = strings.TrimRight(arg, "\r\n")
+		}
+		s.mu.Lock()
+		s.cmds = append(s.cmds, strings.Join(args, " "))
+		s.mu.Unlock()
+		if _, err := c.Write([]byte(s.handler(args))); err != nil {
+			return
+		}
+	}
+}
+
+// 返回收到的命令
+func (s *fakeServer) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+func (s *fakeServer) Close() {
+	_ = s.ln.Close()
+}
+
+func bulkReply(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func arrayReply(items ...string) string {
+	return fmt.Sprintf("*%d\r\n%s", len(items), strings.Join(items, ""))
 }
 
 func TestGetInstance(t *testing.T) {
+	setup(t)
 	t.Run("GetInstance", func(t *testing.T) {
 		objRedis, err := GetInstance(nil, ServiceName)
 		assert.Equal(t, nil, err, fmt.Sprintf("GetInstance error：%v", err))
@@ -44,7 +155,7 @@ func TestGetInstance(t *testing.T) {
 }
 
 func TestDo(t *testing.T) {
-	setup()
+	setup(t)
 	t.Run("Do", func(t *testing.T) {
 		reply, err := r.Do("ping")
 		assert.Equal(t, "PONG", reply)
@@ -52,6 +163,53 @@ func TestDo(t *testing.T) {
 	})
 }
 
+// 命令执行失败时返回错误
+func TestDoError(t *testing.T) {
+	s := newFakeServer(t, func(args []string) string {
+		return "-ERR unavailable\r\n"
+	})
+	defer s.Close()
+	addService(t, "do-error", s.addr, nil)
+
+	objRedis, err := GetInstance(nil, "do-error")
+	assert.NoError(t, err)
+	defer objRedis.Release()
+	_, err = objRedis.Do("GET", "key")
+	assert.EqualError(t, err, "ERR unavailable")
+}
+
+// 模拟模式下资源不存在时使用不建立连接的模拟客户端
+func TestMock(t *testing.T) {
+	m := ral.NewMock()
+	m.On(ral.TYPE_REDIS, "mocked", "GET", "").Return([]byte("v"), nil)
+	m.Start()
+	defer m.Stop()
+
+	objRedis, err := GetInstance(nil, "mocked")
+	assert.NoError(t, err)
+	assert.Nil(t, objRedis.r.pool)
+	v, err := redis.String(objRedis.Do("GET", "k"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v", v)
+
+	// 没有匹配规则时不请求真实服务
+	_, err = objRedis.Do("SET", "k", "v")
+	assert.Equal(t, ral.ERR_MOCK_NOT_FOUND, err)
+}
+
+// 删除 zns 父实例时关闭子实例的连接池
+func TestRemove(t *testing.T) {
+	res := ral.AddResource(&ral.Resource{Type: ral.TYPE_REDIS, Name: "remove"})
+	parent := &ral.Instance{IP: "127.0.0.1", Port: 6379}
+	sub, err := mod.Append(res, res, parent)
+	assert.NoError(t, err)
+	assert.True(t, mod.Remove(sub, res, parent))
+
+	conn := sub.Client.(*RedisClient).pool.Get()
+	defer conn.Close()
+	assert.EqualError(t, conn.Err(), "redigo: get on closed pool")
+}
+
 func TestSend(t *testing.T) {
 
 }
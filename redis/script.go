@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+// Lua 脚本，通过 EVALSHA 执行，服务端未缓存时自动使用 EVAL 执行并缓存
+type Script struct {
+	keyCount int
+	src      string
+	hash     string
+}
+
+// 已注册的脚本，按 sha1 索引
+var scripts = struct {
+	sync.RWMutex
+	list  []*Script
+	index map[string]*Script
+}{index: map[string]*Script{}}
+
+// 注册脚本，keyCount 为 KEYS 的个数。注册后的脚本会预加载到新增的 redis 实例
+func NewScript(keyCount int, src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	hash := hex.EncodeToString(sum[:])
+
+	scripts.Lock()
+	defer scripts.Unlock()
+	if s, ok := scripts.index[hash]; ok && s.keyCount == keyCount {
+		return s
+	}
+	s := &Script{keyCount: keyCount, src: src, hash: hash}
+	scripts.list = append(scripts.list, s)
+	scripts.index[hash] = s
+	return s
+}
+
+func lookupScript(hash string) *Script {
+	scripts.RLock()
+	defer scripts.RUnlock()
+	return scripts.index[hash]
+}
+
+// 返回脚本的 sha1
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+func (s *Script) args(spec string, keysAndArgs []interface{}) []interface{} {
+	args := make([]interface{}, 2+len(keysAndArgs))
+	args[0], args[1] = spec, s.keyCount
+	copy(args[2:], keysAndArgs)
+	return args
+}
+
+// 执行脚本，与 Redis.Do 使用相同的重试及日志
+func (s *Script) Do(objRedis *Redis, keysAndArgs ...interface{}) (interface{}, error) {
+	return objRedis.Do("EVALSHA", s.args(s.hash, keysAndArgs)...)
+}
+
+// 在当前实例上执行脚本
+func (objRedis *Redis) Eval(s *Script, keysAndArgs ...interface{}) (interface{}, error) {
+	return s.Do(objRedis, keysAndArgs...)
+}
+
+func isNoScript(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), "NOSCRIPT ")
+}
+
+// EVALSHA 返回 NOSCRIPT 时，使用已注册的脚本原文通过 EVAL 执行，执行后服务端会缓存脚本
+func evalFallback(cmd string, args []interface{}, err error) (string, []interface{}, bool) {
+	if !strings.EqualFold(cmd, "EVALSHA") || len(args) == 0 || !isNoScript(err) {
+		return cmd, args, false
+	}
+	s := lookupScript(fmt.Sprint(args[0]))
+	if s == nil {
+		return cmd, args, false
+	}
+
+	list := make([]interface{}, len(args))
+	copy(list, args)
+	list[0] = s.src
+	return "EVAL", list, true
+}
+
+// 预加载已注册的脚本
+func preloadScripts(p *redis.Pool, service string, addr string) {
+	scripts.RLock()
+	list := append([]*Script(nil), scripts.list...)
+	scripts.RUnlock()
+	if len(list) == 0 {
+		return
+	}
+
+	conn := p.Get()
+	defer conn.Close()
+	for _, s := range list {
+		if _, err := conn.Do("SCRIPT", "LOAD", s.src); err != nil {
+			zlog.WarnLogger(nil, fmt.Sprintf("redis script preload %s %s error: %s", service, addr, err.Error()), zap.String("prot", "redis"))
+			return
+		}
+	}
+}
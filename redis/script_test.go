@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScript(t *testing.T) {
+	setup(t)
+
+	incr := NewScript(1, `return redis.call("INCRBY", KEYS[1], ARGV[1])`)
+	assert.Equal(t, incr, NewScript(1, `return redis.call("INCRBY", KEYS[1], ARGV[1])`))
+
+	_, _ = r.Do("DEL", "script")
+	// 首次执行时服务端可能未缓存，自动使用 EVAL
+	_, _ = r.Do("SCRIPT", "FLUSH")
+	n, err := redis.Int(r.Eval(incr, "script", 2))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = redis.Int(incr.Do(r, "script", 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+}
+
+// 服务端未缓存脚本时使用 EVAL 执行，之后使用 EVALSHA
+func TestScriptFallback(t *testing.T) {
+	var mu sync.Mutex
+	loaded := map[string]bool{}
+	s := newFakeServer(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch strings.ToUpper(args[0]) {
+		case "EVALSHA":
+			if !loaded[args[1]] {
+				return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+			}
+		case "EVAL":
+			sum := sha1.Sum([]byte(args[1]))
+			loaded[hex.EncodeToString(sum[:])] = true
+		default:
+			return "+OK\r\n"
+		}
+		return ":1\r\n"
+	})
+	defer s.Close()
+	addService(t, "script-fallback", s.addr, nil)
+	objRedis, err := GetInstance(nil, "script-fallback")
+	assert.NoError(t, err)
+	defer objRedis.Release()
+
+	incr := NewScript(1, `return redis.call("INCRBY", KEYS[1], ARGV[1]) -- fallback`)
+	for i := 0; i < 2; i++ {
+		n, err := redis.Int(objRedis.Eval(incr, "script", 2))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+
+	var cmds []string
+	for _, cmd := range s.commands() {
+		if strings.HasPrefix(cmd, "EVAL") {
+			cmds = append(cmds, strings.Fields(cmd)[0])
+		}
+	}
+	assert.Equal(t, []string{"EVALSHA", "EVAL", "EVALSHA"}, cmds)
+
+	// 未注册的脚本不使用 EVAL
+	_, err = objRedis.Do("EVALSHA", "0000000000000000000000000000000000000000", 0)
+	assert.True(t, isNoScript(err))
+}
+
+// 新增实例时预加载已注册的脚本
+func TestScriptPreload(t *testing.T) {
+	src := `return redis.call("GET", KEYS[1]) -- preload`
+	NewScript(1, src)
+	s := newFakeServer(t, func(args []string) string {
+		return bulkReply("sha")
+	})
+	defer s.Close()
+	addService(t, "script-preload", s.addr, nil)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, cmd := range s.commands() {
+			if cmd == "SCRIPT LOAD "+src {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("script not preloaded")
+}
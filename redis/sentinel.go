@@ -0,0 +1,194 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+const ( // 哨兵默认配置
+	// 订阅主节点切换消息的超时时间，超时后重新查询主节点
+	sentinelCheckInterval = 5 * time.Second
+	// 两次查询主节点的最小间隔
+	sentinelResolveMinInterval = time.Second
+	sentinelSwitchChannel      = "+switch-master"
+)
+
+// 哨兵模式下的主节点。通过哨兵查询主节点地址，订阅哨兵的主节点切换消息，
+// 并在订阅超时或主节点连接异常时重新查询，地址变化时切换连接池
+type sentinel struct {
+	res  *ral.Resource
+	addr string
+	// 哨兵的连接池
+	conns *redis.Pool
+
+	mu        sync.RWMutex
+	master    string
+	pool      *redis.Pool
+	resolveAt time.Time
+	resolving int32
+
+	stop chan struct{}
+}
+
+func newSentinel(res *ral.Resource, addr string) *sentinel {
+	s := &sentinel{
+		res:   res,
+		addr:  addr,
+		conns: newPool(res, addr, res.Redis.SentinelPassword, 0),
+		stop:  make(chan struct{}),
+	}
+	if err := s.resolve(); err != nil {
+		zlog.WarnLogger(nil, fmt.Sprintf("redis sentinel %s resolve master %s from %s error: %s", res.Name, res.Redis.MasterName, addr, err.Error()), zap.String("prot", "redis"))
+	}
+	go s.watch()
+	return s
+}
+
+// 返回当前主节点的连接池，未查询到主节点时返回 nil
+func (s *sentinel) current() *redis.Pool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pool
+}
+
+// 查询主节点地址，地址变化时切换连接池
+func (s *sentinel) resolve() error {
+	s.mu.Lock()
+	s.resolveAt = time.Now()
+	s.mu.Unlock()
+
+	conn := s.conns.Get()
+	defer conn.Close()
+	reply, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.res.Redis.MasterName))
+	if err == redis.ErrNil || (err == nil && len(reply) != 2) {
+		return SentinelMasterErr
+	} else if err != nil {
+		return err
+	}
+	s.switchTo(net.JoinHostPort(reply[0], reply[1]))
+	return nil
+}
+
+func (s *sentinel) switchTo(master string) {
+	s.mu.Lock()
+	if s.master == master || s.stopped() {
+		s.mu.Unlock()
+		return
+	}
+	old, from := s.pool, s.master
+	s.master = master
+	s.pool = newPool(s.res, master, s.res.Redis.Password, s.res.Redis.Database)
+	p := s.pool
+	s.mu.Unlock()
+
+	go preloadScripts(p, s.res.Name, master)
+	if old != nil {
+		// 使用中的连接在归还时关闭
+		closePool(old)
+		zlog.WarnLogger(nil, fmt.Sprintf("redis sentinel %s master switched %s -> %s", s.res.Name, from, master), zap.String("prot", "redis"))
+	}
+}
+
+// 主节点调用出错时异步重新查询，只读错误表示主节点已降级为从节点
+func (s *sentinel) failed(err error) {
+	if err == ral.ERR_BUDGET_EXHAUSTED || err == ral.ERR_CONTEXT_CANCELED {
+		return
+	}
+	if e, ok := err.(redis.Error); ok && !strings.HasPrefix(string(e), "READONLY") {
+		return
+	}
+
+	s.mu.RLock()
+	skip := time.Since(s.resolveAt) < sentinelResolveMinInterval
+	s.mu.RUnlock()
+	if skip || !atomic.CompareAndSwapInt32(&s.resolving, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.resolving, 0)
+		if err := s.resolve(); err != nil {
+			zlog.WarnLogger(nil, fmt.Sprintf("redis sentinel %s resolve master error: %s", s.res.Name, err.Error()), zap.String("prot", "redis"))
+		}
+	}()
+}
+
+func (s *sentinel) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// 订阅主节点切换消息，订阅断开或超时后重新查询主节点
+func (s *sentinel) watch() {
+	for !s.stopped() {
+		start := time.Now()
+		s.listen()
+		if s.stopped() {
+			return
+		}
+		if err := s.resolve(); err != nil {
+			zlog.WarnLogger(nil, fmt.Sprintf("redis sentinel %s resolve master from %s error: %s", s.res.Name, s.addr, err.Error()), zap.String("prot", "redis"))
+		}
+
+		// 哨兵不可用时避免频繁重连
+		if wait := sentinelResolveMinInterval - time.Since(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-s.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+func (s *sentinel) listen() {
+	conn := s.conns.Get()
+	defer conn.Close()
+
+	c := redis.PubSubConn{Conn: conn}
+	if err := c.Subscribe(sentinelSwitchChannel); err != nil {
+		return
+	}
+	for !s.stopped() {
+		switch v := c.ReceiveWithTimeout(sentinelCheckInterval).(type) {
+		case redis.Message:
+			// <master name> <old ip> <old port> <new ip> <new port>
+			f := strings.Fields(string(v.Data))
+			if len(f) == 5 && f[0] == s.res.Redis.MasterName {
+				s.switchTo(net.JoinHostPort(f[3], f[4]))
+			}
+		case error:
+			return
+		}
+	}
+}
+
+// 停止订阅并关闭连接池，订阅协程在超时后退出
+func (s *sentinel) close() {
+	s.mu.Lock()
+	if !s.stopped() {
+		close(s.stop)
+	}
+	p := s.pool
+	s.pool = nil
+	s.mu.Unlock()
+
+	if p != nil {
+		closePool(p)
+	}
+	closePool(s.conns)
+}
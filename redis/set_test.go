@@ -9,7 +9,7 @@ import (
 )
 
 func TestRedis_SAdd_SIsMember_SMembers(t *testing.T) {
-	setup()
+	setup(t)
 	key, values := "TestRedis_SAdd_SIsMember_SMembers", []string{"1", "2", "3", "3"}
 
 	r.Del(key)
@@ -31,7 +31,7 @@ func TestRedis_SAdd_SIsMember_SMembers(t *testing.T) {
 }
 
 func TestRedis_SRem_SCard(t *testing.T) {
-	setup()
+	setup(t)
 	key, values := "TestRedis_SAdd_SIsMember_SMembers", []string{"1", "2", "3", "3"}
 
 	r.Del(key)
@@ -52,7 +52,7 @@ func TestRedis_SRem_SCard(t *testing.T) {
 }
 
 func TestRedis_SPop_SRandMember(t *testing.T) {
-	setup()
+	setup(t)
 	key, values := "TestRedis_SAdd_SIsMember_SMembers", []string{"1", "2", "3"}
 
 	r.Del(key)
@@ -79,7 +79,7 @@ func TestRedis_SPop_SRandMember(t *testing.T) {
 
 }
 func TestSCard(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestSCard"
 	r.Del(key)
 	r.SAdd(key, "value")
@@ -93,7 +93,7 @@ func TestSCard(t *testing.T) {
 }
 
 func TestSPop(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestSPop"
 	r.Del(key)
 	r.SAdd(key, "value")
@@ -112,7 +112,7 @@ func TestSPop(t *testing.T) {
 }
 
 func TestSRem(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestSRem"
 	r.Del(key)
 	r.SAdd(key, "one", "two", "three")
@@ -126,7 +126,7 @@ func TestSRem(t *testing.T) {
 }
 
 func TestSScan(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestSScan"
 	r.Del(key)
 	r.SAdd(key, "one", "two", "three")
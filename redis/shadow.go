@@ -0,0 +1,141 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/GitHub121380/golib/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 不包含 key 的命令
+var noKeyCommands = map[string]bool{
+	"PING": true, "ECHO": true, "INFO": true, "TIME": true, "SELECT": true, "DBSIZE": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true, "UNWATCH": true, "SCAN": true,
+	"SCRIPT": true, "CLIENT": true, "CONFIG": true, "AUTH": true, "QUIT": true,
+	"CLUSTER": true, "ASKING": true, "ROLE": true, "SENTINEL": true,
+}
+
+// 参数全部为 key 的命令
+var allKeyCommands = map[string]bool{
+	"DEL": true, "UNLINK": true, "EXISTS": true, "TOUCH": true, "MGET": true, "WATCH": true,
+	"RENAME": true, "RENAMENX": true, "RPOPLPUSH": true, "PFCOUNT": true, "PFMERGE": true,
+	"SDIFF": true, "SINTER": true, "SUNION": true, "SDIFFSTORE": true, "SINTERSTORE": true, "SUNIONSTORE": true,
+}
+
+// 压测流量的 key 添加影子前缀，避免污染线上数据
+func shadowArgs(ctx *gin.Context, cmd string, args []interface{}) []interface{} {
+	if len(args) == 0 || !utils.IsPressure(ctx) {
+		return args
+	}
+
+	index := keyIndexes(cmd, args)
+	if len(index) == 0 {
+		return args
+	}
+	list := make([]interface{}, len(args))
+	copy(list, args)
+	for _, i := range index {
+		list[i] = shadowKey(list[i])
+	}
+	return list
+}
+
+// 返回命令参数中 key 的位置
+func keyIndexes(cmd string, args []interface{}) []int {
+	cmd = strings.ToUpper(cmd)
+	if len(args) == 0 || noKeyCommands[cmd] {
+		return nil
+	}
+
+	var index []int
+	switch {
+	case allKeyCommands[cmd]:
+		for i := range args {
+			index = append(index, i)
+		}
+	case cmd == "MSET" || cmd == "MSETNX":
+		for i := 0; i < len(args); i += 2 {
+			index = append(index, i)
+		}
+	case cmd == "BLPOP" || cmd == "BRPOP" || cmd == "BRPOPLPUSH":
+		// 最后一个参数为超时时间
+		for i := 0; i < len(args)-1; i++ {
+			index = append(index, i)
+		}
+	case cmd == "SMOVE":
+		for i := 0; i < len(args) && i < 2; i++ {
+			index = append(index, i)
+		}
+	case cmd == "XREAD" || cmd == "XREADGROUP":
+		// STREAMS 之后前一半参数为 key，后一半为消息ID
+		for i := range args {
+			if s, ok := args[i].(string); ok && strings.EqualFold(s, "STREAMS") {
+				for j := i + 1; j <= i+(len(args)-i-1)/2; j++ {
+					index = append(index, j)
+				}
+				break
+			}
+		}
+	case cmd == "XGROUP" || cmd == "XINFO":
+		// 子命令之后为 key
+		if len(args) > 1 {
+			index = append(index, 1)
+		}
+	case cmd == "EVAL" || cmd == "EVALSHA":
+		// EVAL script numkeys key [key ...] arg [arg ...]
+		if len(args) > 1 {
+			n, _ := strconv.Atoi(fmt.Sprint(args[1]))
+			for i := 2; i < len(args) && i < n+2; i++ {
+				index = append(index, i)
+			}
+		}
+	case cmd == "BITOP":
+		// BITOP operation destkey key [key ...]
+		for i := 1; i < len(args); i++ {
+			index = append(index, i)
+		}
+	case cmd == "ZUNIONSTORE" || cmd == "ZINTERSTORE":
+		// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS ...] [AGGREGATE ...]
+		index = append(index, 0)
+		if len(args) > 1 {
+			n, _ := strconv.Atoi(fmt.Sprint(args[1]))
+			for i := 2; i < len(args) && i < n+2; i++ {
+				index = append(index, i)
+			}
+		}
+	case cmd == "GEORADIUS" || cmd == "GEORADIUSBYMEMBER":
+		// GEORADIUS key longitude latitude radius unit ... [STORE key] [STOREDIST key]
+		index = append(index, 0)
+		start := 5
+		if cmd == "GEORADIUSBYMEMBER" {
+			start = 4
+		}
+		for i := start; i < len(args)-1; i++ {
+			if s, ok := args[i].(string); ok && (strings.EqualFold(s, "STORE") || strings.EqualFold(s, "STOREDIST")) {
+				i++
+				index = append(index, i)
+			}
+		}
+	default:
+		index = append(index, 0)
+	}
+	return index
+}
+
+func shadowKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case string:
+		if strings.HasPrefix(k, utils.PressureShadowPrefix) {
+			return k
+		}
+		return utils.PressureShadowPrefix + k
+	case []byte:
+		if strings.HasPrefix(string(k), utils.PressureShadowPrefix) {
+			return k
+		}
+		return append([]byte(utils.PressureShadowPrefix), k...)
+	}
+	return utils.PressureShadowPrefix + fmt.Sprint(key)
+}
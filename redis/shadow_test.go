@@ -0,0 +1,27 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyIndexes(t *testing.T) {
+	assert.Equal(t, []int{0}, keyIndexes("get", []interface{}{"k"}))
+	assert.Nil(t, keyIndexes("PING", []interface{}{"hello"}))
+	assert.Equal(t, []int{0, 2}, keyIndexes("MSET", []interface{}{"a", 1, "b", 2}))
+	assert.Equal(t, []int{2, 3}, keyIndexes("EVALSHA", []interface{}{"sha", 2, "a", "b", "arg"}))
+
+	// BITOP 的第一个参数为操作类型
+	assert.Equal(t, []int{1, 2, 3}, keyIndexes("BITOP", []interface{}{"AND", "dest", "a", "b"}))
+
+	// numkeys 之后为 key，其余为选项
+	assert.Equal(t, []int{0, 2, 3}, keyIndexes("ZUNIONSTORE", []interface{}{"dest", 2, "a", "b", "WEIGHTS", 1, 2}))
+	assert.Equal(t, []int{0, 2}, keyIndexes("zinterstore", []interface{}{"dest", "1", "a", "AGGREGATE", "MAX"}))
+
+	// STORE 及 STOREDIST 之后为 key
+	assert.Equal(t, []int{0}, keyIndexes("GEORADIUS", []interface{}{"geo", 116.4, 39.9, 10, "km", "WITHDIST"}))
+	assert.Equal(t, []int{0, 6, 8}, keyIndexes("GEORADIUS", []interface{}{"geo", 116.4, 39.9, 10, "km", "STORE", "a", "STOREDIST", "b"}))
+	// 成员名与选项相同时不视为选项
+	assert.Equal(t, []int{0, 5}, keyIndexes("GEORADIUSBYMEMBER", []interface{}{"geo", "STORE", 10, "km", "store", "a"}))
+}
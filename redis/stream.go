@@ -0,0 +1,463 @@
+package redis
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+// Stream 中的一条消息，消息已被删除时 Values 为 nil
+type StreamMessage struct {
+	ID     string
+	Values map[string][]byte
+}
+
+// 消费组中已投递未确认的消息
+type PendingMessage struct {
+	ID       string
+	Consumer string
+	// 距离上次投递的时间
+	Idle time.Duration
+	// 投递次数
+	Deliveries int64
+}
+
+// 追加消息，maxLen 大于0时近似裁剪到该长度，返回消息ID
+func (objRedis *Redis) XAdd(key string, maxLen int64, values map[string]interface{}) (string, error) {
+	args := []interface{}{key}
+	if maxLen > 0 {
+		args = append(args, "MAXLEN", "~", maxLen)
+	}
+	args = append(args, "*")
+
+	fields := make([]string, 0, len(values))
+	for f := range values {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		args = append(args, f, values[f])
+	}
+	return redis.String(objRedis.Do("XADD", args...))
+}
+
+func (objRedis *Redis) XLen(key string) (int64, error) {
+	return redis.Int64(objRedis.Do("XLEN", key))
+}
+
+func (objRedis *Redis) XDel(key string, ids ...string) (int64, error) {
+	return redis.Int64(objRedis.Do("XDEL", packArgs(key, ids)...))
+}
+
+func isNoGroup(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), "NOGROUP ")
+}
+
+// 创建消费组，stream 不存在时自动创建，消费组已存在时不返回错误。
+// start 为起始消息ID，"$" 只消费新消息，"0" 从头消费
+func (objRedis *Redis) XGroupCreate(key string, group string, start string) error {
+	_, err := objRedis.Do("XGROUP", "CREATE", key, group, start, "MKSTREAM")
+	if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// 以消费组方式读取消息。id 为 ">" 时读取未投递的新消息，其他值时读取本消费者该ID之后已投递未确认的消息。
+// block 大于0时等待新消息直到超时，超时返回空列表
+func (objRedis *Redis) XReadGroup(group string, consumer string, key string, id string, count int, block time.Duration) ([]StreamMessage, error) {
+	args := []interface{}{"GROUP", group, consumer}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	r := objRedis
+	if block > 0 {
+		args = append(args, "BLOCK", int64(block/time.Millisecond))
+		c := *objRedis
+		c.block = block
+		r = &c
+	}
+	args = append(args, "STREAMS", key, id)
+
+	streams, err := redis.Values(r.Do("XREADGROUP", args...))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, nil
+	}
+
+	// 只读取了一个 stream，回复为 [[key, [消息...]]]
+	stream, err := redis.Values(streams[0], nil)
+	if err != nil || len(stream) != 2 {
+		return nil, StreamReplyErr
+	}
+	return parseStreamMessages(stream[1])
+}
+
+// 确认消息，返回确认成功的数量
+func (objRedis *Redis) XAck(key string, group string, ids ...string) (int64, error) {
+	return redis.Int64(objRedis.Do("XACK", packArgs(key, group, ids)...))
+}
+
+// 查询消费组中ID在 start 至 end 之间的待确认消息，"-"、"+" 表示最小、最大ID。consumer 为空时查询所有消费者
+func (objRedis *Redis) XPending(key string, group string, start string, end string, count int, consumer string) ([]PendingMessage, error) {
+	args := []interface{}{key, group, start, end, count}
+	if consumer != "" {
+		args = append(args, consumer)
+	}
+	entries, err := redis.Values(objRedis.Do("XPENDING", args...))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	list := make([]PendingMessage, 0, len(entries))
+	for _, e := range entries {
+		var p PendingMessage
+		var idle int64
+		v, err := redis.Values(e, nil)
+		if err == nil {
+			_, err = redis.Scan(v, &p.ID, &p.Consumer, &idle, &p.Deliveries)
+		}
+		if err != nil {
+			return nil, StreamReplyErr
+		}
+		p.Idle = time.Duration(idle) * time.Millisecond
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// 将空闲超过 minIdle 的待确认消息转移给 consumer，返回转移成功的消息
+func (objRedis *Redis) XClaim(key string, group string, consumer string, minIdle time.Duration, ids ...string) ([]StreamMessage, error) {
+	args := packArgs(key, group, consumer, int64(minIdle/time.Millisecond), ids)
+	reply, err := objRedis.Do("XCLAIM", args...)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, nil
+	}
+	return parseStreamMessages(reply)
+}
+
+// 解析消息列表，每条消息为 [id, [field, value...]]
+func parseStreamMessages(reply interface{}) ([]StreamMessage, error) {
+	entries, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, StreamReplyErr
+	}
+
+	list := make([]StreamMessage, 0, len(entries))
+	for _, e := range entries {
+		// 已删除的消息
+		if e == nil {
+			continue
+		}
+		v, err := redis.Values(e, nil)
+		if err != nil || len(v) != 2 {
+			return nil, StreamReplyErr
+		}
+		id, err := redis.String(v[0], nil)
+		if err != nil {
+			return nil, StreamReplyErr
+		}
+
+		msg := StreamMessage{ID: id}
+		if v[1] != nil {
+			fields, err := redis.ByteSlices(v[1], nil)
+			if err != nil {
+				return nil, StreamReplyErr
+			}
+			msg.Values = make(map[string][]byte, len(fields)/2)
+			for i := 0; i+1 < len(fields); i += 2 {
+				msg.Values[string(fields[i])] = fields[i+1]
+			}
+		}
+		list = append(list, msg)
+	}
+	return list, nil
+}
+
+const ( // Stream 消费默认配置
+	defaultStreamCount   = 10
+	defaultStreamBlock   = 2 * time.Second
+	defaultStreamMinIdle = time.Minute
+	streamMaxBackoff     = 5 * time.Second
+)
+
+// Stream 消费组配置
+type StreamOptions struct {
+	// 每次读取的消息数
+	Count int
+	// 阻塞等待新消息的时间
+	Block time.Duration
+	// 待确认消息空闲超过该时间后由当前消费者认领并重新处理
+	MinIdle time.Duration
+	// 检查待确认消息的间隔，默认与 MinIdle 相同
+	ClaimInterval time.Duration
+	// 投递次数超过该值的消息不再处理，直接确认并记录日志，0 为不限制
+	MaxDeliveries int64
+	// 创建消费组时的起始消息ID，默认 "$" 只消费新消息
+	Start string
+}
+
+// Stream 消费组消费者。handler 返回 nil 时确认消息，返回错误或 panic 时消息保留在待确认列表中，
+// 空闲超过 MinIdle 后由组内消费者认领重新处理。启动时先处理本消费者重启前未确认的消息
+type StreamConsumer struct {
+	service  string
+	key      string
+	group    string
+	consumer string
+	opt      StreamOptions
+	handler  func(msg StreamMessage) error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// 创建消费者并开始消费，service 为 ral 中配置的 redis 服务名，consumer 为组内唯一的消费者名
+func NewStreamConsumer(service string, key string, group string, consumer string, opt StreamOptions, handler func(msg StreamMessage) error) *StreamConsumer {
+	if opt.Count <= 0 {
+		opt.Count = defaultStreamCount
+	}
+	if opt.Block <= 0 {
+		opt.Block = defaultStreamBlock
+	}
+	if opt.MinIdle <= 0 {
+		opt.MinIdle = defaultStreamMinIdle
+	}
+	if opt.ClaimInterval <= 0 {
+		opt.ClaimInterval = opt.MinIdle
+	}
+	if opt.Start == "" {
+		opt.Start = "$"
+	}
+
+	c := &StreamConsumer{
+		service:  service,
+		key:      key,
+		group:    group,
+		consumer: consumer,
+		opt:      opt,
+		handler:  handler,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// 停止消费，等待正在处理的消息完成
+func (c *StreamConsumer) Close() {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+}
+
+func (c *StreamConsumer) run() {
+	defer close(c.done)
+
+	// 本消费者已投递未确认消息的读取位置，读完后置空
+	pending := "0"
+	grouped := false
+	lastClaim := time.Time{}
+	backoff := time.Duration(0)
+	for !c.sleep(backoff) {
+		var err error
+		switch {
+		case !grouped:
+			err = c.do(func(r *Redis) error {
+				return r.XGroupCreate(c.key, c.group, c.opt.Start)
+			})
+			grouped = err == nil
+		case pending != "":
+			pending, err = c.recover(pending)
+		case time.Since(lastClaim) >= c.opt.ClaimInterval:
+			err, lastClaim = c.claim(), time.Now()
+		default:
+			err = c.read()
+		}
+		// stream 或消费组被删除后重新创建
+		if isNoGroup(err) {
+			grouped = false
+		}
+
+		if err == nil {
+			backoff = 0
+			continue
+		}
+		zlog.WarnLogger(nil, fmt.Sprintf("redis stream %s:%s group %s consume error: %s", c.service, c.key, c.group, err.Error()), zap.String("prot", "redis"))
+		if backoff *= 2; backoff == 0 {
+			backoff = subscribeMinBackoff
+		} else if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// 等待一段时间，返回是否已停止
+func (c *StreamConsumer) sleep(d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case <-c.stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.stop:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (c *StreamConsumer) do(fn func(r *Redis) error) error {
+	r, err := GetInstance(nil, c.service)
+	if err != nil {
+		return err
+	}
+	defer r.Release()
+	return fn(r)
+}
+
+// 读取新消息
+func (c *StreamConsumer) read() error {
+	var list []StreamMessage
+	err := c.do(func(r *Redis) (err error) {
+		list, err = r.XReadGroup(c.group, c.consumer, c.key, ">", c.opt.Count, c.opt.Block)
+		return err
+	})
+	c.handle(list)
+	return err
+}
+
+// 处理本消费者重启前已投递未确认的消息，返回下次读取的位置，处理完时返回空。
+// 读取失败时返回原位置，退避后重试
+func (c *StreamConsumer) recover(from string) (string, error) {
+	var list []StreamMessage
+	err := c.do(func(r *Redis) (err error) {
+		list, err = r.XReadGroup(c.group, c.consumer, c.key, from, c.opt.Count, 0)
+		return err
+	})
+	if err != nil {
+		return from, err
+	}
+	c.handle(list)
+	if len(list) < c.opt.Count {
+		return "", nil
+	}
+	return list[len(list)-1].ID, nil
+}
+
+// 按页认领组内空闲超过 MinIdle 的待确认消息并重新处理
+func (c *StreamConsumer) claim() error {
+	for start := "-"; start != "" && !c.sleep(0); {
+		var pending []PendingMessage
+		err := c.do(func(r *Redis) (err error) {
+			pending, err = r.XPending(c.key, c.group, start, "+", c.opt.Count, "")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if start = ""; len(pending) >= c.opt.Count {
+			start = nextStreamID(pending[len(pending)-1].ID)
+		}
+
+		var ids, drop []string
+		for _, p := range pending {
+			if p.Idle < c.opt.MinIdle {
+				continue
+			}
+			if c.opt.MaxDeliveries > 0 && p.Deliveries >= c.opt.MaxDeliveries {
+				drop = append(drop, p.ID)
+				continue
+			}
+			ids = append(ids, p.ID)
+		}
+
+		if len(drop) > 0 {
+			zlog.WarnLogger(nil, fmt.Sprintf("redis stream %s:%s group %s drop messages %s exceeded %d deliveries",
+				c.service, c.key, c.group, strings.Join(drop, ","), c.opt.MaxDeliveries), zap.String("prot", "redis"))
+			c.ack(drop)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		var list []StreamMessage
+		err = c.do(func(r *Redis) (err error) {
+			list, err = r.XClaim(c.key, c.group, c.consumer, c.opt.MinIdle, ids...)
+			return err
+		})
+		c.handle(list)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 返回大于 id 的最小消息ID，消息ID格式为 毫秒时间戳-序号
+func nextStreamID(id string) string {
+	i := strings.LastIndex(id, "-")
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if i < 0 || err != nil {
+		return ""
+	}
+	return id[:i+1] + strconv.FormatUint(seq+1, 10)
+}
+
+// 依次处理消息，处理成功及已删除的消息需确认
+func (c *StreamConsumer) handle(list []StreamMessage) {
+	var ids []string
+	for _, msg := range list {
+		if msg.Values == nil || c.dispatch(msg) == nil {
+			ids = append(ids, msg.ID)
+		}
+	}
+	c.ack(ids)
+}
+
+func (c *StreamConsumer) dispatch(msg StreamMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			zlog.ErrorLogger(nil, fmt.Sprintf("redis stream %s:%s handle %s panic: %v", c.service, c.key, msg.ID, r), zap.String("prot", "redis"))
+		}
+	}()
+	return c.handler(msg)
+}
+
+func (c *StreamConsumer) ack(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	err := c.do(func(r *Redis) error {
+		_, err := r.XAck(c.key, c.group, ids...)
+		return err
+	})
+	if err != nil {
+		zlog.WarnLogger(nil, fmt.Sprintf("redis stream %s:%s group %s ack error: %s", c.service, c.key, c.group, err.Error()), zap.String("prot", "redis"))
+	}
+}
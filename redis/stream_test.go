@@ -0,0 +1,187 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	setup(t)
+
+	_, _ = r.Do("DEL", "stream")
+	assert.NoError(t, r.XGroupCreate("stream", "group", "0"))
+	// 消费组已存在时不返回错误
+	assert.NoError(t, r.XGroupCreate("stream", "group", "0"))
+
+	id, err := r.XAdd("stream", 100, map[string]interface{}{"n": 1})
+	assert.NoError(t, err)
+
+	list, err := r.XReadGroup("group", "c1", "stream", ">", 10, 100*time.Millisecond)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, id, list[0].ID)
+		assert.Equal(t, "1", string(list[0].Values["n"]))
+	}
+
+	// 未确认的消息可被其他消费者认领
+	pending, err := r.XPending("stream", "group", "-", "+", 10, "")
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "c1", pending[0].Consumer)
+		assert.Equal(t, int64(1), pending[0].Deliveries)
+	}
+	list, err = r.XClaim("stream", "group", "c2", 0, id)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	n, err := r.XAck("stream", "group", id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// 阻塞读取超时返回空列表
+	list, err = r.XReadGroup("group", "c1", "stream", ">", 10, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Len(t, list, 0)
+}
+
+func TestSubscriber(t *testing.T) {
+	setup(t)
+
+	ch := make(chan Message, 1)
+	s := NewSubscriber(ServiceName, func(msg Message) {
+		ch <- msg
+	})
+	defer s.Close()
+	assert.NoError(t, s.Subscribe("subscriber"))
+
+	// 等待订阅生效
+	for i := 0; i < 10; i++ {
+		if n, _ := r.Publish("subscriber", "hello"); n > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case msg := <-ch:
+		assert.Equal(t, "subscriber", msg.Channel)
+		assert.Equal(t, "hello", string(msg.Data))
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestNextStreamID(t *testing.T) {
+	assert.Equal(t, "1-1", nextStreamID("1-0"))
+	assert.Equal(t, "1526919030474-56", nextStreamID("1526919030474-55"))
+	assert.Equal(t, "", nextStreamID("invalid"))
+}
+
+// 只包含一条消息的 XREADGROUP 回复
+func streamReply(key string, id string, field string, value string) string {
+	msg := arrayReply(bulkReply(id), arrayReply(bulkReply(field), bulkReply(value)))
+	return arrayReply(arrayReply(bulkReply(key), arrayReply(msg)))
+}
+
+func TestStreamErrors(t *testing.T) {
+	s := newFakeServer(t, func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "XGROUP":
+			return "-BUSYGROUP Consumer Group name already exists\r\n"
+		case "XREADGROUP":
+			return "-ERR connection lost\r\n"
+		}
+		return "+OK\r\n"
+	})
+	defer s.Close()
+	addService(t, "stream-errors", s.addr, nil)
+	objRedis, err := GetInstance(nil, "stream-errors")
+	assert.NoError(t, err)
+	defer objRedis.Release()
+
+	// 消费组已存在时不返回错误，其他错误正常返回
+	assert.NoError(t, objRedis.XGroupCreate("stream", "group", "$"))
+	list, err := objRedis.XReadGroup("group", "c1", "stream", "0", 10, 0)
+	assert.EqualError(t, err, "ERR connection lost")
+	assert.Nil(t, list)
+}
+
+func TestStreamConsumer(t *testing.T) {
+	var mu sync.Mutex
+	var creates, empty int
+	var acked []string
+	recoverFails, noGroup := 1, 1
+	s := newFakeServer(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch strings.ToUpper(args[0]) {
+		case "XGROUP":
+			if creates++; creates > 1 {
+				return "-BUSYGROUP Consumer Group name already exists\r\n"
+			}
+		case "XREADGROUP":
+			if args[len(args)-1] == "0" {
+				// 读取待确认消息失败后重试
+				if recoverFails > 0 {
+					recoverFails--
+					return "-ERR connection lost\r\n"
+				}
+				return streamReply("stream", "1-0", "n", "1")
+			}
+			// 消费组被删除后重新创建
+			if noGroup > 0 {
+				noGroup--
+				return "-NOGROUP No such key 'stream' or consumer group 'group'\r\n"
+			}
+			if creates == 2 && len(acked) == 1 {
+				return streamReply("stream", "2-0", "n", "2")
+			}
+			// 未等待即返回的空结果不视为错误
+			empty++
+			return "*-1\r\n"
+		case "XACK":
+			acked = append(acked, args[3:]...)
+			return fmt.Sprintf(":%d\r\n", len(args)-3)
+		case "XPENDING":
+			return "*0\r\n"
+		}
+		return "+OK\r\n"
+	})
+	defer s.Close()
+	addService(t, "stream-consumer", s.addr, nil)
+
+	received := make(chan string, 10)
+	c := NewStreamConsumer("stream-consumer", "stream", "group", "c1", StreamOptions{ClaimInterval: time.Hour}, func(msg StreamMessage) error {
+		received <- msg.ID + ":" + string(msg.Values["n"])
+		return nil
+	})
+	for _, want := range []string{"1-0:1", "2-0:2"} {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got)
+		case <-time.After(2 * time.Second):
+			t.Fatal("message not received:", want)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := empty
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, empty >= 3)
+	assert.Equal(t, 2, creates)
+	assert.Equal(t, []string{"1-0", "2-0"}, acked)
+}
@@ -8,7 +8,7 @@ import (
 )
 
 func TestRedisSet_GET(t *testing.T) {
-	setup()
+	setup(t)
 	key, value := "TestRedis_Set_GET_Key", "TestRedis_Set_GET_Value"
 	_, err := r.Del(key)
 	assert.NoError(t, err)
@@ -23,7 +23,7 @@ func TestRedisSet_GET(t *testing.T) {
 }
 
 func TestRedisSet_Empty(t *testing.T) {
-	setup()
+	setup(t)
 	key, value := "TestRedis_Set_Empty", ""
 	_, err := r.Del(key)
 	assert.NoError(t, err)
@@ -38,7 +38,7 @@ func TestRedisSet_Empty(t *testing.T) {
 }
 
 func TestRedis_SetEx(t *testing.T) {
-	setup()
+	setup(t)
 	key, value := "TestRedis_Set_GET_Key", "TestRedis_Set_GET_Value"
 	expire := int64(5)
 	_, err := r.Del(key)
@@ -55,7 +55,7 @@ func TestRedis_SetEx(t *testing.T) {
 }
 
 func TestRedis_MSet_MGet(t *testing.T) {
-	setup()
+	setup(t)
 	keys, values := []string{"TestRedis_MSet_MGet_K1", "TestRedis_MSet_MGet_K2"}, []string{"TestRedis_MSet_MGet_V1", "TestRedis_MSet_MGet_V2"}
 	_, _ = r.Del(keys[0], keys[1])
 	time.Sleep(100 * time.Millisecond)
@@ -80,7 +80,7 @@ func TestRedis_MSet_MGet(t *testing.T) {
 }
 
 func TestRedis_Incr_IncrBy_IncrByFloat(t *testing.T) {
-	setup()
+	setup(t)
 	incrKey := "TestRedis_Incr"
 	incrByKey := "TestRedis_IncrBy"
 	incrByFloatKey := "TestRedis_IncrByFloat"
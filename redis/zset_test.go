@@ -9,7 +9,7 @@ import (
 )
 
 func TestRedis_ZAdd_ZCard_ZRange_ZRevRange(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZAdd_ZCard_ZRange_ZRevRange"
 	pairs := map[string]float64{
 		"one":   1.0,
@@ -67,7 +67,7 @@ func TestRedis_ZAdd_ZCard_ZRange_ZRevRange(t *testing.T) {
 }
 
 func TestRedis_ZRangeByScore_ZRevRangeByScore_ZCount(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZRangeByScore_ZRevRangeByScore_ZCount"
 	pairs := map[string]float64{
 		"one":   1.0,
@@ -115,7 +115,7 @@ func TestRedis_ZRangeByScore_ZRevRangeByScore_ZCount(t *testing.T) {
 }
 
 func TestRedis_ZRank_ZRevRank(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZRank_ZRevRank"
 	pairs := map[string]float64{
 		"one":   1.0,
@@ -140,7 +140,7 @@ func TestRedis_ZRank_ZRevRank(t *testing.T) {
 }
 
 func TestRedis_ZRem_ZRemRangeByRank_ZRemRangeByScore(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZRem_ZRemRangeByRank_ZRemRangeByScore"
 	pairs := map[string]float64{
 		"one":   1.0,
@@ -171,7 +171,7 @@ func TestRedis_ZRem_ZRemRangeByRank_ZRemRangeByScore(t *testing.T) {
 }
 
 func TestZIncrBy(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZIncrBy"
 	pairs := map[string]float64{
 		"one":   1.0,
@@ -192,7 +192,7 @@ func TestZIncrBy(t *testing.T) {
 }
 
 func TestZLexCount(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZLexCount"
 	pairs := map[string]float64{
 		"one":   1.0,
@@ -213,7 +213,7 @@ func TestZLexCount(t *testing.T) {
 }
 
 func TestRedis_ZRemRangeByLex(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZRemRangeByLex"
 	pairs := map[string]float64{
 		"v1":  1.0,
@@ -233,7 +233,7 @@ func TestRedis_ZRemRangeByLex(t *testing.T) {
 }
 
 func TestRedis_ZScore(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZScore"
 	pairs := map[string]float64{
 		"one":   1.0,
@@ -255,7 +255,7 @@ func TestRedis_ZScore(t *testing.T) {
 }
 
 func TestRedis_ZScan(t *testing.T) {
-	setup()
+	setup(t)
 	key := "TestRedis_ZScan"
 	pairs := map[string]float64{
 		"one":   1.0,
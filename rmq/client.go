@@ -8,10 +8,14 @@ import (
 	"os"
 	"sync"
 
+	"github.com/GitHub121380/golib/metrics"
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/utils"
 	"github.com/GitHub121380/golib/zlog"
 	"github.com/GitHub121380/golib/zns"
 	"github.com/apache/rocketmq-client-go/v2/primitive"
 	"github.com/apache/rocketmq-client-go/v2/rlog"
+	"github.com/gin-gonic/gin"
 )
 
 // auth 提供链接到Broker所需要的验证信息（按需配置）
@@ -54,6 +58,20 @@ type client struct {
 	namingListener net.Listener
 }
 
+// 客户端状态，用于调试接口输出
+func (c *client) status() interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"group":         c.ClientConfig.Group,
+		"topic":         c.ClientConfig.Topic,
+		"nameServers":   c.ClientConfig.NameServers,
+		"nameServerZns": c.ClientConfig.NameServerZNS,
+		"producer":      c.producer != nil,
+		"consumer":      c.pushConsumer != nil,
+	}
+}
+
 func (c *client) startNamingHandler() error {
 	var err error
 	c.namingListener, err = net.Listen("tcp", "127.0.0.1:0")
@@ -202,6 +220,7 @@ type Message interface {
 	WithTag(string) Message
 	WithShard(string) Message
 	WithDelay(DelayLevel) Message
+	WithContext(*gin.Context) Message
 	Send() (msgID string, err error)
 	GetContent() []byte
 	GetTag() string
@@ -233,6 +252,18 @@ func (m *messageWrapper) WithDelay(lvl DelayLevel) Message {
 	return m
 }
 
+// WithContext 透传当前请求的染色标记及压测标记，压测消息发送到影子topic
+func (m *messageWrapper) WithContext(ctx *gin.Context) Message {
+	if color := ral.Color(ctx); color != "" {
+		m.msg.WithProperty(ral.HEAD_COLOR, color)
+	}
+	if callerURI, _ := utils.GetPressureFlag(ctx); callerURI != "" {
+		m.msg.WithProperty(utils.HttpXBDCallerURI, callerURI)
+	}
+	m.msg.Topic = utils.ShadowName(ctx, m.msg.Topic)
+	return m
+}
+
 // Send 发送消息
 func (m *messageWrapper) Send() (msgID string, err error) {
 	if m.client == nil {
@@ -246,7 +277,9 @@ func (m *messageWrapper) Send() (msgID string, err error) {
 		zlog.WarnLogger(nil, "producer not started")
 		return "", ErrRmqSvcInvalidOperation
 	}
+	done := metrics.Client("rmq", m.client.Service, m.msg.Topic)
 	queue, id, offset, err := m.client.producer.SendMessage(m.msg)
+	done(err)
 	if err != nil {
 		zlog.ErrorLogger(nil, "failed to send message",
 			zap.String("error", err.Error()),
@@ -4,6 +4,8 @@ package rmq
 import (
 	"context"
 	"fmt"
+	"github.com/GitHub121380/golib/metrics"
+	"github.com/GitHub121380/golib/ral"
 	"github.com/GitHub121380/golib/utils"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -62,6 +64,7 @@ func InitRmq(service string, config ClientConfig) (err error) {
 	}
 
 	rmqServices[service] = clnt
+	metrics.RegisterClient("rmq", service, clnt.status)
 	return nil
 }
 
@@ -103,6 +106,7 @@ func Register(service, configFile string) (err error) {
 	}
 
 	rmqServices[service] = clnt
+	metrics.RegisterClient("rmq", service, clnt.status)
 	return nil
 }
 
@@ -174,17 +178,24 @@ func StartConsumer(g *gin.Engine, service string, tags []string, callback Messag
 					return consumer.SuspendCurrentQueueAMoment, ctx.Err()
 				}
 				ctx := gin.CreateNewContext(g)
+				if color := m.GetProperty(ral.HEAD_COLOR); color != "" {
+					ral.SetColor(ctx, color)
+				}
+				utils.SetPressureFlag(ctx, m.GetProperty(utils.HttpXBDCallerURI))
+				done := metrics.Server("rmq")
 				err := callback(ctx, &messageWrapper{
 					msg:      &m.Message,
 					offsetID: m.OffsetMsgId,
 				})
 				if err != nil {
+					done("consume", m.Topic, metrics.CodeError)
 					zlog.WarnLogger(ctx, "failed to consume message",
 						zap.String("message", m.String()),
 						zap.String("error", err.Error()))
 					gin.RecycleContext(g, ctx)
 					return consumer.SuspendCurrentQueueAMoment, nil
 				}
+				done("consume", m.Topic, metrics.CodeOK)
 				gin.RecycleContext(g, ctx)
 			}
 			return consumer.ConsumeSuccess, nil
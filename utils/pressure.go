@@ -13,8 +13,18 @@ const (
 	HttpUrlPressureMarkKey   = "_press_mark"
 )
 
+// 压测流量使用的影子资源前缀，redis key、kafka/rmq topic 均添加该前缀
+const PressureShadowPrefix = "shadow_"
+
+// 压测流量的 caller uri 包含该标记
+const PressureCallerURI = "/qa/test"
+
 func GetPressureFlag(ctx *gin.Context) (callerURI string, pressMark int) {
-	if ctx != nil && ctx.Request != nil {
+	if ctx != nil {
+		// 消息队列等没有 header 的请求，压测标记保存在 context 中
+		callerURI = ctx.GetString(HttpXBDCallerURI)
+	}
+	if callerURI == "" && ctx != nil && ctx.Request != nil {
 		callerURI = ctx.GetHeader(HttpXBDCallerURI)
 		if callerURI == "" {
 			callerURI = ctx.GetHeader(HttpXBDCallerURIV2)
@@ -22,9 +32,30 @@ func GetPressureFlag(ctx *gin.Context) (callerURI string, pressMark int) {
 	}
 
 	pressMark = 0
-	if callerURI != "" && strings.Contains(callerURI, "/qa/test") {
+	if callerURI != "" && strings.Contains(callerURI, PressureCallerURI) {
 		pressMark = 1
 	}
 
 	return callerURI, pressMark
 }
+
+// 设置当前请求的压测标记，用于从消息队列等非 http 请求中恢复压测标记
+func SetPressureFlag(ctx *gin.Context, callerURI string) {
+	if ctx != nil && callerURI != "" {
+		ctx.Set(HttpXBDCallerURI, callerURI)
+	}
+}
+
+// 当前请求是否为压测流量
+func IsPressure(ctx *gin.Context) bool {
+	_, mark := GetPressureFlag(ctx)
+	return mark == 1
+}
+
+// 压测流量返回影子资源名，否则返回原名
+func ShadowName(ctx *gin.Context, name string) string {
+	if !IsPressure(ctx) || strings.HasPrefix(name, PressureShadowPrefix) {
+		return name
+	}
+	return PressureShadowPrefix + name
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPressureFlag(t *testing.T) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/", nil)
+	if IsPressure(ctx) || ShadowName(ctx, "topic") != "topic" {
+		t.Fatal("normal request marked as pressure")
+	}
+
+	ctx.Request.Header.Set(HttpXBDCallerURI, "/qa/test/a")
+	if !IsPressure(ctx) {
+		t.Fatal("pressure header not detected")
+	}
+	if name := ShadowName(ctx, "topic"); name != PressureShadowPrefix+"topic" {
+		t.Fatalf("shadow name %s", name)
+	}
+	if name := ShadowName(ctx, PressureShadowPrefix+"topic"); name != PressureShadowPrefix+"topic" {
+		t.Fatalf("shadow name prefixed twice: %s", name)
+	}
+
+	// 消息队列消费时没有 header，从 context 恢复
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	SetPressureFlag(c, "/qa/test/b")
+	if uri, mark := GetPressureFlag(c); uri != "/qa/test/b" || mark != 1 {
+		t.Fatalf("flag not restored: %s %d", uri, mark)
+	}
+}
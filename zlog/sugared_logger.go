@@ -25,10 +25,12 @@ func sugaredLogger(ctx *gin.Context) *zap.SugaredLogger {
 	return ServerLogger.With(
 		zap.String("logId", GetLogID(ctx)),
 		zap.String("spanId", GetSpanID(ctx)),
+		zap.String("traceId", GetTraceID(ctx)),
 		zap.String("requestId", GetRequestID(ctx)),
 		zap.String("module", env.AppName),
 		zap.String("localIp", env.LocalIP),
 		zap.String("handler", utils.GetHandler(ctx)), // todo: 后面优化去掉
+		zap.Int("press", pressMark(ctx)),
 	)
 }
 
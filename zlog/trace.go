@@ -0,0 +1,300 @@
+package zlog
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GitHub121380/golib/env"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ContextKeySpan         = "_span"
+	ContextKeyParentSpanID = "parentSpanID"
+	ContextKeyChildSpanID  = "childSpanID"
+)
+
+// 链路透传使用的header
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderXBDLogID    = "x_bd_logid"
+	HeaderXBDSpanID   = "x_bd_spanid"
+)
+
+const (
+	traceparentVersion = "00"
+	traceparentSampled = "01"
+	LogNameTrace       = "trace"
+)
+
+// Span 记录一次调用的链路信息
+type Span struct {
+	TraceID  string `json:"traceId"`
+	SpanID   string `json:"spanId"`
+	ParentID string `json:"parentId"`
+	// 兼容北斗的spanID，形如 0.1.2
+	LegacyID string `json:"bdSpanId"`
+	LogID    string `json:"logId"`
+	Name     string `json:"name"`
+
+	StartTime time.Time              `json:"startTime"`
+	EndTime   time.Time              `json:"endTime"`
+	Tags      map[string]interface{} `json:"tags"`
+
+	mu       sync.Mutex
+	finished bool
+}
+
+// SetTag 为span添加标签，span结束后不再生效
+func (s *Span) SetTag(key string, val interface{}) *Span {
+	if s == nil {
+		return s
+	}
+	s.mu.Lock()
+	if !s.finished {
+		s.Tags[key] = val
+	}
+	s.mu.Unlock()
+	return s
+}
+
+// SetError 记录调用错误，err为nil时不做处理
+func (s *Span) SetError(err error) *Span {
+	if err != nil {
+		s.SetTag("error", err.Error())
+	}
+	return s
+}
+
+// Finish 结束span并交给exporter输出，多次调用只输出一次
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.finished = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	if e := getSpanExporter(); e != nil {
+		e.Export(s)
+	}
+}
+
+// Traceparent 返回W3C traceparent格式的链路信息
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join([]string{traceparentVersion, s.TraceID, s.SpanID, traceparentSampled}, "-")
+}
+
+// Inject 将链路信息写入下游请求的header
+func (s *Span) Inject(head map[string]string) {
+	if s == nil || head == nil {
+		return
+	}
+	head[HeaderTraceparent] = s.Traceparent()
+	head[HeaderXBDSpanID] = s.LegacyID
+	head[HeaderXBDLogID] = s.LogID
+}
+
+// StartServerSpan 根据上游透传的链路信息开启当前请求的入口span，get 用于读取上游透传的header
+func StartServerSpan(ctx *gin.Context, name string, get func(key string) string) *Span {
+	if get == nil {
+		get = func(string) string { return "" }
+	}
+
+	// 上游透传的logid优先
+	if ctx != nil && ctx.GetString(ContextKeyLogID) == "" {
+		if logID := strings.TrimSpace(get(HeaderXBDLogID)); logID != "" {
+			ctx.Set(ContextKeyLogID, logID)
+		}
+	}
+
+	legacyID := strings.TrimSpace(get(HeaderXBDSpanID))
+	if legacyID == "" {
+		legacyID = "0"
+	}
+
+	span := newSpan(name)
+	if traceID, parentID, ok := ParseTraceparent(get(HeaderTraceparent)); ok {
+		span.TraceID, span.ParentID = traceID, parentID
+	}
+	span.LegacyID = legacyID
+	span.LogID = GetLogID(ctx)
+
+	if ctx != nil {
+		ctx.Set(ContextKeyParentSpanID, legacyID)
+		ctx.Set(ContextKeyChildSpanID, 0)
+		ctx.Set(ContextKeySpan, span)
+	}
+	return span
+}
+
+// StartSpan 以当前请求的入口span为父节点开启一个子span，用于记录对下游的调用
+func StartSpan(ctx *gin.Context, name string) *Span {
+	span := newSpan(name)
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID, span.ParentID = parent.TraceID, parent.SpanID
+	}
+	span.LegacyID = CreateSpan(ctx)
+	span.LogID = GetLogID(ctx)
+	return span
+}
+
+// SpanFromContext 获取当前请求的入口span
+func SpanFromContext(ctx *gin.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	if v, ok := ctx.Get(ContextKeySpan); ok {
+		span, _ := v.(*Span)
+		return span
+	}
+	return nil
+}
+
+// GetTraceID 获取当前请求的traceId
+func GetTraceID(ctx *gin.Context) string {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.TraceID
+	}
+	return ""
+}
+
+// ParseTraceparent 解析W3C traceparent: version-traceid-parentid-flags
+func ParseTraceparent(v string) (traceID string, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false
+	}
+	if !isHexID(parts[1], 32) || !isHexID(parts[2], 16) {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func isHexID(id string, size int) bool {
+	if len(id) != size || strings.Trim(id, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func newSpan(name string) *Span {
+	return &Span{
+		TraceID:   newID(16),
+		SpanID:    newID(8),
+		Name:      name,
+		StartTime: time.Now(),
+		Tags:      map[string]interface{}{},
+	}
+}
+
+var (
+	idMu   sync.Mutex
+	idRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func newID(size int) string {
+	b := make([]byte, size)
+	idMu.Lock()
+	_, _ = idRand.Read(b)
+	idMu.Unlock()
+	return hex.EncodeToString(b)
+}
+
+// SpanExporter 负责输出已结束的span
+type SpanExporter interface {
+	Export(span *Span)
+}
+
+var (
+	exporterMu   sync.RWMutex
+	exporterSet  bool
+	spanExporter SpanExporter
+)
+
+// SetSpanExporter 替换默认的span输出方式，传入nil则不输出
+func SetSpanExporter(e SpanExporter) {
+	exporterMu.Lock()
+	spanExporter, exporterSet = e, true
+	exporterMu.Unlock()
+}
+
+func getSpanExporter() SpanExporter {
+	exporterMu.RLock()
+	e, set := spanExporter, exporterSet
+	exporterMu.RUnlock()
+	if set {
+		return e
+	}
+
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	if !exporterSet {
+		spanExporter, exporterSet = NewFileSpanExporter(LogNameTrace), true
+	}
+	return spanExporter
+}
+
+// FileSpanExporter 以JSON行的形式将span写入日志目录下的 name.log
+type FileSpanExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewFileSpanExporter(name string) *FileSpanExporter {
+	return &FileSpanExporter{w: NewTimeFileLogWriter(genFilename(name, txtLogNormal))}
+}
+
+// NewWriterSpanExporter 将span输出到指定的writer
+func NewWriterSpanExporter(w io.Writer) *FileSpanExporter {
+	return &FileSpanExporter{w: w}
+}
+
+type spanRecord struct {
+	*Span
+	StartTime string  `json:"startTime"`
+	EndTime   string  `json:"endTime"`
+	Cost      float64 `json:"cost"`
+	Module    string  `json:"module"`
+	LocalIP   string  `json:"localIp"`
+}
+
+func (e *FileSpanExporter) Export(span *Span) {
+	if e == nil || e.w == nil || span == nil {
+		return
+	}
+
+	span.mu.Lock()
+	buf, err := json.Marshal(spanRecord{
+		Span:      span,
+		StartTime: span.StartTime.Format("2006-01-02 15:04:05.000000"),
+		EndTime:   span.EndTime.Format("2006-01-02 15:04:05.000000"),
+		Cost:      float64(span.EndTime.Sub(span.StartTime).Nanoseconds()/1e4) / 100.0,
+		Module:    env.AppName,
+		LocalIP:   env.LocalIP,
+	})
+	span.mu.Unlock()
+	if err != nil {
+		WarnLogger(nil, fmt.Sprintf("export span error: %s", err.Error()))
+		return
+	}
+
+	e.mu.Lock()
+	_, _ = e.w.Write(append(buf, '\n'))
+	e.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package zlog
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parentID, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, "00f067aa0ba902b7", parentID)
+
+	for _, v := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01",
+	} {
+		_, _, ok := ParseTraceparent(v)
+		assert.False(t, ok, v)
+	}
+}
+
+func TestSpanPropagation(t *testing.T) {
+	buf := &bytes.Buffer{}
+	SetSpanExporter(NewWriterSpanExporter(buf))
+	defer SetSpanExporter(nil)
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/ping", nil)
+	ctx.Request.Header.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx.Request.Header.Set(HeaderXBDSpanID, "0.3")
+	ctx.Request.Header.Set(HeaderXBDLogID, "123456")
+
+	server := StartServerSpan(ctx, "/ping", ctx.GetHeader)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentID)
+	assert.Equal(t, "0.3", server.LegacyID)
+	assert.Equal(t, "123456", GetLogID(ctx))
+
+	child := StartSpan(ctx, "http:downstream")
+	assert.Equal(t, server.TraceID, child.TraceID)
+	assert.Equal(t, server.SpanID, child.ParentID)
+	assert.Equal(t, "0.3.1", child.LegacyID)
+	assert.Equal(t, "0.3.2", CreateSpan(ctx))
+
+	head := map[string]string{}
+	child.Inject(head)
+	assert.Equal(t, "00-"+server.TraceID+"-"+child.SpanID+"-01", head[HeaderTraceparent])
+	assert.Equal(t, "0.3.1", head[HeaderXBDSpanID])
+	assert.Equal(t, "123456", head[HeaderXBDLogID])
+
+	child.SetTag("service", "downstream").Finish()
+	child.Finish()
+	server.Finish()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"service":"downstream"`)
+	assert.Contains(t, lines[1], `"name":"/ping"`)
+}
@@ -2,6 +2,7 @@ package zlog
 
 import (
 	"fmt"
+	"github.com/GitHub121380/golib/utils"
 	"github.com/GitHub121380/golib/utils/metadata"
 	"github.com/gin-gonic/gin"
 	"strconv"
@@ -26,6 +27,12 @@ func GetRequestID(ctx *gin.Context) string {
 	return ""
 }
 
+// 压测流量标记，压测请求的日志 press 字段为1
+func pressMark(ctx *gin.Context) int {
+	_, mark := utils.GetPressureFlag(ctx)
+	return mark
+}
+
 // web 请求 兼容odp生成logid方式
 func GetLogID(ctx *gin.Context) string {
 	if ctx != nil {
@@ -55,12 +62,35 @@ func GetLogID(ctx *gin.Context) string {
 	return logID
 }
 
+// 生成一个下游调用的spanID，形如 parentSpanID.childSpanID
 func CreateSpan(ctx *gin.Context) string {
-	return ""
+	if ctx == nil {
+		return ""
+	}
+	parentSpanID := SetSpanID(ctx)
+	childSpanID := ctx.GetInt(ContextKeyChildSpanID) + 1
+	ctx.Set(ContextKeyChildSpanID, childSpanID)
+	return fmt.Sprintf("%s.%d", parentSpanID, childSpanID)
 }
 
+// 从上游请求头中初始化当前请求的spanID，已初始化则直接返回
 func SetSpanID(ctx *gin.Context) string {
-	return ""
+	if ctx == nil {
+		return ""
+	}
+	if spanID := ctx.GetString(ContextKeyParentSpanID); spanID != "" {
+		return spanID
+	}
+
+	spanID := "0"
+	if ctx.Request != nil {
+		if s := strings.TrimSpace(ctx.GetHeader(HeaderXBDSpanID)); s != "" {
+			spanID = s
+		}
+	}
+	ctx.Set(ContextKeyParentSpanID, spanID)
+	ctx.Set(ContextKeyChildSpanID, 0)
+	return spanID
 }
 
 // 兼容北斗的spanID生成方式
@@ -68,8 +98,8 @@ func GetSpanID(ctx *gin.Context) string {
 	if ctx == nil {
 		return ""
 	}
-	parentSpanID := ctx.GetString("parentSpanID")
-	childSpanID := ctx.GetInt("childSpanID")
+	parentSpanID := ctx.GetString(ContextKeyParentSpanID)
+	childSpanID := ctx.GetInt(ContextKeyChildSpanID)
 	newSpan := fmt.Sprintf("%s.%d", parentSpanID, childSpanID)
 	return newSpan
 }
@@ -30,10 +30,12 @@ func zapLogger(ctx *gin.Context) *zap.Logger {
 	}
 	return m.With(zap.String("logId", GetLogID(ctx)),
 		zap.String("spanId", GetSpanID(ctx)),
+		zap.String("traceId", GetTraceID(ctx)),
 		zap.String("requestId", GetRequestID(ctx)),
 		zap.String("module", env.GetAppName()),
 		zap.String("localIp", env.LocalIP),
 		zap.String("handler", utils.GetHandler(ctx)), // todo: 后面优化去掉
+		zap.Int("press", pressMark(ctx)),
 	)
 }
 
@@ -0,0 +1,301 @@
+package bns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/GitHub121380/golib/zns/util"
+	"github.com/golang/protobuf/proto"
+	"gopkg.in/yaml.v2"
+)
+
+// 实例状态，0 为正常，其余状态的实例不会被 zns 使用
+const (
+	StatusOK      = 0
+	StatusOffline = -1
+)
+
+// 本地命名服务中的实例
+type ServerInstance struct {
+	IP       string `yaml:"ip"`
+	Port     int    `yaml:"port"`
+	Status   int    `yaml:"status"`
+	Tags     string `yaml:"tags"`
+	HostName string `yaml:"hostName"`
+}
+
+// 进程内的本地命名服务，与 bns agent 使用相同的协议，用于测试及离线开发
+type Server struct {
+	ln net.Listener
+
+	mu       sync.RWMutex
+	services map[string][]*ServerInstance
+
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// 启动本地命名服务，addr 为空时监听随机端口
+func NewServer(addr string) (*Server, error) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ln:       ln,
+		services: map[string][]*ServerInstance{},
+		conns:    map[net.Conn]bool{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// 返回监听地址，可作为 zns.Config 的 LocalAddr
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// 关闭服务及所有连接
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// 从yaml文件加载实例列表，格式为 服务名: [实例列表]，会覆盖文件中出现的服务
+func (s *Server) LoadFile(file string) error {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	services := map[string][]*ServerInstance{}
+	if err := yaml.Unmarshal(buf, &services); err != nil {
+		return err
+	}
+	for name, list := range services {
+		s.Set(name, list)
+	}
+	return nil
+}
+
+// 设置服务的实例列表
+func (s *Server) Set(service string, list []*ServerInstance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[service] = append([]*ServerInstance(nil), list...)
+}
+
+// 添加实例，ip:port 已存在时更新实例
+func (s *Server) Add(service string, ins ServerInstance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v := s.find(service, ins.IP, ins.Port); v != nil {
+		*v = ins
+		return
+	}
+	s.services[service] = append(s.services[service], &ins)
+}
+
+// 删除实例
+func (s *Server) Remove(service string, ip string, port int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.services[service]
+	for i, v := range list {
+		if v.IP == ip && v.Port == port {
+			s.services[service] = append(list[:i:i], list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 修改实例状态
+func (s *Server) SetStatus(service string, ip string, port int, status int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v := s.find(service, ip, port); v != nil {
+		v.Status = status
+		return true
+	}
+	return false
+}
+
+// 修改实例标签
+func (s *Server) SetTags(service string, ip string, port int, tags string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v := s.find(service, ip, port); v != nil {
+		v.Tags = tags
+		return true
+	}
+	return false
+}
+
+func (s *Server) find(service string, ip string, port int) *ServerInstance {
+	for _, v := range s.services[service] {
+		if v.IP == ip && v.Port == port {
+			return v
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+	for {
+		req, err := readRequest(rw)
+		if err != nil {
+			return
+		}
+
+		resType, reply, err := s.dispatch(req)
+		if err != nil {
+			return
+		}
+		body, err := proto.Marshal(reply)
+		if err != nil {
+			return
+		}
+		if _, err := newResponse(resType, req.Header.LogId, body).write(rw); err != nil {
+			return
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(req *request) (MsgType, proto.Message, error) {
+	switch MsgType(req.Header.Id) {
+	case ReqService:
+		args := &LocalNamingRequest{}
+		if err := proto.Unmarshal(req.Body, args); err != nil {
+			return 0, nil, err
+		}
+		return ResService, s.naming(args), nil
+	case ReqServiceList:
+		args := &LocalNamingListRequest{}
+		if err := proto.Unmarshal(req.Body, args); err != nil {
+			return 0, nil, err
+		}
+		reply := &LocalNamingListResponse{}
+		for _, v := range args.RequestList {
+			reply.NamingList = append(reply.NamingList, s.naming(v))
+		}
+		return ResServiceList, reply, nil
+	}
+	return 0, nil, fmt.Errorf("unsupported message type %d", req.Header.Id)
+}
+
+func (s *Server) naming(args *LocalNamingRequest) *LocalNamingResponse {
+	name := args.GetServiceName()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list, ok := s.services[name]
+	reply := &LocalNamingResponse{
+		ServiceName: proto.String(name),
+		Retcode:     proto.Int32(0),
+	}
+	if !ok {
+		reply.Retcode = proto.Int32(-1)
+		return reply
+	}
+
+	for _, v := range list {
+		if !args.GetAll() && v.Status != StatusOK {
+			continue
+		}
+		hostName := v.HostName
+		if hostName == "" {
+			hostName = v.IP
+		}
+		reply.InstanceInfo = append(reply.InstanceInfo, &InstanceInfo{
+			HostName:    proto.String(hostName),
+			ServiceName: proto.String(name),
+			HostIp:      proto.Uint32(util.StringIpToUInt32(v.IP)),
+			InstanceStatus: &InstanceStatus{
+				Port:   proto.Int32(int32(v.Port)),
+				Status: proto.Int32(int32(v.Status)),
+				Tags:   proto.String(v.Tags),
+			},
+		})
+	}
+	return reply
+}
+
+func readRequest(r io.Reader) (req *request, err error) {
+	req = new(request)
+	if _, err = req.Header.read(r); err != nil {
+		return nil, err
+	}
+	if req.Header.MagicNum != headerMagicNum {
+		return nil, fmt.Errorf("invalid magic number %x", req.Header.MagicNum)
+	}
+
+	req.Body = make([]byte, int(req.Header.BodyLen))
+	if _, err = io.ReadFull(r, req.Body); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func newResponse(t MsgType, logID uint32, body []byte) *response {
+	resp := new(response)
+	resp.Header.MagicNum = headerMagicNum
+	resp.Header.Id = uint16(t)
+	resp.Header.LogId = logID
+	resp.Header.BodyLen = uint32(len(body))
+	resp.Body = body
+	return resp
+}
+
+func (r *response) write(w io.Writer) (n int, err error) {
+	n, err = r.Header.write(w)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(r.Body)
+}
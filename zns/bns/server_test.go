@@ -0,0 +1,63 @@
+package bns
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func call(t *testing.T, addr string, name string) []*InstanceInfo {
+	c := New(addr, time.Second)
+	assert.NoError(t, c.Connect())
+	defer c.Close()
+
+	var rsp LocalNamingResponse
+	assert.NoError(t, c.Call(&LocalNamingRequest{ServiceName: proto.String(name), All: proto.Bool(true)}, &rsp))
+	return rsp.InstanceInfo
+}
+
+func TestServer(t *testing.T) {
+	s, err := NewServer("")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "bns")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "bns.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`
+demo.svc:
+  - ip: 10.0.0.1
+    port: 8080
+    tags: "weight:10"
+  - ip: 10.0.0.2
+    port: 8080
+    status: -1
+`), 0644))
+	assert.NoError(t, s.LoadFile(file))
+
+	list := call(t, s.Addr(), "demo.svc")
+	assert.Len(t, list, 2)
+	assert.Equal(t, uint32(10<<24|1), list[0].GetHostIp())
+	assert.Equal(t, int32(8080), list[0].InstanceStatus.GetPort())
+	assert.Equal(t, "weight:10", list[0].InstanceStatus.GetTags())
+	assert.Equal(t, int32(-1), list[1].InstanceStatus.GetStatus())
+
+	s.Add("demo.svc", ServerInstance{IP: "10.0.0.3", Port: 8081})
+	assert.True(t, s.SetStatus("demo.svc", "10.0.0.2", 8080, StatusOK))
+	assert.True(t, s.Remove("demo.svc", "10.0.0.1", 8080))
+	assert.False(t, s.Remove("demo.svc", "10.0.0.1", 8080))
+
+	list = call(t, s.Addr(), "demo.svc")
+	assert.Len(t, list, 2)
+	assert.Equal(t, int32(0), list[0].InstanceStatus.GetStatus())
+	assert.Equal(t, int32(8081), list[1].InstanceStatus.GetPort())
+
+	assert.Len(t, call(t, s.Addr(), "unknown"), 0)
+}
@@ -2,6 +2,8 @@ package util
 
 import (
 	"net"
+	"strconv"
+	"strings"
 )
 
 func UInt32IpToString(intIP uint32) string {
@@ -13,3 +15,41 @@ func UInt32IpToString(intIP uint32) string {
 
 	return net.IPv4(bytes[3], bytes[2], bytes[1], bytes[0]).String()
 }
+
+// UInt32IpToString 的逆操作，非法ip返回0
+func StringIpToUInt32(ip string) uint32 {
+	v4 := net.ParseIP(ip).To4()
+	if v4 == nil {
+		return 0
+	}
+	return uint32(v4[0])<<24 | uint32(v4[1])<<16 | uint32(v4[2])<<8 | uint32(v4[3])
+}
+
+// 解析实例标签，格式为 key1:value1,key2:value2
+func ParseTags(tags string) map[string]string {
+	m := map[string]string{}
+	for _, item := range strings.Split(tags, ",") {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if k := strings.TrimSpace(kv[0]); k != "" {
+			m[k] = strings.TrimSpace(kv[1])
+		}
+	}
+	return m
+}
+
+// 从实例标签中获取染色标记
+func GetColor(tags string) string {
+	return ParseTags(tags)["color"]
+}
+
+// 从实例标签中获取权重，未配置或非法时返回0
+func GetWeight(tags string) int {
+	w, err := strconv.Atoi(ParseTags(tags)["weight"])
+	if err != nil || w < 0 {
+		return 0
+	}
+	return w
+}
@@ -2,18 +2,22 @@ package zns
 
 import (
 	"fmt"
+	"github.com/GitHub121380/golib/metrics"
 	"github.com/GitHub121380/golib/ral"
 	"github.com/GitHub121380/golib/zlog"
 	"github.com/GitHub121380/golib/zns/bns"
 	"github.com/GitHub121380/golib/zns/util"
 	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
+	"sync"
 	"time"
 )
 
 type Instance struct {
-	IP   string
-	Port int
+	IP     string
+	Port   int
+	Weight int
+	Color  string
 }
 
 // 根据 znsName 查询有效ip:port
@@ -27,8 +31,10 @@ func GetZnsInstance(name string) (list []Instance, err error) {
 			continue
 		}
 		in := Instance{
-			IP:   util.UInt32IpToString(*value.HostIp),
-			Port: int(*value.InstanceStatus.Port),
+			IP:     util.UInt32IpToString(*value.HostIp),
+			Port:   int(*value.InstanceStatus.Port),
+			Weight: util.GetWeight(value.InstanceStatus.GetTags()),
+			Color:  util.GetColor(value.InstanceStatus.GetTags()),
 		}
 		list = append(list, in)
 	}
@@ -84,6 +90,7 @@ func Download(list []*ral.Resource) {
 			continue
 		}
 
+		setLast(s.Name, rsp.InstanceInfo)
 		if len(rsp.InstanceInfo) == 0 {
 			// 如果本次没有拿到结果，不做处理，使用上次获得的数据
 			zlog.WarnLogger(nil, "call zns get num: 0 , pls manual check. ", fields...)
@@ -100,14 +107,26 @@ func Download(list []*ral.Resource) {
 			if *value.InstanceStatus.Status == 0 {
 				ip := util.UInt32IpToString(*value.HostIp)
 				port := *value.InstanceStatus.Port
+				weight := util.GetWeight(value.InstanceStatus.GetTags())
+				color := util.GetColor(value.InstanceStatus.GetTags())
 
 				if node := fmt.Sprintf("%s:%d", ip, port); list[node] == nil {
 					// 添加主机地址
 					zlog.InfoLogger(nil, "znsService add "+s.Name+" "+node, fields...)
-					if _, err := ral.AddInstance(ral.TYPE_ZNS, s.Name, &ral.Instance{IP: ip, Port: int(port)}); err != nil {
+					if _, err := ral.AddInstance(ral.TYPE_ZNS, s.Name, &ral.Instance{IP: ip, Port: int(port), Weight: weight, Color: color}); err != nil {
 						zlog.ErrorLogger(nil, "znsService add "+s.Name+""+node+" error: "+err.Error(), fields...)
 					}
 				} else {
+					// 更新主机权重
+					if ins := list[node]; ins.Weight != weight {
+						zlog.InfoLogger(nil, fmt.Sprintf("znsService weight %s %s %d->%d", s.Name, node, ins.Weight, weight), fields...)
+						ins.SetWeight(weight)
+					}
+					// 更新主机染色标记
+					if ins := list[node]; ins.Color != color {
+						zlog.InfoLogger(nil, fmt.Sprintf("znsService color %s %s %s->%s", s.Name, node, ins.Color, color), fields...)
+						ins.SetColor(color)
+					}
 					delete(list, node)
 				}
 			}
@@ -140,6 +159,38 @@ func Refresh() {
 
 var resList []*ral.Resource
 
+// 最近一次下载结果
+type LastResult struct {
+	Time      time.Time `json:"time"`
+	Instances []string  `json:"instances"`
+}
+
+var last = map[string]LastResult{}
+var lastLock sync.RWMutex
+
+func setLast(name string, list []*bns.InstanceInfo) {
+	r := LastResult{Time: time.Now(), Instances: make([]string, 0, len(list))}
+	for _, v := range list {
+		r.Instances = append(r.Instances, fmt.Sprintf("%s:%d status:%d tags:%s", util.UInt32IpToString(v.GetHostIp()),
+			v.InstanceStatus.GetPort(), v.InstanceStatus.GetStatus(), v.InstanceStatus.GetTags()))
+	}
+
+	lastLock.Lock()
+	last[name] = r
+	lastLock.Unlock()
+}
+
+// 返回各服务最近一次下载的实例列表，用于调试接口输出
+func Last() map[string]LastResult {
+	lastLock.RLock()
+	defer lastLock.RUnlock()
+	m := make(map[string]LastResult, len(last))
+	for k, v := range last {
+		m[k] = v
+	}
+	return m
+}
+
 var mod *ral.Module
 
 func init() {
@@ -179,5 +230,8 @@ func Init(conf Config) {
 	conf.checkConf()
 	config = conf
 
+	metrics.RegisterClient("zns", conf.LocalAddr, func() interface{} {
+		return Last()
+	})
 	go Refresh()
 }
@@ -0,0 +1,62 @@
+package zns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GitHub121380/golib/ral"
+	"github.com/GitHub121380/golib/zlog"
+	"github.com/GitHub121380/golib/zns/bns"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func init() {
+	zlog.ModuleLogger = zap.NewNop()
+}
+
+func TestDownload(t *testing.T) {
+	s, err := bns.NewServer("")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	config = Config{LocalAddr: s.Addr(), Timeout: time.Second}
+	config.checkConf()
+
+	s.Add("demo.svc", bns.ServerInstance{IP: "10.0.0.1", Port: 8080, Tags: "weight:10"})
+	s.Add("demo.svc", bns.ServerInstance{IP: "10.0.0.2", Port: 8080})
+
+	// 依赖 zns 的下游资源
+	ral.AddModule(&ral.Module{
+		Type: "zns_test",
+		Append: func(self *ral.Resource, res *ral.Resource, ins *ral.Instance) (*ral.Instance, error) {
+			return &ral.Instance{IP: ins.IP, Port: ins.Port}, nil
+		},
+		Remove: func(self *ral.Instance, res *ral.Resource, ins *ral.Instance) bool {
+			return true
+		},
+	})
+	ral.AddResource(&ral.Resource{Type: "zns_test", Name: "demo", ZNS: struct {
+		Name string
+		IDC  map[string]string
+	}{Name: "demo.svc"}})
+	res := ral.AddResource(&ral.Resource{Type: ral.TYPE_ZNS, Name: "demo.svc"})
+
+	Download([]*ral.Resource{res})
+	list := ral.GetAllInstance("zns_test", "demo")
+	assert.Len(t, list, 2)
+	assert.Equal(t, 10, list[0].Weight)
+
+	ins, err := GetZnsInstance("demo.svc")
+	assert.NoError(t, err)
+	assert.Len(t, ins, 2)
+
+	// 实例下线及新增
+	s.SetStatus("demo.svc", "10.0.0.1", 8080, bns.StatusOffline)
+	s.Add("demo.svc", bns.ServerInstance{IP: "10.0.0.3", Port: 8080})
+	Download([]*ral.Resource{res})
+	list = ral.GetAllInstance("zns_test", "demo")
+	assert.Len(t, list, 2)
+	assert.Equal(t, "10.0.0.2", list[0].IP)
+	assert.Equal(t, "10.0.0.3", list[1].IP)
+}